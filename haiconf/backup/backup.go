@@ -0,0 +1,373 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backup keeps a copy of data before haiconf overwrites
+// or deletes it.
+//
+// Contents are stored once in <Dir>/contents/<sha1> and each backup
+// is described by a JSON file in <Dir>/meta/<id>.json holding the
+// original path, mode, owner, timestamp and run id.
+package backup
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/osutils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_DIR = "/var/lib/haiconf/backups"
+
+	CONTENTS_DIR = "contents"
+	META_DIR     = "meta"
+
+	// Crontabs are stored as crontab:<user> and restored with crontab(1)
+	CRONTAB_PREFIX = "crontab:"
+	CRONTAB        = "/usr/bin/crontab"
+)
+
+var (
+	crontabPath = CRONTAB
+)
+
+type Bucket struct {
+	// An empty Dir disables backups
+	Dir   string
+	RunId string
+}
+
+type Entry struct {
+	Id        string
+	Path      string
+	Mode      os.FileMode
+	Uid       int
+	Gid       int
+	Timestamp time.Time
+	RunId     string
+	Sum       string
+}
+
+func NewBucket(dir string, runId string) *Bucket {
+	return &Bucket{
+		Dir:   dir,
+		RunId: runId,
+	}
+}
+
+func (b *Bucket) Enabled() bool {
+	return b.Dir != ""
+}
+
+// StoreFile backs up the regular file at path. Nothing is stored
+// and a nil entry is returned when path does not exist.
+func (b *Bucket) StoreFile(path string) (*Entry, error) {
+	if !b.Enabled() {
+		return nil, nil
+	}
+
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, gid := -1, -1
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if ok {
+		uid = int(st.Uid)
+		gid = int(st.Gid)
+	}
+
+	return b.Store(path, buff, fi.Mode()&haiconf.MODE_MASK, uid, gid)
+}
+
+// StoreTree backs up every regular file under root.
+func (b *Bucket) StoreTree(root string) ([]*Entry, error) {
+	var entries []*Entry
+
+	if !b.Enabled() {
+		return entries, nil
+	}
+
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		e, err := b.StoreFile(p)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// Store backs up arbitrary content. path does not have to be a real
+// file path, it only has to identify the content, e.g. "crontab:root".
+// Only file paths and crontabs can be restored.
+func (b *Bucket) Store(path string, buff []byte, mode os.FileMode, uid int, gid int) (*Entry, error) {
+	if !b.Enabled() {
+		return nil, nil
+	}
+
+	sum := fmt.Sprintf("%x", sha1.Sum(buff))
+	now := time.Now()
+
+	e := &Entry{
+		Path:      path,
+		Mode:      mode,
+		Uid:       uid,
+		Gid:       gid,
+		Timestamp: now,
+		RunId:     b.RunId,
+		Sum:       sum,
+	}
+
+	idSum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%d", path, sum, now.UnixNano())))
+	e.Id = fmt.Sprintf("%x", idSum)[:12]
+
+	err := b.mkDir()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.writeContent(sum, buff)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.writeEntry(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// List returns all backups, oldest first.
+func (b *Bucket) List() ([]*Entry, error) {
+	var entries []*Entry
+
+	files, err := ioutil.ReadDir(filepath.Join(b.Dir, META_DIR))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return entries, err
+	}
+
+	for _, fi := range files {
+		if filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+
+		buff, err := ioutil.ReadFile(filepath.Join(b.Dir, META_DIR, fi.Name()))
+		if err != nil {
+			return entries, err
+		}
+
+		e := new(Entry)
+		err = json.Unmarshal(buff, e)
+		if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Sort(byTimestamp(entries))
+
+	return entries, nil
+}
+
+// Find returns the backup matching idOrPath. When a path is given
+// the most recent backup of that path is returned.
+func (b *Bucket) Find(idOrPath string) (*Entry, error) {
+	entries, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Entry
+	for _, e := range entries {
+		if e.Id == idOrPath {
+			return e, nil
+		}
+
+		if e.Path == idOrPath {
+			found = e
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("No backup found for %s", idOrPath)
+	}
+
+	return found, nil
+}
+
+func (b *Bucket) Content(e *Entry) ([]byte, error) {
+	return ioutil.ReadFile(b.contentPath(e.Sum))
+}
+
+// Restore writes the backed up content back to its original path
+// with its original mode and owner. Crontabs are installed back
+// for their user.
+func (b *Bucket) Restore(e *Entry) error {
+	if strings.HasPrefix(e.Path, CRONTAB_PREFIX) {
+		return b.restoreCrontab(e)
+	}
+
+	if !filepath.IsAbs(e.Path) {
+		return fmt.Errorf("%s is not a file and can not be restored", e.Path)
+	}
+
+	buff, err := b.Content(e)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(e.Path), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(e.Path, buff, e.Mode)
+	if err != nil {
+		return err
+	}
+
+	return haiconf.SetOwnerAndMode(haiconf.FilePath(e.Path), e.Uid, e.Gid, e.Mode)
+}
+
+func (b *Bucket) restoreCrontab(e *Entry) error {
+	username := strings.TrimPrefix(e.Path, CRONTAB_PREFIX)
+	if username == "" {
+		return fmt.Errorf("%s does not name a user", e.Path)
+	}
+
+	buff, err := b.Content(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "cron")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buff)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	sc := osutils.SystemCommand{
+		Path:                 crontabPath,
+		Args:                 []string{"-u", username, f.Name()},
+		ExecDir:              os.TempDir(),
+		EnableShellExpansion: true,
+	}
+
+	output := sc.Run()
+	if output.HasError() {
+		return output
+	}
+
+	return nil
+}
+
+// mkDir creates Dir. DEFAULT_DIR usually requires root so the error
+// tells how to use another directory instead.
+func (b *Bucket) mkDir() error {
+	err := os.MkdirAll(b.Dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Can not create backup directory %s, use -backup-dir to choose another one or -backup-dir=\"\" to disable backups: %s", b.Dir, err)
+	}
+
+	return nil
+}
+
+func (b *Bucket) writeContent(sum string, buff []byte) error {
+	p := b.contentPath(sum)
+
+	// content addressed, already stored
+	_, err := os.Stat(p)
+	if err == nil {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, buff, 0600)
+}
+
+func (b *Bucket) writeEntry(e *Entry) error {
+	dir := filepath.Join(b.Dir, META_DIR)
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	buff, err := json.MarshalIndent(e, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, e.Id+".json"), buff, 0600)
+}
+
+func (b *Bucket) contentPath(sum string) string {
+	return filepath.Join(b.Dir, CONTENTS_DIR, sum[:2], sum)
+}
+
+type byTimestamp []*Entry
+
+func (s byTimestamp) Len() int           { return len(s) }
+func (s byTimestamp) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTimestamp) Less(i, j int) bool { return s[i].Timestamp.Before(s[j].Timestamp) }
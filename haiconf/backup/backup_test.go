@@ -0,0 +1,190 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backup
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"testing"
+)
+
+// Hooks up gocheck into the gotest runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type BackupTestSuite struct {
+	b *Bucket
+}
+
+var _ = Suite(&BackupTestSuite{})
+
+func (s *BackupTestSuite) SetUpTest(c *C) {
+	s.b = NewBucket(c.MkDir(), "run-1")
+}
+
+func (s *BackupTestSuite) TestStoreFile_Disabled(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+	err := ioutil.WriteFile(tmpFile, []byte("foo"), 0640)
+	c.Assert(err, IsNil)
+
+	e, err := NewBucket("", "run-1").StoreFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(e, IsNil)
+}
+
+func (s *BackupTestSuite) TestStoreFile_DoesNotExist(c *C) {
+	e, err := s.b.StoreFile(c.MkDir() + "/foo.txt")
+	c.Assert(err, IsNil)
+	c.Assert(e, IsNil)
+}
+
+func (s *BackupTestSuite) TestStore_DirNotCreatable(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+	err := ioutil.WriteFile(tmpFile, []byte("foo"), 0640)
+	c.Assert(err, IsNil)
+
+	_, err = NewBucket(tmpFile+"/backups", "run-1").Store(tmpFile, []byte("foo"), 0640, -1, -1)
+	c.Assert(err, ErrorMatches, "Can not create backup directory .*/foo.txt/backups, use -backup-dir .*")
+}
+
+func (s *BackupTestSuite) TestStoreFile(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+	err := ioutil.WriteFile(tmpFile, []byte("foo"), 0640)
+	c.Assert(err, IsNil)
+
+	e, err := s.b.StoreFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(e.Path, Equals, tmpFile)
+	c.Assert(e.Mode, Equals, os.FileMode(0640))
+	c.Assert(e.RunId, Equals, "run-1")
+	c.Assert(e.Uid, Equals, os.Getuid())
+	c.Assert(e.Gid, Equals, os.Getgid())
+
+	buff, err := s.b.Content(e)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "foo")
+
+	entries, err := s.b.List()
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 1)
+	c.Assert(entries[0].Id, Equals, e.Id)
+}
+
+func (s *BackupTestSuite) TestStoreTree(c *C) {
+	root := c.MkDir()
+	err := os.MkdirAll(root+"/a/b", 0755)
+	c.Assert(err, IsNil)
+
+	for _, f := range []string{"/foo.txt", "/a/bar.txt", "/a/b/baz.txt"} {
+		err = ioutil.WriteFile(root+f, []byte(f), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	entries, err := s.b.StoreTree(root)
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 3)
+}
+
+func (s *BackupTestSuite) TestFind(c *C) {
+	_, err := s.b.Store("/foo", []byte("1"), 0644, -1, -1)
+	c.Assert(err, IsNil)
+
+	e2, err := s.b.Store("/foo", []byte("2"), 0644, -1, -1)
+	c.Assert(err, IsNil)
+
+	e3, err := s.b.Store("/bar", []byte("3"), 0644, -1, -1)
+	c.Assert(err, IsNil)
+
+	obtained, err := s.b.Find("/foo")
+	c.Assert(err, IsNil)
+	c.Assert(obtained.Id, Equals, e2.Id)
+
+	obtained, err = s.b.Find(e3.Id)
+	c.Assert(err, IsNil)
+	c.Assert(obtained.Path, Equals, "/bar")
+
+	_, err = s.b.Find("/unknown")
+	c.Assert(err, NotNil)
+}
+
+func (s *BackupTestSuite) TestRestore(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+	err := ioutil.WriteFile(tmpFile, []byte("original"), 0600)
+	c.Assert(err, IsNil)
+
+	e, err := s.b.StoreFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(tmpFile, []byte("broken"), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.b.Restore(e)
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "original")
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *BackupTestSuite) TestRestore_SpecialBits(c *C) {
+	tmpFile := c.MkDir() + "/foo.sh"
+	err := ioutil.WriteFile(tmpFile, []byte("original"), 0755)
+	c.Assert(err, IsNil)
+
+	mode := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid
+	err = os.Chmod(tmpFile, mode)
+	c.Assert(err, IsNil)
+
+	e, err := s.b.StoreFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(e.Mode, Equals, mode)
+
+	err = os.Remove(tmpFile)
+	c.Assert(err, IsNil)
+
+	err = s.b.Restore(e)
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, mode)
+}
+
+func (s *BackupTestSuite) TestRestore_NotAFile(c *C) {
+	e, err := s.b.Store("foo", []byte("foo"), 0600, 0, 0)
+	c.Assert(err, IsNil)
+
+	err = s.b.Restore(e)
+	c.Assert(err, NotNil)
+}
+
+func (s *BackupTestSuite) TestRestore_Crontab(c *C) {
+	dir := c.MkDir()
+
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncp \"$3\" " + dir + "/installed\n"
+	err := ioutil.WriteFile(dir+"/crontab", []byte(script), 0755)
+	c.Assert(err, IsNil)
+
+	crontabPath = dir + "/crontab"
+	defer func() { crontabPath = CRONTAB }()
+
+	e, err := s.b.Store(CRONTAB_PREFIX+"nobody", []byte("* * * * * foo\n"), 0600, 0, 0)
+	c.Assert(err, IsNil)
+
+	err = s.b.Restore(e)
+	c.Assert(err, IsNil)
+
+	args, err := ioutil.ReadFile(dir + "/args")
+	c.Assert(err, IsNil)
+	c.Assert(string(args), Matches, "-u nobody .*\n")
+
+	installed, err := ioutil.ReadFile(dir + "/installed")
+	c.Assert(err, IsNil)
+	c.Assert(string(installed), Equals, "* * * * * foo\n")
+}
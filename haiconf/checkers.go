@@ -6,6 +6,7 @@ package haiconf
 
 import (
 	"github.com/jeromer/haiconf/hacks"
+	"os"
 	"os/user"
 	"path"
 	"strconv"
@@ -31,7 +32,7 @@ func CheckAbsolutePath(k string, args CommandArgs) (string, error) {
 }
 
 func CheckInt64(k string, args CommandArgs) (int64, error) {
-	mStr, _ := args["Mode"].(string)
+	mStr, _ := args[k].(string)
 
 	if mStr == "" {
 		return 0, NewArgError(k+" must be provided", args)
@@ -40,22 +41,75 @@ func CheckInt64(k string, args CommandArgs) (int64, error) {
 	return strconv.ParseInt(mStr, 8, 0)
 }
 
+func CheckFileMode(k string, args CommandArgs) (os.FileMode, error) {
+	s, _ := args[k].(string)
+
+	if s == "" {
+		return 0, NewArgError(k+" must be provided", args)
+	}
+
+	m, err := ParseFileMode(s)
+	if err != nil {
+		return 0, NewArgError("Invalid "+k+" : "+err.Error(), args)
+	}
+
+	return m, nil
+}
+
+// CheckSystemUser accepts a user name or a numeric UID. UIDs
+// which are not known yet are accepted as is.
 func CheckSystemUser(k string, args CommandArgs) (*user.User, error) {
-	o, _ := args[k].(string)
+	o := checkId(args[k])
 	if o == "" {
 		return nil, NewArgError(k+" must be defined", args)
 	}
 
-	return user.Lookup(o)
+	u, err := user.Lookup(o)
+	if err == nil || !isNumericId(o) {
+		return u, err
+	}
+
+	u, err = user.LookupId(o)
+	if err != nil {
+		return &user.User{Uid: o, Username: o}, nil
+	}
+
+	return u, nil
 }
 
+// CheckSystemGroup accepts a group name or a numeric GID. GIDs
+// which are not known yet are accepted as is.
 func CheckSystemGroup(k string, args CommandArgs) (*hacks.Group, error) {
-	g, _ := args[k].(string)
+	g := checkId(args[k])
 	if g == "" {
 		return nil, NewArgError(k+" must be defined", args)
 	}
 
-	return hacks.LookupSystemGroup(g)
+	grp, err := hacks.LookupSystemGroup(g)
+	if err == nil || !isNumericId(g) {
+		return grp, err
+	}
+
+	return &hacks.Group{Gid: g, Name: g}, nil
+}
+
+// lua numbers are received as float64
+func checkId(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatInt(int64(t), 10)
+	case int:
+		return strconv.Itoa(t)
+	}
+
+	return ""
+}
+
+func isNumericId(s string) bool {
+	id, err := strconv.Atoi(s)
+	return err == nil && id >= 0
 }
 
 func CheckBool(k string, args CommandArgs) bool {
@@ -6,6 +6,7 @@ package haiconf
 
 import (
 	. "launchpad.net/gocheck"
+	"os"
 	"testing"
 )
 
@@ -107,6 +108,12 @@ func (s *CheckersTestSuite) TestCheckInt64_Provided(c *C) {
 	c.Assert(m, Equals, int64(0750))
 }
 
+func (s *CheckersTestSuite) TestCheckInt64_OtherKey(c *C) {
+	m, err := CheckInt64("DirMode", CommandArgs{"Mode": "0640", "DirMode": "0750"})
+	c.Assert(err, IsNil)
+	c.Assert(m, Equals, int64(0750))
+}
+
 func (s *CheckersTestSuite) TestCheckString_Empty(c *C) {
 	p, err := CheckString("String", CommandArgs{})
 	c.Assert(err, ErrorMatches, "String must be provided(.*)")
@@ -135,3 +142,33 @@ func (s *CheckersTestSuite) TestCheckStringList_NonEmpty_Interface(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(p, DeepEquals, []string{"foo"})
 }
+
+func (s *CheckersTestSuite) TestCheckSystemUser_NumericId(c *C) {
+	o, err := CheckSystemUser("Owner", CommandArgs{"Owner": "0"})
+	c.Assert(err, IsNil)
+	c.Assert(o.Uid, Equals, "0")
+
+	// lua numbers, unknown to NSS
+	o, err = CheckSystemUser("Owner", CommandArgs{"Owner": float64(54321)})
+	c.Assert(err, IsNil)
+	c.Assert(o.Uid, Equals, "54321")
+	c.Assert(o.Username, Equals, "54321")
+}
+
+func (s *CheckersTestSuite) TestCheckSystemGroup_NumericId(c *C) {
+	g, err := CheckSystemGroup("Group", CommandArgs{"Group": "54321"})
+	c.Assert(err, IsNil)
+	c.Assert(g.Gid, Equals, "54321")
+}
+
+func (s *CheckersTestSuite) TestCheckFileMode(c *C) {
+	m, err := CheckFileMode("Mode", CommandArgs{"Mode": "u=rw,g=r,o="})
+	c.Assert(err, IsNil)
+	c.Assert(m, Equals, os.FileMode(0640))
+
+	_, err = CheckFileMode("Mode", CommandArgs{"Mode": "u=rwz"})
+	c.Assert(err, ErrorMatches, "Invalid Mode : (.*)")
+
+	_, err = CheckFileMode("Mode", CommandArgs{})
+	c.Assert(err, ErrorMatches, "Mode must be provided(.*)")
+}
@@ -31,6 +31,7 @@ package cron
 
 import (
 	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
 	"github.com/jeromer/haiconf/haiconf/utils"
 	"os/user"
 )
@@ -107,13 +108,22 @@ func (c *Cron) Run() error {
 	}
 
 	ct := NewCrontab(c.Owner)
+	ct.Bucket = backup.NewBucket(c.rc.BackupDir, c.rc.RunId)
 
 	if c.Ensure == haiconf.ENSURE_PRESENT {
 		haiconf.Output(c.rc, "Adding cronjob %s for user %s", cj.Command, c.Owner.Username)
+		if c.rc.DryRun {
+			return nil
+		}
+
 		return ct.Add(cj)
 	}
 
 	haiconf.Output(c.rc, "Removing cronjob %s for user %s", cj.Command, c.Owner.Username)
+	if c.rc.DryRun {
+		return nil
+	}
+
 	return ct.Remove(cj)
 }
 
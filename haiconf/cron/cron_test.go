@@ -7,6 +7,7 @@ package cron
 import (
 	"github.com/jeromer/haiconf/haiconf"
 	"github.com/jeromer/haiconf/haiconf/osutils"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
 	"os/user"
@@ -223,6 +224,25 @@ func (s *CronTestSuite) TestRun_EnsureAbsent(c *C) {
 	c.Assert(obtained, DeepEquals, expected)
 }
 
+func (s *CronTestSuite) TestRun_DryRun(c *C) {
+	u, err := user.Current()
+	c.Assert(err, IsNil)
+
+	s.c.SetDefault(&haiconf.RuntimeConfig{DryRun: true, Output: ioutil.Discard})
+	err = s.c.SetUserConfig(haiconf.CommandArgs{
+		"Command": "/foo/bar",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Schedule": map[string]interface{}{
+			"Predefined": "daily",
+		},
+		"Owner": u.Username,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.c.Run()
+	c.Assert(err, IsNil)
+}
+
 func (s *CronTestSuite) cleanCrontab(c *C) {
 	u, err := user.Current()
 	c.Assert(err, IsNil)
@@ -6,16 +6,21 @@ package cron
 
 import (
 	"fmt"
+	"github.com/jeromer/haiconf/haiconf/backup"
 	"github.com/jeromer/haiconf/haiconf/osutils"
 	"io/ioutil"
 	"os"
 	"os/user"
+	"strconv"
 	"strings"
 )
 
 type Crontab struct {
 	Path string
 	User *user.User
+
+	// When set, the current crontab is backed up before being replaced
+	Bucket *backup.Bucket
 }
 
 func NewCrontab(u *user.User) *Crontab {
@@ -53,28 +58,13 @@ func (c *Crontab) Remove(cj Cronjob) error {
 }
 
 func (c *Crontab) Read() ([]Cronjob, error) {
-	sc := osutils.SystemCommand{
-		Path:                 c.Path,
-		Args:                 []string{"-u", c.User.Username, "-l"},
-		ExecDir:              os.TempDir(),
-		EnableShellExpansion: true,
-	}
-
-	output := sc.Run()
-	if output.HasError() {
-		nilCronjobs := []Cronjob{}
-		noCrontab := fmt.Sprintf("no crontab for %s", c.User.Username)
-		stdErr := strings.Trim(strings.TrimSpace(output.Stderr), "\n")
-
-		if stdErr == noCrontab {
-			return nilCronjobs, nil
-		}
-
-		return nilCronjobs, output
+	content, err := c.readRaw()
+	if err != nil {
+		return []Cronjob{}, err
 	}
 
 	cp := CrontabParser{
-		Buff: []byte(output.Stdout),
+		Buff: []byte(content),
 	}
 
 	cronjobs, err := cp.Parse()
@@ -86,6 +76,11 @@ func (c *Crontab) Read() ([]Cronjob, error) {
 }
 
 func (c *Crontab) Save(cronjobs []Cronjob) error {
+	err := c.backup()
+	if err != nil {
+		return err
+	}
+
 	fileName, err := c.writeTmpCrontab(cronjobs)
 	if err != nil {
 		return err
@@ -109,6 +104,57 @@ func (c *Crontab) Save(cronjobs []Cronjob) error {
 	return nil
 }
 
+func (c *Crontab) readRaw() (string, error) {
+	sc := osutils.SystemCommand{
+		Path:                 c.Path,
+		Args:                 []string{"-u", c.User.Username, "-l"},
+		ExecDir:              os.TempDir(),
+		EnableShellExpansion: true,
+	}
+
+	output := sc.Run()
+	if output.HasError() {
+		noCrontab := fmt.Sprintf("no crontab for %s", c.User.Username)
+		stdErr := strings.Trim(strings.TrimSpace(output.Stderr), "\n")
+
+		if stdErr == noCrontab {
+			return "", nil
+		}
+
+		return "", output
+	}
+
+	return output.Stdout, nil
+}
+
+func (c *Crontab) backup() error {
+	if c.Bucket == nil || !c.Bucket.Enabled() {
+		return nil
+	}
+
+	content, err := c.readRaw()
+	if err != nil {
+		return err
+	}
+
+	if content == "" {
+		return nil
+	}
+
+	uid, err := strconv.Atoi(c.User.Uid)
+	if err != nil {
+		return err
+	}
+
+	gid, err := strconv.Atoi(c.User.Gid)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Bucket.Store(backup.CRONTAB_PREFIX+c.User.Username, []byte(content), 0600, uid, gid)
+	return err
+}
+
 func (c *Crontab) RemoveDuplicates(cronjobs []Cronjob) []Cronjob {
 	uniques := []Cronjob{}
 	for _, cronjob := range c.buildCronIndex(cronjobs) {
@@ -0,0 +1,162 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package haiconf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// permissions and special bits, the mode type is ignored
+	MODE_MASK = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+)
+
+// Chowner is implemented by *os.File and by FilePath
+type Chowner interface {
+	Chown(uid int, gid int) error
+	Chmod(mode os.FileMode) error
+}
+
+// FilePath changes the owner and mode of a file which is not open
+type FilePath string
+
+func (p FilePath) Chown(uid int, gid int) error {
+	return os.Chown(string(p), uid, gid)
+}
+
+func (p FilePath) Chmod(mode os.FileMode) error {
+	return os.Chmod(string(p), mode)
+}
+
+// SetOwnerAndMode changes the owner and group of f, unless uid and gid
+// are both -1, then its mode. chown(2) clears the setuid and setgid
+// bits of regular files so the mode always has to be set last.
+func SetOwnerAndMode(f Chowner, uid int, gid int, mode os.FileMode) error {
+	if uid >= 0 || gid >= 0 {
+		err := f.Chown(uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return f.Chmod(mode)
+}
+
+// ParseFileMode reads an octal mode such as "0644" or "4755", or a
+// symbolic mode such as "u=rw,g=r,o=" or "a=rx,u+w,g+s". Symbolic
+// modes are absolute, they are applied to a mode of 0 so they never
+// depend on the current mode of a file nor on the process umask.
+func ParseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, fmt.Errorf("Empty mode")
+	}
+
+	if strings.Trim(s, "01234567") == "" {
+		m, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			return 0, err
+		}
+
+		if m > 07777 {
+			return 0, fmt.Errorf("Invalid mode %s", s)
+		}
+
+		return UnixToFileMode(uint32(m)), nil
+	}
+
+	var m uint32
+
+	for _, clause := range strings.Split(s, ",") {
+		var err error
+
+		m, err = applySymbolicClause(m, clause)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return UnixToFileMode(m), nil
+}
+
+// UnixToFileMode converts a mode as used by chmod(2) into an os.FileMode,
+// which uses different bits for setuid, setgid and sticky.
+func UnixToFileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+func applySymbolicClause(m uint32, clause string) (uint32, error) {
+	opIdx := strings.IndexAny(clause, "=+-")
+	if opIdx < 0 {
+		return 0, fmt.Errorf("Invalid symbolic mode %s", clause)
+	}
+
+	var who uint32
+	for _, c := range clause[:opIdx] {
+		switch c {
+		case 'u':
+			who |= 04700
+		case 'g':
+			who |= 02070
+		case 'o':
+			who |= 01007
+		case 'a':
+			who |= 07777
+		default:
+			return 0, fmt.Errorf("Invalid symbolic mode %s", clause)
+		}
+	}
+
+	if who == 0 {
+		who = 07777
+	}
+
+	var perms uint32
+	for _, c := range clause[opIdx+1:] {
+		switch c {
+		case 'r':
+			perms |= 0444
+		case 'w':
+			perms |= 0222
+		case 'x':
+			perms |= 0111
+		case 's':
+			perms |= 06000
+		case 't':
+			perms |= 01000
+		default:
+			return 0, fmt.Errorf("Invalid symbolic mode %s", clause)
+		}
+	}
+
+	perms &= who
+
+	switch clause[opIdx] {
+	case '=':
+		m = (m &^ who) | perms
+	case '+':
+		m |= perms
+	case '-':
+		m &^= perms
+	}
+
+	return m, nil
+}
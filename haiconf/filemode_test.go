@@ -0,0 +1,83 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package haiconf
+
+import (
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type FileModeTestSuite struct{}
+
+var _ = Suite(&FileModeTestSuite{})
+
+func (s *FileModeTestSuite) TestParseFileMode_Octal(c *C) {
+	tests := map[string]os.FileMode{
+		"0644": 0644,
+		"755":  0755,
+		"4755": 0755 | os.ModeSetuid,
+		"2775": 0775 | os.ModeSetgid,
+		"1777": 0777 | os.ModeSticky,
+	}
+
+	for s, expected := range tests {
+		m, err := ParseFileMode(s)
+		c.Assert(err, IsNil)
+		c.Assert(m, Equals, expected)
+	}
+}
+
+func (s *FileModeTestSuite) TestParseFileMode_Symbolic(c *C) {
+	tests := map[string]os.FileMode{
+		"u=rw,g=r,o=":  0640,
+		"a=rx,u+w":     0755,
+		"ug=rwx,o=rx":  0775,
+		"a=rwx,o-w":    0775,
+		"u=rwx,g=rxs":  0750 | os.ModeSetgid,
+		"u=rwxs,go=rx": 0755 | os.ModeSetuid,
+		"a=rwxt":       0777 | os.ModeSticky,
+		"=r":           0444,
+		"u=rw,go=,o+s": 0600,
+	}
+
+	for s, expected := range tests {
+		m, err := ParseFileMode(s)
+		c.Assert(err, IsNil, Commentf(s))
+		c.Assert(m, Equals, expected, Commentf(s))
+	}
+}
+
+func (s *FileModeTestSuite) TestParseFileMode_Invalid(c *C) {
+	for _, mode := range []string{"", "9644", "17777", "u=rwz", "z=rw", "rw"} {
+		_, err := ParseFileMode(mode)
+		c.Assert(err, NotNil, Commentf(mode))
+	}
+}
+
+func (s *FileModeTestSuite) TestSetOwnerAndMode(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("changing the owner of a file requires root")
+	}
+
+	f, err := ioutil.TempFile(c.MkDir(), "tool")
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	mode := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid
+
+	// both through the file descriptor and the path
+	for _, ch := range []Chowner{f, FilePath(f.Name())} {
+		err = SetOwnerAndMode(ch, 65534, 65534, mode)
+		c.Assert(err, IsNil)
+
+		fi, err := os.Stat(f.Name())
+		c.Assert(err, IsNil)
+		c.Assert(fi.Mode()&MODE_MASK, Equals, mode)
+
+		err = os.Chown(f.Name(), 0, 0)
+		c.Assert(err, IsNil)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file, for File and Directory
+//
+// File({
+//     -- optional, other extended attributes are left untouched
+//     Xattrs     = {["user.origin"] = "haiconf"},
+//
+//     -- optional, inode flags are left untouched when missing
+//     Immutable  = true,
+//     AppendOnly = false,
+// })
+//
+// Setting Immutable or AppendOnly requires CAP_LINUX_IMMUTABLE.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Attributes holds the extended attributes and inode flags managed on
+// files and directories.
+type Attributes struct {
+	Xattrs     map[string]string
+	Immutable  bool
+	AppendOnly bool
+
+	// flags are only managed when Immutable or AppendOnly is given
+	manageFlags bool
+}
+
+// withAttributes runs change, which modifies p, after removing the
+// immutable and append only flags which would make it fail. Xattrs are
+// then applied and the flags restored, or set as configured.
+func (a *Attributes) withAttributes(rc *haiconf.RuntimeConfig, p string, change func() error) error {
+	flags, err := getFileFlags(p)
+	if err != nil && a.manageFlags && !os.IsNotExist(err) {
+		return err
+	}
+
+	locked, err := unlockFile(rc, p)
+	if err != nil {
+		return err
+	}
+
+	err = change()
+	if err != nil {
+		// best effort, the original error matters more
+		if locked != 0 {
+			setFileFlags(p, flags)
+		}
+
+		return err
+	}
+
+	err = a.applyXattrs(rc, p)
+	if err != nil {
+		return err
+	}
+
+	return a.applyFlags(rc, p, locked)
+}
+
+// unlockFile clears the immutable and append only flags of p and
+// returns those which were set. Missing files and file systems
+// without flags have nothing to clear.
+func unlockFile(rc *haiconf.RuntimeConfig, p string) (int, error) {
+	flags, err := getFileFlags(p)
+	if err != nil {
+		return 0, nil
+	}
+
+	locked := flags & (FS_IMMUTABLE_FL | FS_APPEND_FL)
+	if locked == 0 {
+		return 0, nil
+	}
+
+	haiconf.Output(rc, "Clearing flags %s on %s", flagsString(locked), p)
+	return locked, setFileFlags(p, flags&^locked)
+}
+
+// attributesDiffer tells whether Xattrs or managed flags differ from
+// those of p
+func (a *Attributes) attributesDiffer(p string) (bool, error) {
+	for _, k := range a.xattrKeys() {
+		v, found, err := getXattr(p, k)
+		if err != nil {
+			return false, err
+		}
+
+		if !found || v != a.Xattrs[k] {
+			return true, nil
+		}
+	}
+
+	if !a.manageFlags {
+		return false, nil
+	}
+
+	flags, err := getFileFlags(p)
+	if err != nil {
+		return false, err
+	}
+
+	return flags&(FS_IMMUTABLE_FL|FS_APPEND_FL) != a.wantedFlags(), nil
+}
+
+// outputAttributes shows what would be done in dry-run mode
+func (a *Attributes) outputAttributes(rc *haiconf.RuntimeConfig, p string) {
+	for _, k := range a.xattrKeys() {
+		haiconf.Output(rc, "Setting extended attribute %s on %s", k, p)
+	}
+
+	if a.manageFlags {
+		haiconf.Output(rc, "Setting flags %s on %s", flagsString(a.wantedFlags()), p)
+	}
+}
+
+func (a *Attributes) applyXattrs(rc *haiconf.RuntimeConfig, p string) error {
+	for _, k := range a.xattrKeys() {
+		v, found, err := getXattr(p, k)
+		if err != nil {
+			return err
+		}
+
+		if found && v == a.Xattrs[k] {
+			continue
+		}
+
+		haiconf.Output(rc, "Setting extended attribute %s on %s", k, p)
+		err = setXattr(p, k, a.Xattrs[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyFlags sets the managed flags, or restores the previous ones.
+// A rewritten file is a new inode which has lost its previous flags.
+func (a *Attributes) applyFlags(rc *haiconf.RuntimeConfig, p string, previous int) error {
+	wanted := previous
+	if a.manageFlags {
+		wanted = a.wantedFlags()
+	}
+
+	if wanted == 0 && !a.manageFlags {
+		return nil
+	}
+
+	flags, err := getFileFlags(p)
+	if err != nil {
+		return err
+	}
+
+	current := flags & (FS_IMMUTABLE_FL | FS_APPEND_FL)
+	if current == wanted {
+		return nil
+	}
+
+	haiconf.Output(rc, "Setting flags %s on %s", flagsString(wanted), p)
+	return setFileFlags(p, flags&^current|wanted)
+}
+
+func (a *Attributes) wantedFlags() int {
+	flags := 0
+
+	if a.Immutable {
+		flags |= FS_IMMUTABLE_FL
+	}
+
+	if a.AppendOnly {
+		flags |= FS_APPEND_FL
+	}
+
+	return flags
+}
+
+// xattrKeys returns sorted keys so changes are applied
+// and displayed in a stable order
+func (a *Attributes) xattrKeys() []string {
+	keys := make([]string, 0, len(a.Xattrs))
+	for k := range a.Xattrs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func flagsString(flags int) string {
+	names := []string{}
+
+	if flags&FS_IMMUTABLE_FL != 0 {
+		names = append(names, "immutable")
+	}
+
+	if flags&FS_APPEND_FL != 0 {
+		names = append(names, "append-only")
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (a *Attributes) setAttributes(args haiconf.CommandArgs) error {
+	err := a.setXattrs(args)
+	if err != nil {
+		return err
+	}
+
+	_, immutable := args["Immutable"]
+	_, appendOnly := args["AppendOnly"]
+
+	a.manageFlags = immutable || appendOnly
+	a.Immutable = haiconf.CheckBool("Immutable", args)
+	a.AppendOnly = haiconf.CheckBool("AppendOnly", args)
+
+	return nil
+}
+
+func (a *Attributes) setXattrs(args haiconf.CommandArgs) error {
+	v, present := args["Xattrs"]
+	if !present {
+		return nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return haiconf.NewArgError("Xattrs must be a table", args)
+	}
+
+	a.Xattrs = make(map[string]string, len(m))
+
+	for k, v := range m {
+		// extended attributes are namespaced, as in user.foo
+		idx := strings.Index(k, ".")
+		if idx <= 0 || idx == len(k)-1 {
+			return haiconf.NewArgError("Invalid extended attribute name "+k, args)
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			return haiconf.NewArgError("Extended attribute "+k+" must be a string", args)
+		}
+
+		a.Xattrs[k] = s
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// from linux/fs.h
+const (
+	FS_IMMUTABLE_FL = 0x00000010
+	FS_APPEND_FL    = 0x00000020
+)
+
+// FS_IOC_GETFLAGS and FS_IOC_SETFLAGS are declared with a long
+// argument, the kernel reads and writes an int though.
+var (
+	fsIocGetFlags = ioc(2, 'f', 1, unsafe.Sizeof(uintptr(0)))
+	fsIocSetFlags = ioc(1, 'f', 2, unsafe.Sizeof(uintptr(0)))
+)
+
+func ioc(dir uintptr, t uintptr, nr uintptr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | t<<8 | nr
+}
+
+func getFileFlags(p string) (int, error) {
+	var flags int32
+
+	err := fileFlagsIoctl(p, fsIocGetFlags, &flags)
+	return int(flags), err
+}
+
+func setFileFlags(p string, flags int) error {
+	f := int32(flags)
+	return fileFlagsIoctl(p, fsIocSetFlags, &f)
+}
+
+func fileFlagsIoctl(p string, req uintptr, flags *int32) error {
+	// O_NONBLOCK so opening a fifo does not hang
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(flags)))
+	if errno != 0 {
+		return &os.PathError{Op: "ioctl", Path: p, Err: errno}
+	}
+
+	return nil
+}
+
+// getXattr returns the value of the extended attribute k of p
+// and whether it is set
+func getXattr(p string, k string) (string, bool, error) {
+	buff := make([]byte, 256)
+
+	for {
+		n, err := syscall.Getxattr(p, k, buff)
+
+		switch err {
+		case nil:
+			return string(buff[:n]), true, nil
+		case syscall.ENODATA:
+			return "", false, nil
+		case syscall.ERANGE:
+			// the value is larger than the buffer, ask for its size
+			n, err = syscall.Getxattr(p, k, nil)
+			if err != nil {
+				return "", false, &os.PathError{Op: "getxattr", Path: p, Err: err}
+			}
+
+			buff = make([]byte, n)
+			continue
+		}
+
+		return "", false, &os.PathError{Op: "getxattr", Path: p, Err: err}
+	}
+}
+
+func setXattr(p string, k string, v string) error {
+	err := syscall.Setxattr(p, k, []byte(v), 0)
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: p, Err: err}
+	}
+
+	return nil
+}
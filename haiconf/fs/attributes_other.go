@@ -0,0 +1,35 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package fs
+
+import (
+	"errors"
+)
+
+const (
+	FS_IMMUTABLE_FL = 0x00000010
+	FS_APPEND_FL    = 0x00000020
+)
+
+var errAttributesNotSupported = errors.New("Extended attributes and inode flags are only supported on Linux")
+
+func getFileFlags(p string) (int, error) {
+	return 0, errAttributesNotSupported
+}
+
+func setFileFlags(p string, flags int) error {
+	return errAttributesNotSupported
+}
+
+func getXattr(p string, k string) (string, bool, error) {
+	return "", false, errAttributesNotSupported
+}
+
+func setXattr(p string, k string, v string) error {
+	return errAttributesNotSupported
+}
@@ -0,0 +1,283 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type AttributesTestSuite struct {
+	dir string
+}
+
+var _ = Suite(&AttributesTestSuite{})
+
+func (s *AttributesTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+// TearDownTest unlocks what tests locked, the
+// temporary directory could not be removed otherwise
+func (s *AttributesTestSuite) TearDownTest(c *C) {
+	for _, p := range []string{s.dir + "/file", s.dir + "/dir"} {
+		unlockFile(&dummyRuntimeConfig, p)
+	}
+}
+
+// skipWithoutFlags skips tests on file systems without inode flags
+// or when CAP_LINUX_IMMUTABLE is missing
+func (s *AttributesTestSuite) skipWithoutFlags(c *C) {
+	p := s.dir + "/probe"
+	err := ioutil.WriteFile(p, []byte{}, 0644)
+	c.Assert(err, IsNil)
+	defer os.Remove(p)
+
+	flags, err := getFileFlags(p)
+	if err != nil {
+		c.Skip(err.Error())
+	}
+
+	err = setFileFlags(p, flags|FS_IMMUTABLE_FL)
+	if err != nil {
+		c.Skip(err.Error())
+	}
+
+	setFileFlags(p, flags)
+}
+
+func (s *AttributesTestSuite) skipWithoutXattrs(c *C) {
+	p := s.dir + "/probe"
+	err := ioutil.WriteFile(p, []byte{}, 0644)
+	c.Assert(err, IsNil)
+	defer os.Remove(p)
+
+	err = setXattr(p, "user.haiconf", "probe")
+	if err != nil {
+		c.Skip(err.Error())
+	}
+}
+
+func assertFlags(c *C, p string, expected int) {
+	flags, err := getFileFlags(p)
+	c.Assert(err, IsNil)
+	c.Assert(flags&(FS_IMMUTABLE_FL|FS_APPEND_FL), Equals, expected)
+}
+
+func assertFileContent(c *C, p string, expected string) {
+	buff, err := ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, expected)
+}
+
+func (s *AttributesTestSuite) runFile(c *C, args haiconf.CommandArgs) {
+	f := new(File)
+	f.SetDefault(&dummyRuntimeConfig)
+
+	args["Path"] = s.dir + "/file"
+	if _, present := args["Ensure"]; !present {
+		args["Ensure"] = haiconf.ENSURE_PRESENT
+	}
+
+	if _, present := args["Mode"]; !present {
+		args["Mode"] = "0644"
+	}
+
+	err := f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = f.Run()
+	c.Assert(err, IsNil)
+}
+
+func (s *AttributesTestSuite) TestSetAttributes(c *C) {
+	a := new(Attributes)
+
+	err := a.setAttributes(haiconf.CommandArgs{})
+	c.Assert(err, IsNil)
+	c.Assert(a.manageFlags, Equals, false)
+	c.Assert(a.Xattrs, IsNil)
+
+	err = a.setAttributes(haiconf.CommandArgs{
+		"Xattrs":    map[string]interface{}{"user.origin": "haiconf"},
+		"Immutable": false,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(a.manageFlags, Equals, true)
+	c.Assert(a.wantedFlags(), Equals, 0)
+	c.Assert(a.Xattrs, DeepEquals, map[string]string{"user.origin": "haiconf"})
+
+	err = a.setAttributes(haiconf.CommandArgs{
+		"Immutable":  true,
+		"AppendOnly": true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(a.wantedFlags(), Equals, FS_IMMUTABLE_FL|FS_APPEND_FL)
+	c.Assert(flagsString(a.wantedFlags()), Equals, "immutable,append-only")
+}
+
+func (s *AttributesTestSuite) TestSetAttributes_InvalidXattrs(c *C) {
+	a := new(Attributes)
+
+	err := a.setAttributes(haiconf.CommandArgs{
+		"Xattrs": []interface{}{"user.origin"},
+	})
+	c.Assert(err, ErrorMatches, "Xattrs must be a table(.*)")
+
+	for _, k := range []string{"origin", ".origin", "user."} {
+		err = a.setAttributes(haiconf.CommandArgs{
+			"Xattrs": map[string]interface{}{k: "haiconf"},
+		})
+		c.Assert(err, ErrorMatches, "Invalid extended attribute name "+k+"(.*)")
+	}
+
+	err = a.setAttributes(haiconf.CommandArgs{
+		"Xattrs": map[string]interface{}{"user.count": float64(1)},
+	})
+	c.Assert(err, ErrorMatches, "Extended attribute user.count must be a string(.*)")
+}
+
+func (s *AttributesTestSuite) TestFile_Immutable(c *C) {
+	s.skipWithoutFlags(c)
+	p := s.dir + "/file"
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content":   "v1",
+		"Immutable": true,
+	})
+	assertFlags(c, p, FS_IMMUTABLE_FL)
+
+	// rewritten with the flag cleared then restored
+	s.runFile(c, haiconf.CommandArgs{
+		"Content": "v2",
+		"Mode":    "0600",
+	})
+	assertFlags(c, p, FS_IMMUTABLE_FL)
+	assertFileContent(c, p, "v2")
+	assertMode(c, p, 0600)
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content":   "v2",
+		"Mode":      "0600",
+		"Immutable": false,
+	})
+	assertFlags(c, p, 0)
+}
+
+func (s *AttributesTestSuite) TestFile_AppendOnly(c *C) {
+	s.skipWithoutFlags(c)
+	p := s.dir + "/file"
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content":    "v1",
+		"AppendOnly": true,
+	})
+	assertFlags(c, p, FS_APPEND_FL)
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content":    "v2",
+		"AppendOnly": true,
+	})
+	assertFlags(c, p, FS_APPEND_FL)
+	assertFileContent(c, p, "v2")
+}
+
+func (s *AttributesTestSuite) TestFile_AbsentImmutable(c *C) {
+	s.skipWithoutFlags(c)
+	p := s.dir + "/file"
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content":   "v1",
+		"Immutable": true,
+	})
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+
+	_, err := os.Stat(p)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *AttributesTestSuite) TestFile_Xattrs(c *C) {
+	s.skipWithoutXattrs(c)
+	p := s.dir + "/file"
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content": "v1",
+		"Xattrs":  map[string]interface{}{"user.origin": "haiconf"},
+	})
+
+	v, found, err := getXattr(p, "user.origin")
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(v, Equals, "haiconf")
+
+	err = setXattr(p, "user.other", "kept")
+	c.Assert(err, IsNil)
+
+	s.runFile(c, haiconf.CommandArgs{
+		"Content": "v1",
+		"Xattrs":  map[string]interface{}{"user.origin": "puppet"},
+	})
+
+	v, _, err = getXattr(p, "user.origin")
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "puppet")
+
+	v, _, err = getXattr(p, "user.other")
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "kept")
+
+	_, found, err = getXattr(p, "user.missing")
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+}
+
+func (s *AttributesTestSuite) TestGetXattr_LargeValue(c *C) {
+	s.skipWithoutXattrs(c)
+	p := s.dir + "/file"
+
+	err := ioutil.WriteFile(p, []byte{}, 0644)
+	c.Assert(err, IsNil)
+
+	large := string(make([]byte, 1024))
+	err = setXattr(p, "user.large", large)
+	c.Assert(err, IsNil)
+
+	v, found, err := getXattr(p, "user.large")
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(v, Equals, large)
+}
+
+func (s *AttributesTestSuite) TestDirectory_Immutable(c *C) {
+	s.skipWithoutFlags(c)
+	p := s.dir + "/dir"
+
+	d := new(Directory)
+	d.SetDefault(&dummyRuntimeConfig)
+
+	err := d.SetUserConfig(haiconf.CommandArgs{
+		"Path":      p,
+		"Mode":      "0755",
+		"Ensure":    haiconf.ENSURE_PRESENT,
+		"Immutable": true,
+	})
+	c.Assert(err, IsNil)
+
+	err = d.Run()
+	c.Assert(err, IsNil)
+	assertFlags(c, p, FS_IMMUTABLE_FL)
+
+	// still immutable after a mode change
+	d.Mode = 0700
+	err = d.Run()
+	c.Assert(err, IsNil)
+	assertFlags(c, p, FS_IMMUTABLE_FL)
+	assertMode(c, p, 0700)
+}
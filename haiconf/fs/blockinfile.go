@@ -0,0 +1,223 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// BlockInFile({
+//     Path   = "/etc/hosts",
+//     Name   = "backends",
+//     Ensure = "present",
+//     Block  = [[
+// 10.0.0.1 backend-1
+// 10.0.0.2 backend-2
+// ]],
+//
+//     -- create the file when it does not exist
+//     Create = false,
+//     Mode   = "0644",
+// })
+//
+// The block is written between "# BEGIN haiconf backends" and
+// "# END haiconf backends". It is appended to the file the first time
+// then updated in place. Ensure = "absent" removes the block and its
+// markers. Mode and owner of existing files are preserved.
+
+package fs
+
+import (
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+)
+
+const (
+	BLOCK_BEGIN_MARKER = "# BEGIN haiconf %s"
+	BLOCK_END_MARKER   = "# END haiconf %s"
+)
+
+type BlockInFile struct {
+	Path   string
+	Name   string
+	Block  string
+	Ensure string
+	Create bool
+	Mode   os.FileMode
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (b *BlockInFile) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*b = BlockInFile{
+		Path:   "",
+		Name:   "",
+		Block:  "",
+		Ensure: haiconf.ENSURE_PRESENT,
+		Create: false,
+		Mode:   DEFAULT_MODE_FILE,
+		rc:     rc,
+	}
+
+	return nil
+}
+
+func (b *BlockInFile) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		b.setPath,
+		b.setName,
+		b.setEnsure,
+		b.setBlock,
+		b.setCreate,
+		b.setMode,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *BlockInFile) Run() error {
+	_, err := os.Stat(b.Path)
+	if b.Ensure == haiconf.ENSURE_ABSENT && os.IsNotExist(err) {
+		return nil
+	}
+
+	create := b.Create && b.Ensure == haiconf.ENSURE_PRESENT
+
+	return editFile(b.rc, b.Path, create, b.Mode, func(buff []byte) ([]byte, error) {
+		lines, err := b.apply(splitFileLines(buff))
+		if err != nil {
+			return nil, err
+		}
+
+		return joinFileLines(lines), nil
+	})
+}
+
+func (b *BlockInFile) apply(lines []string) ([]string, error) {
+	begin, end, err := b.findMarkers(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	var block []string
+	if b.Ensure == haiconf.ENSURE_PRESENT {
+		block = append(block, fmt.Sprintf(BLOCK_BEGIN_MARKER, b.Name))
+		block = append(block, splitFileLines([]byte(b.Block))...)
+		block = append(block, fmt.Sprintf(BLOCK_END_MARKER, b.Name))
+	}
+
+	if begin < 0 {
+		return append(lines, block...), nil
+	}
+
+	out := make([]string, 0, len(lines)-(end-begin+1)+len(block))
+	out = append(out, lines[:begin]...)
+	out = append(out, block...)
+	out = append(out, lines[end+1:]...)
+
+	return out, nil
+}
+
+// findMarkers returns the indexes of the begin and end markers,
+// -1 is returned for both when the block is not in the file
+func (b *BlockInFile) findMarkers(lines []string) (int, int, error) {
+	beginMarker := fmt.Sprintf(BLOCK_BEGIN_MARKER, b.Name)
+	endMarker := fmt.Sprintf(BLOCK_END_MARKER, b.Name)
+	begin, end := -1, -1
+
+	for i, line := range lines {
+		if line == beginMarker && begin < 0 {
+			begin = i
+		}
+
+		if line == endMarker && begin >= 0 {
+			end = i
+			break
+		}
+	}
+
+	if begin >= 0 && end < 0 {
+		return -1, -1, fmt.Errorf("%s has no \"%s\" marker", b.Path, endMarker)
+	}
+
+	return begin, end, nil
+}
+
+func (b *BlockInFile) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	b.Path = p
+	return nil
+}
+
+func (b *BlockInFile) setName(args haiconf.CommandArgs) error {
+	n, err := haiconf.CheckString("Name", args)
+	if err != nil {
+		return err
+	}
+
+	b.Name = n
+	return nil
+}
+
+func (b *BlockInFile) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	b.Ensure = e
+	return nil
+}
+
+func (b *BlockInFile) setBlock(args haiconf.CommandArgs) error {
+	if b.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	// an empty block is allowed, only the markers are written then
+	_, present := args["Block"]
+	if !present {
+		return haiconf.NewArgError("Block must be provided", args)
+	}
+
+	block, ok := args["Block"].(string)
+	if !ok {
+		return haiconf.NewArgError("Block must be a string", args)
+	}
+
+	b.Block = block
+	return nil
+}
+
+func (b *BlockInFile) setCreate(args haiconf.CommandArgs) error {
+	b.Create = haiconf.CheckBool("Create", args)
+	return nil
+}
+
+func (b *BlockInFile) setMode(args haiconf.CommandArgs) error {
+	_, present := args["Mode"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckFileMode("Mode", args)
+	if err != nil {
+		return err
+	}
+
+	b.Mode = m
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+)
+
+type BlockInFileTestSuite struct {
+	b *BlockInFile
+}
+
+var _ = Suite(&BlockInFileTestSuite{})
+
+const hostsFile = `127.0.0.1 localhost
+::1 localhost
+`
+
+func (s *BlockInFileTestSuite) SetUpTest(c *C) {
+	s.b = new(BlockInFile)
+	err := s.b.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *BlockInFileTestSuite) run(c *C, p string, ensure string, block string) string {
+	s.SetUpTest(c)
+
+	err := s.b.SetUserConfig(haiconf.CommandArgs{
+		"Path":   p,
+		"Name":   "backends",
+		"Ensure": ensure,
+		"Block":  block,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.b.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *BlockInFileTestSuite) TestSetUserConfig_NoName(c *C) {
+	err := s.b.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/hosts",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Block":  "foo",
+	})
+	c.Assert(err, ErrorMatches, "Name must be provided(.*)")
+}
+
+func (s *BlockInFileTestSuite) TestSetUserConfig_NoBlock(c *C) {
+	err := s.b.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/hosts",
+		"Name":   "backends",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Block must be provided(.*)")
+}
+
+func (s *BlockInFileTestSuite) TestRun(c *C) {
+	tmpFile := c.MkDir() + "/hosts"
+	err := ioutil.WriteFile(tmpFile, []byte(hostsFile), 0644)
+	c.Assert(err, IsNil)
+
+	obtained := s.run(c, tmpFile, haiconf.ENSURE_PRESENT, "10.0.0.1 backend-1\n")
+	expected := hostsFile + `# BEGIN haiconf backends
+10.0.0.1 backend-1
+# END haiconf backends
+`
+	c.Assert(obtained, Equals, expected)
+
+	// lines added after the block are kept when it is updated
+	err = ioutil.WriteFile(tmpFile, []byte(obtained+"10.0.0.9 other\n"), 0644)
+	c.Assert(err, IsNil)
+
+	obtained = s.run(c, tmpFile, haiconf.ENSURE_PRESENT, "10.0.0.1 backend-1\n10.0.0.2 backend-2")
+	expected = hostsFile + `# BEGIN haiconf backends
+10.0.0.1 backend-1
+10.0.0.2 backend-2
+# END haiconf backends
+10.0.0.9 other
+`
+	c.Assert(obtained, Equals, expected)
+
+	obtained = s.run(c, tmpFile, haiconf.ENSURE_ABSENT, "")
+	c.Assert(obtained, Equals, hostsFile+"10.0.0.9 other\n")
+}
+
+func (s *BlockInFileTestSuite) TestRun_MissingEndMarker(c *C) {
+	tmpFile := c.MkDir() + "/hosts"
+	err := ioutil.WriteFile(tmpFile, []byte(hostsFile+"# BEGIN haiconf backends\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.b.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Name":   "backends",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Block":  "10.0.0.1 backend-1",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.b.Run()
+	c.Assert(err, ErrorMatches, "(.*) has no \"# END haiconf backends\" marker")
+}
+
+func (s *BlockInFileTestSuite) TestRun_Create(c *C) {
+	tmpFile := c.MkDir() + "/profile"
+
+	err := s.b.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Name":   "path",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Block":  "export PATH=$PATH:/opt/bin",
+		"Create": true,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.b.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "# BEGIN haiconf path\nexport PATH=$PATH:/opt/bin\n# END haiconf path\n")
+}
@@ -5,20 +5,51 @@
 package fs
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
 	"os"
 	"os/user"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 )
 
 const (
 	DEFAULT_MODE_DIRECTORY = os.FileMode(0755)
 	DEFAULT_MODE_FILE      = os.FileMode(0644)
+
+	CHECKSUM_SHA1   = "sha1"
+	CHECKSUM_SHA256 = "sha256"
 )
 
+type ChecksumError struct {
+	Source   string
+	Expected string
+	Obtained string
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf("Checksum mismatch for %s. Expected %s, got %s", err.Source, err.Expected, err.Obtained)
+}
+
+// MkDir creates path unless it exists. A symbolic link pointing to a
+// directory is considered as an existing directory.
 func MkDir(path string, recurse bool, mode os.FileMode) error {
-	// XXX : symlink support ?
-	_, err := os.Stat(path)
+	fi, err := os.Lstat(path)
+
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		fi, err = os.Stat(path)
+		if err != nil || !fi.IsDir() {
+			return fmt.Errorf("%s is a symbolic link which does not point to a directory", path)
+		}
+	}
 
 	// directory already exists
 	if err == nil {
@@ -32,16 +63,20 @@ func MkDir(path string, recurse bool, mode os.FileMode) error {
 	return os.Mkdir(path, mode)
 }
 
+// RmDir removes path. When path is a symbolic link only the link is
+// removed, never the directory it points to.
 func RmDir(path string, recurse bool) error {
-	// XXX : symlink support ?
-
-	_, err := os.Stat(path)
+	fi, err := os.Lstat(path)
 
 	// directory does not exists
 	if os.IsNotExist(err) {
 		return nil
 	}
 
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+
 	if recurse {
 		return os.RemoveAll(path)
 	}
@@ -59,15 +94,330 @@ func Chmod(path string, mode os.FileMode) error {
 }
 
 func Chown(path string, usr *user.User, grp *hacks.Group) error {
-	uid, err := strconv.Atoi(usr.Uid)
+	uid, gid, err := lookupIds(usr, grp)
+	if err != nil {
+		return err
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// keepOwnership replaces a nil usr or grp with the current owner
+// or group of p. They are returned unchanged when p does not exist.
+func keepOwnership(p string, usr *user.User, grp *hacks.Group) (*user.User, *hacks.Group, error) {
+	if usr != nil && grp != nil {
+		return usr, grp, nil
+	}
+
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return usr, grp, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return usr, grp, nil
+	}
+
+	if usr == nil {
+		usr = &user.User{Uid: strconv.Itoa(int(st.Uid))}
+	}
+
+	if grp == nil {
+		grp = &hacks.Group{Gid: strconv.Itoa(int(st.Gid))}
+	}
+
+	return usr, grp, nil
+}
+
+// lookupIds returns the ids of usr and grp, -1 is returned
+// when they are nil so chown leaves them unchanged
+func lookupIds(usr *user.User, grp *hacks.Group) (int, int, error) {
+	uid, gid := -1, -1
+	var err error
+
+	if usr != nil {
+		uid, err = strconv.Atoi(usr.Uid)
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+
+	if grp != nil {
+		gid, err = strconv.Atoi(grp.Gid)
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// ownershipDiffers reports whether fi is not owned by uid or gid,
+// -1 is not compared
+func ownershipDiffers(fi os.FileInfo, uid int, gid int) bool {
+	if uid < 0 && gid < 0 {
+		return false
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+
+	return (uid >= 0 && int(st.Uid) != uid) || (gid >= 0 && int(st.Gid) != gid)
+}
+
+// ownership formats usr and grp for messages
+func ownership(usr *user.User, grp *hacks.Group) string {
+	u, g := "-", "-"
+
+	if usr != nil {
+		u = usr.Username
+	}
+
+	if grp != nil {
+		g = grp.Name
+	}
+
+	return u + ":" + g
+}
+
+// MkDirAll creates p and its missing parents. Every directory created
+// is chmodded explicitly so the process umask does not apply, usr and
+// grp are applied when not nil.
+func MkDirAll(p string, mode os.FileMode, usr *user.User, grp *hacks.Group) error {
+	fi, err := os.Stat(p)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", p)
+		}
+
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	parent := path.Dir(p)
+	if parent != p {
+		err = MkDirAll(parent, mode, usr, grp)
+		if err != nil {
+			return err
+		}
+	}
+
+	uid, gid, err := lookupIds(usr, grp)
 	if err != nil {
 		return err
 	}
 
-	gid, err := strconv.Atoi(grp.Gid)
+	err = os.Mkdir(p, mode)
 	if err != nil {
 		return err
 	}
 
-	return os.Chown(path, uid, gid)
+	return haiconf.SetOwnerAndMode(haiconf.FilePath(p), uid, gid, mode)
+}
+
+// Checksum returns the sha1 of buff
+func Checksum(buff []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(buff))
+}
+
+// ParseChecksum splits checksums like "sha256:<hex>" into their algorithm
+// and value. sha256 is assumed when no algorithm is given.
+func ParseChecksum(s string) (string, string, error) {
+	algo := CHECKSUM_SHA256
+	sum := strings.ToLower(s)
+
+	parts := strings.SplitN(sum, ":", 2)
+	if len(parts) == 2 {
+		algo = parts[0]
+		sum = parts[1]
+	}
+
+	size := 0
+	switch algo {
+	case CHECKSUM_SHA1:
+		size = sha1.Size
+	case CHECKSUM_SHA256:
+		size = sha256.Size
+	default:
+		return "", "", fmt.Errorf("Unsupported checksum algorithm %s", algo)
+	}
+
+	raw, err := hex.DecodeString(sum)
+	if err != nil || len(raw) != size {
+		return "", "", fmt.Errorf("Invalid %s checksum %s", algo, sum)
+	}
+
+	return algo, sum, nil
+}
+
+func VerifyChecksum(src string, buff []byte, expected string) error {
+	algo, sum, err := ParseChecksum(expected)
+	if err != nil {
+		return err
+	}
+
+	obtained := ""
+	switch algo {
+	case CHECKSUM_SHA1:
+		obtained = fmt.Sprintf("%x", sha1.Sum(buff))
+	case CHECKSUM_SHA256:
+		obtained = fmt.Sprintf("%x", sha256.Sum256(buff))
+	}
+
+	if obtained != sum {
+		return &ChecksumError{
+			Source:   src,
+			Expected: algo + ":" + sum,
+			Obtained: algo + ":" + obtained,
+		}
+	}
+
+	return nil
+}
+
+// MetadataDiffers reports whether the permissions, special bits or
+// ownership of p differ. A nil usr or grp is not compared.
+func MetadataDiffers(p string, mode os.FileMode, usr *user.User, grp *hacks.Group) (bool, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+
+	if fi.Mode()&haiconf.MODE_MASK != mode&haiconf.MODE_MASK {
+		return true, nil
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, nil
+	}
+
+	if usr != nil && strconv.Itoa(int(st.Uid)) != usr.Uid {
+		return true, nil
+	}
+
+	return grp != nil && strconv.Itoa(int(st.Gid)) != grp.Gid, nil
+}
+
+// WriteFileAtomic writes buff to a temporary file in the same directory
+// as p, sets its mode and owner, syncs it to disk and renames it to p.
+// Readers either see the old file or the new one, never a partial one.
+func WriteFileAtomic(p string, buff []byte, mode os.FileMode, usr *user.User, grp *hacks.Group) error {
+	return WriteFileAtomicValidated(p, buff, mode, usr, grp, nil)
+}
+
+// WriteFileAtomicValidated works like WriteFileAtomic but calls validate
+// with the path of the temporary file before it replaces p. Nothing is
+// installed when validate returns an error. When p is a symbolic link
+// the file it points to is replaced, the link is kept.
+func WriteFileAtomicValidated(p string, buff []byte, mode os.FileMode, usr *user.User, grp *hacks.Group, validate func(string) error) error {
+	p, err := resolveSymlink(p)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p, buff, mode, usr, grp, validate)
+}
+
+// writeFileAtomic does not follow symbolic links, a link at p is
+// replaced by the new file
+func writeFileAtomic(p string, buff []byte, mode os.FileMode, usr *user.User, grp *hacks.Group, validate func(string) error) error {
+	dir := path.Dir(p)
+
+	tmp, err := ioutil.TempFile(dir, "."+path.Base(p)+".haiconf")
+	if err != nil {
+		return err
+	}
+
+	err = writeTmpFile(tmp, buff, mode, usr, grp)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if validate != nil {
+		err = validate(tmp.Name())
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+
+	err = os.Rename(tmp.Name(), p)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// resolveSymlink returns the file p points to when p is a symbolic
+// link, p otherwise
+func resolveSymlink(p string) (string, error) {
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return p, nil
+	}
+
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", fmt.Errorf("Can not write through symbolic link %s: %s", p, err)
+	}
+
+	return target, nil
+}
+
+func writeTmpFile(tmp *os.File, buff []byte, mode os.FileMode, usr *user.User, grp *hacks.Group) error {
+	_, err := tmp.Write(buff)
+	if err != nil {
+		return err
+	}
+
+	uid, gid, err := lookupIds(usr, grp)
+	if err != nil {
+		return err
+	}
+
+	err = haiconf.SetOwnerAndMode(tmp, uid, gid, mode)
+	if err != nil {
+		return err
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+
+	return tmp.Close()
+}
+
+// syncDir makes sure a rename in dir is persisted
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
 }
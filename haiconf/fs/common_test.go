@@ -5,8 +5,13 @@
 package fs
 
 import (
+	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
+	"os/user"
+	"syscall"
 )
 
 type CommonTestSuite struct{}
@@ -71,3 +76,185 @@ func (s *CommonTestSuite) TestRmDir_Recursive(c *C) {
 	c.Assert(os.IsNotExist(err), Equals, true)
 	c.Assert(f, IsNil)
 }
+
+func (s *CommonTestSuite) TestMkDir_Symlink(c *C) {
+	tmpDir := c.MkDir()
+
+	err := os.Symlink(tmpDir, tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	err = MkDir(tmpDir+"/link", false, 0755)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(tmpDir+"/missing", tmpDir+"/dangling")
+	c.Assert(err, IsNil)
+
+	err = MkDir(tmpDir+"/dangling", false, 0755)
+	c.Assert(err, ErrorMatches, "(.*) is a symbolic link which does not point to a directory")
+}
+
+func (s *CommonTestSuite) TestRmDir_Symlink(c *C) {
+	tmpDir := c.MkDir()
+
+	err := MkDir(tmpDir+"/foo/bar", true, 0755)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(tmpDir+"/foo", tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	err = RmDir(tmpDir+"/link", true)
+	c.Assert(err, IsNil)
+
+	_, err = os.Lstat(tmpDir + "/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	f, err := os.Stat(tmpDir + "/foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(f.IsDir(), Equals, true)
+}
+
+func (s *CommonTestSuite) TestWriteFileAtomic(c *C) {
+	tmpDir := c.MkDir()
+	tmpFile := tmpDir + "/foo.txt"
+
+	err := ioutil.WriteFile(tmpFile, []byte("old"), 0644)
+	c.Assert(err, IsNil)
+
+	usr, err := user.Current()
+	c.Assert(err, IsNil)
+
+	grp := &hacks.Group{Gid: usr.Gid}
+
+	err = WriteFileAtomic(tmpFile, []byte("new"), 0640, usr, grp)
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "new")
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0640))
+
+	differs, err := MetadataDiffers(tmpFile, 0640, usr, grp)
+	c.Assert(err, IsNil)
+	c.Assert(differs, Equals, false)
+
+	differs, err = MetadataDiffers(tmpFile, 0644, usr, grp)
+	c.Assert(err, IsNil)
+	c.Assert(differs, Equals, true)
+
+	names, err := ioutil.ReadDir(tmpDir)
+	c.Assert(err, IsNil)
+	c.Assert(len(names), Equals, 1)
+}
+
+func (s *CommonTestSuite) TestWriteFileAtomic_Symlink(c *C) {
+	tmpDir := c.MkDir()
+	target := c.MkDir() + "/resolv.conf"
+	link := tmpDir + "/link"
+
+	err := ioutil.WriteFile(target, []byte("old"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(target, link)
+	c.Assert(err, IsNil)
+
+	err = WriteFileAtomic(link, []byte("new"), 0640, nil, nil)
+	c.Assert(err, IsNil)
+
+	fi, err := os.Lstat(link)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+
+	buff, err := ioutil.ReadFile(target)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "new")
+
+	assertMode(c, target, 0640)
+
+	// a dangling link is not replaced by a regular file
+	err = os.Remove(target)
+	c.Assert(err, IsNil)
+
+	err = WriteFileAtomic(link, []byte("new"), 0640, nil, nil)
+	c.Assert(err, ErrorMatches, "Can not write through symbolic link .*/link: .*")
+
+	fi, err = os.Lstat(link)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+}
+
+func (s *CommonTestSuite) TestChecksum(c *C) {
+	c.Assert(Checksum([]byte("foo")), Equals, "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33")
+}
+
+func (s *CommonTestSuite) TestParseChecksum(c *C) {
+	sha1Sum := "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"
+	sha256Sum := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	algo, sum, err := ParseChecksum("sha1:" + sha1Sum)
+	c.Assert(err, IsNil)
+	c.Assert(algo, Equals, CHECKSUM_SHA1)
+	c.Assert(sum, Equals, sha1Sum)
+
+	algo, sum, err = ParseChecksum(sha256Sum)
+	c.Assert(err, IsNil)
+	c.Assert(algo, Equals, CHECKSUM_SHA256)
+	c.Assert(sum, Equals, sha256Sum)
+
+	_, _, err = ParseChecksum("md5:" + sha1Sum)
+	c.Assert(err, ErrorMatches, "Unsupported checksum algorithm md5")
+
+	_, _, err = ParseChecksum("sha256:" + sha1Sum)
+	c.Assert(err, ErrorMatches, "Invalid sha256 checksum .*")
+}
+
+func (s *CommonTestSuite) TestVerifyChecksum(c *C) {
+	sum := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	err := VerifyChecksum("foo.txt", []byte("foo"), sum)
+	c.Assert(err, IsNil)
+
+	err = VerifyChecksum("foo.txt", []byte("bar"), sum)
+	c.Assert(err, FitsTypeOf, &ChecksumError{})
+}
+
+func (s *CommonTestSuite) TestMkDirAll(c *C) {
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+
+	tmpDir := c.MkDir()
+
+	err := MkDirAll(tmpDir+"/a/b", 0755|os.ModeSetgid, nil, nil)
+	c.Assert(err, IsNil)
+
+	for _, d := range []string{"/a", "/a/b"} {
+		fi, err := os.Stat(tmpDir + d)
+		c.Assert(err, IsNil)
+		c.Assert(fi.Mode()&haiconf.MODE_MASK, Equals, 0755|os.ModeSetgid)
+	}
+
+	err = ioutil.WriteFile(tmpDir+"/file", []byte{}, 0644)
+	c.Assert(err, IsNil)
+
+	err = MkDirAll(tmpDir+"/file", 0755, nil, nil)
+	c.Assert(err, ErrorMatches, "(.*) exists and is not a directory")
+}
+
+func (s *CommonTestSuite) TestMetadataDiffers_OptionalOwnership(c *C) {
+	tmpFile := c.MkDir() + "/foo"
+	err := ioutil.WriteFile(tmpFile, []byte{}, 0644)
+	c.Assert(err, IsNil)
+
+	err = os.Chmod(tmpFile, 0644)
+	c.Assert(err, IsNil)
+
+	differs, err := MetadataDiffers(tmpFile, 0644, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(differs, Equals, false)
+
+	differs, err = MetadataDiffers(tmpFile, 0644|os.ModeSticky, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(differs, Equals, true)
+}
@@ -0,0 +1,232 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Files bigger than this are not diffed
+	DIFF_MAX_SIZE = 1024 * 1024
+
+	// Maximum number of cells in the LCS table, this protects us
+	// from huge memory usage when both files are heavily modified
+	DIFF_MAX_CELLS = 4 * 1024 * 1024
+
+	DIFF_CONTEXT_LINES = 3
+
+	// Same heuristic as git: a NUL byte in the first 8000 bytes
+	// means the file is binary
+	BINARY_CHECK_SIZE = 8000
+)
+
+type diffOp struct {
+	kind byte
+	line string
+	a    int
+	b    int
+}
+
+func IsBinary(buff []byte) bool {
+	if len(buff) > BINARY_CHECK_SIZE {
+		buff = buff[:BINARY_CHECK_SIZE]
+	}
+
+	return bytes.IndexByte(buff, 0) != -1
+}
+
+// UnifiedDiff returns a unified diff between old and cur.
+// An empty string is returned when both contents are identical.
+func UnifiedDiff(oldName string, newName string, old []byte, cur []byte) string {
+	if bytes.Equal(old, cur) {
+		return ""
+	}
+
+	if IsBinary(old) || IsBinary(cur) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+	}
+
+	if len(old) > DIFF_MAX_SIZE || len(cur) > DIFF_MAX_SIZE {
+		return fmt.Sprintf("Files %s and %s differ (too big to diff)\n", oldName, newName)
+	}
+
+	a := splitLines(old)
+	b := splitLines(cur)
+
+	ops, ok := diffLines(a, b)
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ (too many changes to diff)\n", oldName, newName)
+	}
+
+	buff := new(bytes.Buffer)
+	fmt.Fprintf(buff, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range buildHunks(ops) {
+		buff.WriteString(formatHunk(ops[h[0]:h[1]]))
+	}
+
+	return buff.String()
+}
+
+func splitLines(buff []byte) []string {
+	if len(buff) == 0 {
+		return []string{}
+	}
+
+	lines := strings.SplitAfter(string(buff), "\n")
+
+	// content ending with a new line produces a trailing empty element
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func diffLines(a []string, b []string) ([]diffOp, bool) {
+	// strip common prefix and suffix, this keeps the LCS table small
+	// for the usual "a few lines changed" case
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+
+	if (len(ma)+1)*(len(mb)+1) > DIFF_MAX_CELLS {
+		return nil, false
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', line: a[i], a: i, b: i})
+	}
+
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+				continue
+			}
+
+			lcs[i][j] = lcs[i+1][j]
+			if lcs[i][j+1] > lcs[i][j] {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		ai, bj := prefix+i, prefix+j
+
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, diffOp{kind: ' ', line: ma[i], a: ai, b: bj})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: ma[i], a: ai, b: bj})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: mb[j], a: ai, b: bj})
+			j++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ai := len(a) - suffix + k
+		bj := len(b) - suffix + k
+		ops = append(ops, diffOp{kind: ' ', line: a[ai], a: ai, b: bj})
+	}
+
+	return ops, true
+}
+
+// buildHunks returns [start, end) ranges of ops to display
+func buildHunks(ops []diffOp) [][2]int {
+	var hunks [][2]int
+
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+
+		start := i - DIFF_CONTEXT_LINES
+		if start < 0 {
+			start = 0
+		}
+
+		end := i + DIFF_CONTEXT_LINES + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		l := len(hunks)
+		if l > 0 && start <= hunks[l-1][1] {
+			hunks[l-1][1] = end
+			continue
+		}
+
+		hunks = append(hunks, [2]int{start, end})
+	}
+
+	return hunks
+}
+
+func formatHunk(ops []diffOp) string {
+	aCount, bCount := 0, 0
+	body := new(bytes.Buffer)
+
+	for _, op := range ops {
+		if op.kind != '+' {
+			aCount++
+		}
+
+		if op.kind != '-' {
+			bCount++
+		}
+
+		body.WriteByte(op.kind)
+		body.WriteString(op.line)
+
+		if !strings.HasSuffix(op.line, "\n") {
+			body.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+
+	return fmt.Sprintf(
+		"@@ -%s +%s @@\n%s",
+		hunkRange(ops[0].a, aCount), hunkRange(ops[0].b, bCount), body.String(),
+	)
+}
+
+func hunkRange(start int, count int) string {
+	// an empty range refers to the line before it
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,113 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	. "launchpad.net/gocheck"
+	"strings"
+)
+
+type DiffTestSuite struct{}
+
+var _ = Suite(&DiffTestSuite{})
+
+func (s *DiffTestSuite) TestUnifiedDiff_Identical(c *C) {
+	buff := []byte("a\nb\nc\n")
+	c.Assert(UnifiedDiff("old", "new", buff, buff), Equals, "")
+}
+
+func (s *DiffTestSuite) TestUnifiedDiff_Modified(c *C) {
+	old := []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	cur := []byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n")
+
+	expected := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -2,7 +2,7 @@",
+		" 2",
+		" 3",
+		" 4",
+		"-5",
+		"+five",
+		" 6",
+		" 7",
+		" 8",
+		"",
+	}, "\n")
+
+	c.Assert(UnifiedDiff("old", "new", old, cur), Equals, expected)
+}
+
+func (s *DiffTestSuite) TestUnifiedDiff_SeveralHunks(c *C) {
+	old := []byte("a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n")
+	cur := []byte("A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n")
+
+	expected := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -1,4 +1,4 @@",
+		"-a",
+		"+A",
+		" 1",
+		" 2",
+		" 3",
+		"@@ -7,4 +7,4 @@",
+		" 6",
+		" 7",
+		" 8",
+		"-b",
+		"+B",
+		"",
+	}, "\n")
+
+	c.Assert(UnifiedDiff("old", "new", old, cur), Equals, expected)
+}
+
+func (s *DiffTestSuite) TestUnifiedDiff_FromEmpty(c *C) {
+	expected := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -0,0 +1,2 @@",
+		"+a",
+		"+b",
+		"",
+	}, "\n")
+
+	c.Assert(UnifiedDiff("old", "new", []byte{}, []byte("a\nb\n")), Equals, expected)
+}
+
+func (s *DiffTestSuite) TestUnifiedDiff_NoNewLineAtEndOfFile(c *C) {
+	expected := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -1 +1 @@",
+		"-a",
+		"\\ No newline at end of file",
+		"+a",
+		"",
+	}, "\n")
+
+	c.Assert(UnifiedDiff("old", "new", []byte("a"), []byte("a\n")), Equals, expected)
+}
+
+func (s *DiffTestSuite) TestUnifiedDiff_Binary(c *C) {
+	obtained := UnifiedDiff("old", "new", []byte("a\x00b"), []byte("a"))
+	c.Assert(obtained, Equals, "Binary files old and new differ\n")
+}
+
+func (s *DiffTestSuite) TestUnifiedDiff_TooBig(c *C) {
+	big := make([]byte, DIFF_MAX_SIZE+1)
+	for i := range big {
+		big[i] = 'a'
+	}
+
+	obtained := UnifiedDiff("old", "new", []byte("a"), big)
+	c.Assert(obtained, Equals, "Files old and new differ (too big to diff)\n")
+}
+
+func (s *DiffTestSuite) TestIsBinary(c *C) {
+	c.Assert(IsBinary([]byte("foo\nbar\n")), Equals, false)
+	c.Assert(IsBinary([]byte("foo\x00bar")), Equals, true)
+}
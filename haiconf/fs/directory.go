@@ -6,11 +6,38 @@
 //
 // Directory({
 //         Path    = "/tmp/haiconf/testdirectory",
-//         Mode    = "0755",
+//         Mode    = "0755",    -- or "u=rwx,g=rx,o=", "2775"
+//
+//         -- optional, ownership is left unchanged when missing.
+//         -- Numeric ids are accepted.
 //         Owner   = "jerome",
 //         Group   = "wheel",
+//
 //         Recurse = true,
 //         Ensure  = "present",
+//
+//         -- optional, applies FileMode/DirMode and Owner/Group to
+//         -- everything under Path. Symbolic links are never followed.
+//         -- RecurseOwnership requires Owner or Group.
+//         RecursePermissions = true,
+//         RecurseOwnership   = true,
+//         FileMode = "0644",
+//         DirMode  = "0755",   -- defaults to Mode
+//
+//         -- optional, copies every file from Source to Path
+//         Source  = "/absolute/path/to/files/etc/sudoers.d",
+//
+//         -- optional, removes files from Path which are not in Source
+//         Purge   = true,
+//
+//         -- optional, paths matching these globs are left untouched.
+//         -- Globs are matched against the path relative to Path or
+//         -- the file name
+//         Ignore  = {"README", "*.dpkg-*"},
+//
+//         -- optional, see Attributes. Flags only apply to Path itself
+//         Xattrs    = {["user.origin"] = "haiconf"},
+//         Immutable = false,
 //     })
 //
 package fs
@@ -18,8 +45,10 @@ package fs
 import (
 	"github.com/jeromer/haiconf/hacks"
 	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
 	"os"
 	"os/user"
+	"path/filepath"
 )
 
 type Directory struct {
@@ -28,12 +57,22 @@ type Directory struct {
 	Owner   *user.User
 	Recurse bool
 	Ensure  string
+	Source  string
+	Purge   bool
+	Ignore  []string
+
+	RecurseOwnership   bool
+	RecursePermissions bool
+	FileMode           os.FileMode
+	DirMode            os.FileMode
 
 	// no user.Group in golang yet
 	// (https://code.google.com/p/go/issues/detail?id=2617)
 	// Let's use a temporary one
 	Group *hacks.Group
 
+	Attributes
+
 	rc *haiconf.RuntimeConfig
 }
 
@@ -41,8 +80,8 @@ func (d *Directory) SetDefault(rc *haiconf.RuntimeConfig) error {
 	*d = Directory{
 		Path:    "",
 		Mode:    DEFAULT_MODE_DIRECTORY,
-		Owner:   new(user.User),
-		Group:   new(hacks.Group),
+		Owner:   nil,
+		Group:   nil,
 		Recurse: false,
 		Ensure:  haiconf.ENSURE_PRESENT,
 		rc:      rc,
@@ -86,6 +125,26 @@ func (d *Directory) SetUserConfig(args haiconf.CommandArgs) error {
 		return err
 	}
 
+	err = d.setRecursiveEnforcement(args)
+	if err != nil {
+		return err
+	}
+
+	err = d.setSource(args)
+	if err != nil {
+		return err
+	}
+
+	err = d.setPurge(args)
+	if err != nil {
+		return err
+	}
+
+	err = d.setAttributes(args)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -93,27 +152,150 @@ func (d *Directory) Run() error {
 	// XXX : acquire/release lock
 	if d.Ensure == haiconf.ENSURE_ABSENT {
 		haiconf.Output(d.rc, "Removing directory %s", d.Path)
+		if d.rc.DryRun {
+			return nil
+		}
+
+		if d.Recurse {
+			err := d.backup()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := unlockFile(d.rc, d.Path)
+		if err != nil {
+			return err
+		}
+
 		return RmDir(d.Path, d.Recurse)
 	}
 
 	haiconf.Output(d.rc, "Creating directory %s", d.Path)
+	if d.rc.DryRun {
+		haiconf.Output(d.rc, "Chmod %s on %s", d.Mode, d.Path)
+		haiconf.Output(d.rc, "Chown %s on %s", ownership(d.Owner, d.Group), d.Path)
+		d.outputAttributes(d.rc, d.Path)
+
+		err := d.enforceRecursively()
+		if err != nil {
+			return err
+		}
+
+		return d.sync()
+	}
+
 	err := MkDir(d.Path, d.Recurse, d.Mode)
 	if err != nil {
 		return err
 	}
 
+	// an immutable directory can not receive new files
+	return d.withAttributes(d.rc, d.Path, d.apply)
+}
+
+func (d *Directory) apply() error {
+	uid, gid, err := lookupIds(d.Owner, d.Group)
+	if err != nil {
+		return err
+	}
+
+	if d.Owner != nil || d.Group != nil {
+		haiconf.Output(d.rc, "Chown %s on %s", ownership(d.Owner, d.Group), d.Path)
+	}
+
 	haiconf.Output(d.rc, "Chmod %s on %s", d.Mode, d.Path)
-	err = Chmod(d.Path, d.Mode)
+	err = haiconf.SetOwnerAndMode(haiconf.FilePath(d.Path), uid, gid, d.Mode)
+	if err != nil {
+		return err
+	}
+
+	err = d.enforceRecursively()
+	if err != nil {
+		return err
+	}
+
+	return d.sync()
+}
+
+// enforceRecursively applies modes and ownership to everything under
+// d.Path. filepath.Walk uses Lstat so symbolic links are never followed,
+// their ownership is changed with Lchown and their mode is left as is.
+func (d *Directory) enforceRecursively() error {
+	if !d.RecurseOwnership && !d.RecursePermissions {
+		return nil
+	}
+
+	uid, gid, err := lookupIds(d.Owner, d.Group)
 	if err != nil {
 		return err
 	}
 
-	haiconf.Output(d.rc, "Chown %s:%s on %s", d.Owner.Username, d.Group.Name, d.Path)
-	err = Chown(d.Path, d.Owner, d.Group)
+	changed := 0
+	err = filepath.Walk(d.Path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// does not exist yet in dry-run mode
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		// the top level directory has already been handled
+		if p == d.Path {
+			return nil
+		}
+
+		c, err := d.enforce(p, fi, uid, gid)
+		if c {
+			changed++
+		}
+
+		return err
+	})
+
+	haiconf.Output(d.rc, "Changed permissions or ownership of %d entries under %s", changed, d.Path)
+
+	return err
+}
+
+func (d *Directory) enforce(p string, fi os.FileInfo, uid int, gid int) (bool, error) {
+	isLink := fi.Mode()&os.ModeSymlink != 0
+
+	chown := d.RecurseOwnership && ownershipDiffers(fi, uid, gid)
+	if !chown {
+		uid, gid = -1, -1
+	}
+
+	mode := d.FileMode
+	if fi.IsDir() {
+		mode = d.DirMode
+	}
+
+	chmod := d.RecursePermissions && !isLink && fi.Mode()&haiconf.MODE_MASK != mode&haiconf.MODE_MASK
+
+	if d.rc.DryRun || (!chown && !chmod) {
+		return chown || chmod, nil
+	}
+
+	if isLink || !d.RecursePermissions {
+		return true, os.Lchown(p, uid, gid)
+	}
+
+	return true, haiconf.SetOwnerAndMode(haiconf.FilePath(p), uid, gid, mode)
+}
+
+func (d *Directory) backup() error {
+	entries, err := backup.NewBucket(d.rc.BackupDir, d.rc.RunId).StoreTree(d.Path)
 	if err != nil {
 		return err
 	}
 
+	for _, e := range entries {
+		haiconf.Output(d.rc, "Backed up %s as %s", e.Path, e.Id)
+	}
+
 	return nil
 }
 
@@ -140,17 +322,22 @@ func (d *Directory) setEnsure(args haiconf.CommandArgs) error {
 }
 
 func (d *Directory) setMode(args haiconf.CommandArgs) error {
-	m, err := haiconf.CheckInt64("Mode", args)
+	m, err := haiconf.CheckFileMode("Mode", args)
 	if err != nil {
 		return err
 	}
 
-	d.Mode = os.FileMode(m)
+	d.Mode = m
 
 	return nil
 }
 
 func (d *Directory) setOwner(args haiconf.CommandArgs) error {
+	_, present := args["Owner"]
+	if !present {
+		return nil
+	}
+
 	u, err := haiconf.CheckSystemUser("Owner", args)
 	if err != nil {
 		return err
@@ -161,6 +348,11 @@ func (d *Directory) setOwner(args haiconf.CommandArgs) error {
 }
 
 func (d *Directory) setGroup(args haiconf.CommandArgs) error {
+	_, present := args["Group"]
+	if !present {
+		return nil
+	}
+
 	grp, err := haiconf.CheckSystemGroup("Group", args)
 	if err != nil {
 		return err
@@ -174,3 +366,88 @@ func (d *Directory) setRecurse(args haiconf.CommandArgs) error {
 	d.Recurse = haiconf.CheckBool("Recurse", args)
 	return nil
 }
+
+func (d *Directory) setSource(args haiconf.CommandArgs) error {
+	_, present := args["Source"]
+	if !present {
+		return nil
+	}
+
+	src, err := haiconf.CheckAbsolutePath("Source", args)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return haiconf.NewArgError(err.Error(), args)
+	}
+
+	if !fi.IsDir() {
+		return haiconf.NewArgError(src+" is not a directory", args)
+	}
+
+	d.Source = src
+	return nil
+}
+
+func (d *Directory) setPurge(args haiconf.CommandArgs) error {
+	d.Purge = haiconf.CheckBool("Purge", args)
+
+	if d.Purge && d.Source == "" {
+		return haiconf.NewArgError("Purge requires a Source", args)
+	}
+
+	_, present := args["Ignore"]
+	if !present {
+		return nil
+	}
+
+	ignore, err := haiconf.CheckStringList("Ignore", args)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range ignore {
+		_, err = filepath.Match(g, "")
+		if err != nil {
+			return haiconf.NewArgError("Invalid Ignore glob "+g+" : "+err.Error(), args)
+		}
+	}
+
+	d.Ignore = ignore
+	return nil
+}
+
+func (d *Directory) setRecursiveEnforcement(args haiconf.CommandArgs) error {
+	d.RecurseOwnership = haiconf.CheckBool("RecurseOwnership", args)
+	d.RecursePermissions = haiconf.CheckBool("RecursePermissions", args)
+
+	if d.RecurseOwnership && d.Owner == nil && d.Group == nil {
+		return haiconf.NewArgError("RecurseOwnership requires Owner or Group", args)
+	}
+
+	d.FileMode = DEFAULT_MODE_FILE
+	_, present := args["FileMode"]
+	if present {
+		m, err := haiconf.CheckFileMode("FileMode", args)
+		if err != nil {
+			return err
+		}
+
+		d.FileMode = m
+	}
+
+	d.DirMode = d.Mode
+	_, present = args["DirMode"]
+	if present {
+		m, err := haiconf.CheckFileMode("DirMode", args)
+		if err != nil {
+			return err
+		}
+
+		d.DirMode = m
+	}
+
+	return nil
+}
@@ -5,13 +5,16 @@
 package fs
 
 import (
+	"bytes"
 	"github.com/jeromer/haiconf/hacks"
 	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
 	"os/user"
 	"path"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -54,8 +57,8 @@ func (s *DirectoryTestSuite) TestSetDefault(c *C) {
 	expected := &Directory{
 		Path:    "",
 		Mode:    0755,
-		Owner:   new(user.User),
-		Group:   new(hacks.Group),
+		Owner:   nil,
+		Group:   nil,
 		Recurse: false,
 		Ensure:  haiconf.ENSURE_PRESENT,
 		rc:      &dummyRuntimeConfig,
@@ -111,8 +114,8 @@ func (s *DirectoryTestSuite) TestSetUserConfig_Absent(c *C) {
 
 	// Since we want to remove the directory we do not care about
 	// the value of attributes below
-	c.Assert(s.d.Owner, DeepEquals, new(user.User))
-	c.Assert(s.d.Group, DeepEquals, new(hacks.Group))
+	c.Assert(s.d.Owner, IsNil)
+	c.Assert(s.d.Group, IsNil)
 }
 
 func (s *DirectoryTestSuite) TestRun_Create(c *C) {
@@ -167,3 +170,119 @@ func (s *DirectoryTestSuite) TestRun_Remove(c *C) {
 	c.Assert(os.IsNotExist(err), Equals, true)
 	c.Assert(f, IsNil)
 }
+
+func (s *DirectoryTestSuite) TestRun_RecursePermissions(c *C) {
+	tmpDir := c.MkDir() + "/foo"
+
+	err := os.MkdirAll(tmpDir+"/sub", 0700)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(tmpDir+"/sub/file", []byte{}, 0600)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(tmpDir+"/ok", []byte{}, 0640)
+	c.Assert(err, IsNil)
+
+	// links pointing outside of the tree must not be followed
+	outside := c.MkDir() + "/outside"
+	err = ioutil.WriteFile(outside, []byte{}, 0600)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(outside, tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{Verbose: true, Output: output}
+	s.d.SetDefault(&rc)
+
+	err = s.d.SetUserConfig(haiconf.CommandArgs{
+		"Path":               tmpDir,
+		"Ensure":             haiconf.ENSURE_PRESENT,
+		"Mode":               "0755",
+		"Owner":              currentUser.Username,
+		"Group":              dummyGroup,
+		"RecursePermissions": true,
+		"RecurseOwnership":   true,
+		"FileMode":           "0640",
+		"DirMode":            "0750",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.d.Run()
+	c.Assert(err, IsNil)
+
+	expected := map[string]os.FileMode{
+		"":          0755,
+		"/sub":      0750,
+		"/sub/file": 0640,
+		"/ok":       0640,
+	}
+
+	for p, mode := range expected {
+		fi, err := os.Stat(tmpDir + p)
+		c.Assert(err, IsNil)
+		c.Assert(fi.Mode().Perm(), Equals, mode)
+	}
+
+	fi, err := os.Stat(outside)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	// sub and sub/file only, ownership is already correct
+	c.Assert(strings.Contains(output.String(), "Changed permissions or ownership of 2 entries"), Equals, true)
+}
+
+func (s *DirectoryTestSuite) TestSetUserConfig_RecurseOwnershipWithoutOwner(c *C) {
+	err := s.d.SetUserConfig(haiconf.CommandArgs{
+		"Path":             c.MkDir(),
+		"Ensure":           haiconf.ENSURE_PRESENT,
+		"Mode":             "0755",
+		"RecurseOwnership": true,
+	})
+	c.Assert(err, ErrorMatches, "RecurseOwnership requires Owner or Group(.*)")
+}
+
+func (s *DirectoryTestSuite) TestRun_RecurseOwnershipKeepsSpecialBits(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("changing the owner of a file requires root")
+	}
+
+	tmpDir := c.MkDir() + "/bin"
+	err := os.MkdirAll(tmpDir, 0755)
+	c.Assert(err, IsNil)
+
+	mode := os.FileMode(0755) | os.ModeSetuid
+	err = ioutil.WriteFile(tmpDir+"/tool", []byte{}, 0755)
+	c.Assert(err, IsNil)
+
+	err = os.Chown(tmpDir+"/tool", 65534, 65534)
+	c.Assert(err, IsNil)
+
+	args := haiconf.CommandArgs{
+		"Path":               tmpDir,
+		"Ensure":             haiconf.ENSURE_PRESENT,
+		"Mode":               "0755",
+		"Owner":              currentUser.Uid,
+		"RecursePermissions": true,
+		"RecurseOwnership":   true,
+		"FileMode":           "4755",
+	}
+
+	for _, expected := range []string{"1", "0"} {
+		output := new(bytes.Buffer)
+		s.d.SetDefault(&haiconf.RuntimeConfig{Verbose: true, Output: output})
+
+		err = s.d.SetUserConfig(args)
+		c.Assert(err, IsNil)
+
+		err = s.d.Run()
+		c.Assert(err, IsNil)
+
+		fi, err := os.Stat(tmpDir + "/tool")
+		c.Assert(err, IsNil)
+		c.Assert(fi.Mode()&haiconf.MODE_MASK, Equals, mode)
+
+		// converges on the first run
+		c.Assert(output.String(), Matches, "(?s).*Changed permissions or ownership of "+expected+" entries.*")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// editFunc receives the current content of a file and returns
+// the content which must be written.
+type editFunc func([]byte) ([]byte, error)
+
+// editFile applies edit to the file at p and writes the result when it
+// differs. The mode and owner of an existing file are preserved. When
+// the file does not exist it is created with mode if create is true,
+// edit then receives an empty content. A symbolic link is edited
+// through, the file it points to is read, backed up and replaced.
+func editFile(rc *haiconf.RuntimeConfig, p string, create bool, mode os.FileMode, edit editFunc) error {
+	p, err := resolveSymlink(p)
+	if err != nil {
+		return err
+	}
+
+	return editFileAs(rc, p, create, mode, nil, nil, edit)
+}
+
+// editFileAs works like editFile but refuses symbolic links and
+// creates missing files for usr and grp, nil meaning the haiconf
+// process. It is meant for directories owned by someone else, such as
+// a home directory, whose owner could swap the file for a link to a
+// file only root may read or write: the file is only accessed through
+// file descriptors and a link is replaced, never written through.
+func editFileAs(rc *haiconf.RuntimeConfig, p string, create bool, mode os.FileMode, usr *user.User, grp *hacks.Group, edit editFunc) error {
+	current, fi, err := readFileNoFollow(p)
+	if err != nil {
+		return err
+	}
+
+	exists := fi != nil
+	if !exists && !create {
+		return fmt.Errorf("%s does not exist", p)
+	}
+
+	buff, err := edit(current)
+	if err != nil {
+		return err
+	}
+
+	if exists && bytes.Equal(current, buff) {
+		haiconf.Output(rc, "File %s is unchanged", p)
+		return nil
+	}
+
+	if usr == nil {
+		usr = &user.User{Uid: strconv.Itoa(os.Getuid())}
+	}
+
+	if grp == nil {
+		grp = &hacks.Group{Gid: strconv.Itoa(os.Getgid())}
+	}
+
+	if exists {
+		mode = fi.Mode() & haiconf.MODE_MASK
+
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if ok {
+			usr = &user.User{Uid: strconv.Itoa(int(st.Uid))}
+			grp = &hacks.Group{Gid: strconv.Itoa(int(st.Gid))}
+		}
+
+		diff := UnifiedDiff(p, p, current, buff)
+		haiconf.Output(rc, "%s", strings.TrimRight(diff, "\n"))
+	}
+
+	haiconf.Output(rc, "Writing file %s", p)
+	if rc.DryRun {
+		return nil
+	}
+
+	if exists {
+		uid, gid, err := lookupIds(usr, grp)
+		if err != nil {
+			return err
+		}
+
+		// the content already read is stored, p may have changed since
+		e, err := backup.NewBucket(rc.BackupDir, rc.RunId).Store(p, current, mode, uid, gid)
+		if err != nil {
+			return err
+		}
+
+		if e != nil {
+			haiconf.Output(rc, "Backed up %s as %s", p, e.Id)
+		}
+	} else {
+		err = MkDir(path.Dir(p), true, DEFAULT_MODE_DIRECTORY)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(p, buff, mode, usr, grp, nil)
+}
+
+// readFileNoFollow returns the content of the regular file p and its
+// FileInfo, taken from the same file descriptor. Both are nil when p
+// does not exist. Symbolic links and special files are refused.
+func readFileNoFollow(p string) ([]byte, os.FileInfo, error) {
+	// O_NONBLOCK keeps a fifo from blocking until it is refused below
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	pe, ok := err.(*os.PathError)
+	if ok && pe.Err == syscall.ELOOP {
+		return nil, nil, fmt.Errorf("%s is a symbolic link", p)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", p)
+	}
+
+	buff, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buff, fi, nil
+}
+
+// splitFileLines splits buff on new lines, the trailing new line
+// does not produce an empty last line.
+func splitFileLines(buff []byte) []string {
+	s := strings.TrimSuffix(string(buff), "\n")
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(s, "\n")
+}
+
+func joinFileLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func checkRegexp(k string, args haiconf.CommandArgs) (*regexp.Regexp, error) {
+	_, present := args[k]
+	if !present {
+		return nil, nil
+	}
+
+	s, err := haiconf.CheckString(k, args)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, haiconf.NewArgError("Invalid "+k+" : "+err.Error(), args)
+	}
+
+	return re, nil
+}
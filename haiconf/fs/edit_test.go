@@ -0,0 +1,154 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type EditTestSuite struct{}
+
+var _ = Suite(&EditTestSuite{})
+
+func appendFoo(buff []byte) ([]byte, error) {
+	return append(buff, []byte("foo\n")...), nil
+}
+
+func (s *EditTestSuite) TestSplitFileLines(c *C) {
+	c.Assert(splitFileLines([]byte("")), DeepEquals, []string{})
+	c.Assert(splitFileLines([]byte("a\nb\n")), DeepEquals, []string{"a", "b"})
+	c.Assert(splitFileLines([]byte("a\n\nb")), DeepEquals, []string{"a", "", "b"})
+	c.Assert(string(joinFileLines([]string{"a", "b"})), Equals, "a\nb\n")
+	c.Assert(string(joinFileLines([]string{})), Equals, "")
+}
+
+func (s *EditTestSuite) TestEditFile_DoesNotExist(c *C) {
+	err := editFile(&dummyRuntimeConfig, c.MkDir()+"/foo", false, 0644, appendFoo)
+	c.Assert(err, ErrorMatches, "(.*) does not exist")
+}
+
+func (s *EditTestSuite) TestEditFile_Create(c *C) {
+	tmpFile := c.MkDir() + "/sub/foo"
+
+	err := editFile(&dummyRuntimeConfig, tmpFile, true, 0600, appendFoo)
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "foo\n")
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *EditTestSuite) TestEditFile_PreservesMode(c *C) {
+	tmpFile := c.MkDir() + "/foo"
+	err := ioutil.WriteFile(tmpFile, []byte("bar\n"), 0640)
+	c.Assert(err, IsNil)
+
+	err = editFile(&dummyRuntimeConfig, tmpFile, false, 0644, appendFoo)
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "bar\nfoo\n")
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0640))
+}
+
+func (s *EditTestSuite) TestEditFile_PreservesSpecialBits(c *C) {
+	tmpFile := c.MkDir() + "/foo"
+	err := ioutil.WriteFile(tmpFile, []byte("bar\n"), 0755)
+	c.Assert(err, IsNil)
+
+	mode := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid
+	err = os.Chmod(tmpFile, mode)
+	c.Assert(err, IsNil)
+
+	err = editFile(&dummyRuntimeConfig, tmpFile, false, 0644, appendFoo)
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, mode)
+}
+
+func (s *EditTestSuite) TestEditFile_Symlink(c *C) {
+	tmpDir := c.MkDir()
+	target := tmpDir + "/target"
+	link := tmpDir + "/link"
+
+	err := ioutil.WriteFile(target, []byte("bar\n"), 0640)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(target, link)
+	c.Assert(err, IsNil)
+
+	rc := haiconf.RuntimeConfig{
+		Output:    ioutil.Discard,
+		BackupDir: c.MkDir(),
+	}
+
+	err = editFile(&rc, link, false, 0644, appendFoo)
+	c.Assert(err, IsNil)
+
+	fi, err := os.Lstat(link)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&os.ModeSymlink, Not(Equals), os.FileMode(0))
+
+	buff, err := ioutil.ReadFile(target)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "bar\nfoo\n")
+
+	entries, err := backup.NewBucket(rc.BackupDir, "").List()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Path, Equals, target)
+}
+
+func (s *EditTestSuite) TestEditFileAs_RefusesSymlink(c *C) {
+	tmpDir := c.MkDir()
+
+	err := ioutil.WriteFile(tmpDir+"/target", []byte("bar\n"), 0600)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(tmpDir+"/target", tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	err = editFileAs(&dummyRuntimeConfig, tmpDir+"/link", true, 0644, nil, nil, appendFoo)
+	c.Assert(err, ErrorMatches, ".*/link is a symbolic link")
+
+	buff, err := ioutil.ReadFile(tmpDir + "/target")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "bar\n")
+}
+
+func (s *EditTestSuite) TestEditFile_DryRun(c *C) {
+	tmpFile := c.MkDir() + "/foo"
+	err := ioutil.WriteFile(tmpFile, []byte("bar\n"), 0644)
+	c.Assert(err, IsNil)
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+
+	err = editFile(&rc, tmpFile, false, 0644, appendFoo)
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "bar\n")
+	c.Assert(output.String(), Matches, "(?s).*\\+foo\n.*Writing file .*")
+}
@@ -0,0 +1,337 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// EnvironmentVariable({
+//     Name   = "JAVA_HOME",
+//     Value  = "/usr/lib/jvm/default-java",
+//     Ensure = "present",
+//
+//     -- system  : KEY=VALUE in /etc/environment, read by pam_env
+//     -- profile : export KEY='VALUE' in /etc/profile.d/<File>.sh
+//     -- user    : export KEY='VALUE' in the ~/.profile of User
+//     Scope  = "system",
+//
+//     -- optional, profile scope only
+//     File   = "java",
+//
+//     -- mandatory for the user scope
+//     User   = "jerome",
+//
+//     -- optional, overrides the path derived from Scope
+//     Path   = "/etc/environment",
+// })
+//
+// Values are written literally, no variable expansion happens. Other
+// variables, comments and unrelated lines are left untouched. In the
+// user scope the file is refused when it is a symbolic link.
+
+package fs
+
+import (
+	"fmt"
+	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	ENV_SCOPE_SYSTEM  = "system"
+	ENV_SCOPE_PROFILE = "profile"
+	ENV_SCOPE_USER    = "user"
+
+	DEFAULT_ENVIRONMENT_PATH = "/etc/environment"
+	DEFAULT_PROFILE_DIR      = "/etc/profile.d"
+	DEFAULT_PROFILE_FILE     = "haiconf"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type EnvironmentVariable struct {
+	Name   string
+	Value  string
+	Ensure string
+	Scope  string
+	File   string
+	User   *user.User
+	Path   string
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (e *EnvironmentVariable) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*e = EnvironmentVariable{
+		Name:   "",
+		Value:  "",
+		Ensure: haiconf.ENSURE_PRESENT,
+		Scope:  ENV_SCOPE_SYSTEM,
+		File:   DEFAULT_PROFILE_FILE,
+		User:   nil,
+		Path:   "",
+		rc:     rc,
+	}
+
+	return nil
+}
+
+func (e *EnvironmentVariable) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		e.setName,
+		e.setEnsure,
+		e.setScope,
+		e.setValue,
+		e.setFile,
+		e.setUser,
+		e.setPath,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *EnvironmentVariable) Run() error {
+	fi, err := os.Lstat(e.Path)
+	exists := err == nil
+
+	if e.Ensure == haiconf.ENSURE_ABSENT && !exists {
+		return nil
+	}
+
+	edit := func(buff []byte) ([]byte, error) {
+		return joinFileLines(e.apply(splitFileLines(buff))), nil
+	}
+
+	if e.Scope != ENV_SCOPE_USER {
+		return editFile(e.rc, e.Path, true, DEFAULT_MODE_FILE, edit)
+	}
+
+	// the home directory belongs to the user, a link there could
+	// make haiconf read or write any file as root
+	if exists && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symbolic link, refusing to edit it for %s", e.Path, e.User.Username)
+	}
+
+	// files created for a user must belong to that user
+	grp := &hacks.Group{Gid: e.User.Gid}
+
+	return editFileAs(e.rc, e.Path, true, DEFAULT_MODE_FILE, e.User, grp, edit)
+}
+
+func (e *EnvironmentVariable) apply(lines []string) []string {
+	setting := e.line()
+	out := make([]string, 0, len(lines)+1)
+	replaced := false
+
+	for _, line := range lines {
+		if envVariableName(line) != e.Name {
+			out = append(out, line)
+			continue
+		}
+
+		if e.Ensure == haiconf.ENSURE_PRESENT && !replaced {
+			out = append(out, setting)
+			replaced = true
+		}
+	}
+
+	if e.Ensure == haiconf.ENSURE_PRESENT && !replaced {
+		out = append(out, setting)
+	}
+
+	return out
+}
+
+// line formats the variable for the file it goes to. pam_env
+// only strips surrounding quotes, shells need proper escaping.
+func (e *EnvironmentVariable) line() string {
+	if e.Scope != ENV_SCOPE_SYSTEM {
+		return "export " + e.Name + "=" + shellQuote(e.Value)
+	}
+
+	if !strings.ContainsAny(e.Value, " \t#'\"") {
+		return e.Name + "=" + e.Value
+	}
+
+	if strings.Contains(e.Value, `"`) {
+		return e.Name + "='" + e.Value + "'"
+	}
+
+	return e.Name + `="` + e.Value + `"`
+}
+
+// shellQuote wraps s in single quotes, which are the only
+// character needing an escape within single quotes
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// envVariableName returns the name of the variable defined on line,
+// as in KEY=VALUE or export KEY=VALUE
+func envVariableName(line string) string {
+	l := strings.TrimSpace(line)
+
+	if strings.HasPrefix(l, "export ") || strings.HasPrefix(l, "export\t") {
+		l = strings.TrimSpace(l[len("export"):])
+	}
+
+	idx := strings.Index(l, "=")
+	if idx <= 0 || !envNameRegexp.MatchString(l[:idx]) {
+		return ""
+	}
+
+	return l[:idx]
+}
+
+func (e *EnvironmentVariable) setName(args haiconf.CommandArgs) error {
+	n, err := haiconf.CheckString("Name", args)
+	if err != nil {
+		return err
+	}
+
+	if !envNameRegexp.MatchString(n) {
+		return haiconf.NewArgError("Invalid variable name "+n, args)
+	}
+
+	e.Name = n
+	return nil
+}
+
+func (e *EnvironmentVariable) setEnsure(args haiconf.CommandArgs) error {
+	ensure, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	e.Ensure = ensure
+	return nil
+}
+
+func (e *EnvironmentVariable) setScope(args haiconf.CommandArgs) error {
+	_, present := args["Scope"]
+	if !present {
+		return nil
+	}
+
+	choices := []string{ENV_SCOPE_SYSTEM, ENV_SCOPE_PROFILE, ENV_SCOPE_USER}
+
+	s, err := haiconf.CheckStringChoice("Scope", args, choices)
+	if err != nil {
+		return err
+	}
+
+	e.Scope = s
+	return nil
+}
+
+func (e *EnvironmentVariable) setValue(args haiconf.CommandArgs) error {
+	if e.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	v, present := args["Value"]
+	if !present {
+		return haiconf.NewArgError("Value must be provided", args)
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return haiconf.NewArgError("Value must be a string", args)
+	}
+
+	if strings.Contains(s, "\n") {
+		return haiconf.NewArgError("Value must not contain new lines", args)
+	}
+
+	// pam_env has no escape mechanism
+	if e.Scope == ENV_SCOPE_SYSTEM && strings.Contains(s, `"`) && strings.Contains(s, "'") {
+		return haiconf.NewArgError("Value can not contain both single and double quotes in /etc/environment", args)
+	}
+
+	e.Value = s
+	return nil
+}
+
+func (e *EnvironmentVariable) setFile(args haiconf.CommandArgs) error {
+	_, present := args["File"]
+	if !present {
+		return nil
+	}
+
+	if e.Scope != ENV_SCOPE_PROFILE {
+		return haiconf.NewArgError("File is only valid for the profile scope", args)
+	}
+
+	f, err := haiconf.CheckString("File", args)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(f, "/") {
+		return haiconf.NewArgError("File must be a file name, not a path", args)
+	}
+
+	e.File = strings.TrimSuffix(f, ".sh")
+	return nil
+}
+
+func (e *EnvironmentVariable) setUser(args haiconf.CommandArgs) error {
+	_, present := args["User"]
+	if !present && e.Scope != ENV_SCOPE_USER {
+		return nil
+	}
+
+	if e.Scope != ENV_SCOPE_USER {
+		return haiconf.NewArgError("User is only valid for the user scope", args)
+	}
+
+	u, err := haiconf.CheckSystemUser("User", args)
+	if err != nil {
+		return err
+	}
+
+	if u.HomeDir == "" {
+		return haiconf.NewArgError(u.Username+" has no home directory", args)
+	}
+
+	e.User = u
+	return nil
+}
+
+func (e *EnvironmentVariable) setPath(args haiconf.CommandArgs) error {
+	_, present := args["Path"]
+	if present {
+		p, err := haiconf.CheckAbsolutePath("Path", args)
+		if err != nil {
+			return err
+		}
+
+		e.Path = p
+		return nil
+	}
+
+	switch e.Scope {
+	case ENV_SCOPE_PROFILE:
+		e.Path = path.Join(DEFAULT_PROFILE_DIR, e.File+".sh")
+	case ENV_SCOPE_USER:
+		e.Path = path.Join(e.User.HomeDir, ".profile")
+	default:
+		e.Path = DEFAULT_ENVIRONMENT_PATH
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"syscall"
+)
+
+type EnvironmentVariableTestSuite struct {
+	e *EnvironmentVariable
+}
+
+var _ = Suite(&EnvironmentVariableTestSuite{})
+
+const etcEnvironment = `PATH="/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+# proxy settings
+http_proxy=http://proxy:3128
+`
+
+const profileD = `# set by the JDK package
+export JAVA_HOME=/usr/lib/jvm/java-6
+export JAVA_OPTS="-Xmx512m"
+`
+
+func (s *EnvironmentVariableTestSuite) SetUpTest(c *C) {
+	s.e = new(EnvironmentVariable)
+	err := s.e.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *EnvironmentVariableTestSuite) run(c *C, initial string, args haiconf.CommandArgs) string {
+	tmpFile := c.MkDir() + "/environment"
+	err := ioutil.WriteFile(tmpFile, []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	args["Path"] = tmpFile
+	if _, present := args["Ensure"]; !present {
+		args["Ensure"] = haiconf.ENSURE_PRESENT
+	}
+
+	err = s.e.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.e.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *EnvironmentVariableTestSuite) TestSetDefault(c *C) {
+	c.Assert(s.e.Scope, Equals, ENV_SCOPE_SYSTEM)
+	c.Assert(s.e.File, Equals, DEFAULT_PROFILE_FILE)
+}
+
+func (s *EnvironmentVariableTestSuite) TestSetUserConfig_Paths(c *C) {
+	err := s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA_HOME",
+		"Value":  "/usr/lib/jvm/default-java",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.e.Path, Equals, DEFAULT_ENVIRONMENT_PATH)
+
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA_HOME",
+		"Value":  "/usr/lib/jvm/default-java",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Scope":  ENV_SCOPE_PROFILE,
+		"File":   "java.sh",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.e.Path, Equals, "/etc/profile.d/java.sh")
+
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA_HOME",
+		"Value":  "/usr/lib/jvm/default-java",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Scope":  ENV_SCOPE_USER,
+		"User":   currentUser.Username,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.e.Path, Equals, currentUser.HomeDir+"/.profile")
+}
+
+func (s *EnvironmentVariableTestSuite) TestSetUserConfig_Invalid(c *C) {
+	err := s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA-HOME",
+		"Value":  "/usr/lib/jvm/default-java",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Invalid variable name JAVA-HOME(.*)")
+
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA_HOME",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Scope":  ENV_SCOPE_USER,
+		"Value":  "/usr/lib/jvm/default-java",
+	})
+	c.Assert(err, ErrorMatches, "User must be defined(.*)")
+
+	s.e.SetDefault(&dummyRuntimeConfig)
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA_HOME",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Value":  "/usr/lib/jvm/default-java",
+		"File":   "java",
+	})
+	c.Assert(err, ErrorMatches, "File is only valid for the profile scope(.*)")
+
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "GREETING",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Value":  `it's "quoted"`,
+	})
+	c.Assert(err, ErrorMatches, "Value can not contain both single and double quotes(.*)")
+
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "GREETING",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Value":  "hello\nworld",
+	})
+	c.Assert(err, ErrorMatches, "(?s)Value must not contain new lines(.*)")
+}
+
+func (s *EnvironmentVariableTestSuite) TestEnvVariableName(c *C) {
+	tests := map[string]string{
+		"PATH=/bin":                 "PATH",
+		"  export JAVA_HOME=/opt":   "JAVA_HOME",
+		"export\tJAVA_OPTS='-Xmx'":  "JAVA_OPTS",
+		"# JAVA_HOME=/opt":          "",
+		"export JAVA_HOME":          "",
+		"if [ -d /opt ]; then":      "",
+		"PATH=$PATH:/opt/bin # foo": "PATH",
+	}
+
+	for line, expected := range tests {
+		c.Assert(envVariableName(line), Equals, expected, Commentf(line))
+	}
+}
+
+func (s *EnvironmentVariableTestSuite) TestLine(c *C) {
+	s.e.Name = "V"
+
+	tests := map[string]string{
+		"/usr/bin":    "V=/usr/bin",
+		"hello world": `V="hello world"`,
+		`say "hi"`:    `V='say "hi"'`,
+		"it's":        `V="it's"`,
+		"":            "V=",
+	}
+
+	for v, expected := range tests {
+		s.e.Value = v
+		c.Assert(s.e.line(), Equals, expected, Commentf(v))
+	}
+
+	s.e.Scope = ENV_SCOPE_PROFILE
+	s.e.Value = "it's $HOME"
+	c.Assert(s.e.line(), Equals, `export V='it'\''s $HOME'`)
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_System(c *C) {
+	out := s.run(c, etcEnvironment, haiconf.CommandArgs{
+		"Name":  "http_proxy",
+		"Value": "http://proxy.example.com:3128",
+	})
+
+	expected := `PATH="/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+# proxy settings
+http_proxy=http://proxy.example.com:3128
+`
+	c.Assert(out, Equals, expected)
+
+	out = s.run(c, etcEnvironment, haiconf.CommandArgs{
+		"Name":  "LANG",
+		"Value": "en_US.UTF-8",
+	})
+	c.Assert(out, Equals, etcEnvironment+"LANG=en_US.UTF-8\n")
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_Profile(c *C) {
+	out := s.run(c, profileD, haiconf.CommandArgs{
+		"Name":  "JAVA_HOME",
+		"Value": "/usr/lib/jvm/default-java",
+		"Scope": ENV_SCOPE_PROFILE,
+	})
+
+	expected := `# set by the JDK package
+export JAVA_HOME='/usr/lib/jvm/default-java'
+export JAVA_OPTS="-Xmx512m"
+`
+	c.Assert(out, Equals, expected)
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_Absent(c *C) {
+	out := s.run(c, profileD, haiconf.CommandArgs{
+		"Name":   "JAVA_OPTS",
+		"Scope":  ENV_SCOPE_PROFILE,
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(out, Equals, "# set by the JDK package\nexport JAVA_HOME=/usr/lib/jvm/java-6\n")
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_AbsentMissingFile(c *C) {
+	err := s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "JAVA_OPTS",
+		"Ensure": haiconf.ENSURE_ABSENT,
+		"Path":   c.MkDir() + "/environment",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.e.Run()
+	c.Assert(err, IsNil)
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_UserNewFile(c *C) {
+	p := c.MkDir() + "/.profile"
+
+	err := s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "EDITOR",
+		"Value":  "vim",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Scope":  ENV_SCOPE_USER,
+		"User":   currentUser.Username,
+		"Path":   p,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.e.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "export EDITOR='vim'\n")
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_UserNewFileOwner(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("creating a file for another user requires root")
+	}
+
+	p := c.MkDir() + "/.profile"
+
+	err := s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "EDITOR",
+		"Value":  "vim",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Scope":  ENV_SCOPE_USER,
+		"User":   "65534",
+		"Path":   p,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.e.Run()
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(p)
+	c.Assert(err, IsNil)
+	st := fi.Sys().(*syscall.Stat_t)
+	c.Assert(st.Uid, Equals, uint32(65534))
+	c.Assert(st.Gid, Equals, uint32(65534))
+	c.Assert(fi.Mode().Perm(), Equals, DEFAULT_MODE_FILE)
+}
+
+func (s *EnvironmentVariableTestSuite) TestRun_UserSymlink(c *C) {
+	tmpDir := c.MkDir()
+	p := tmpDir + "/.profile"
+
+	err := ioutil.WriteFile(tmpDir+"/shadow", []byte("root:secret\n"), 0600)
+	c.Assert(err, IsNil)
+
+	err = os.Symlink(tmpDir+"/shadow", p)
+	c.Assert(err, IsNil)
+
+	err = s.e.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "EDITOR",
+		"Value":  "vim",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Scope":  ENV_SCOPE_USER,
+		"User":   currentUser.Username,
+		"Path":   p,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.e.Run()
+	c.Assert(err, ErrorMatches, ".*/.profile is a symbolic link, refusing to edit it for .*")
+
+	buff, err := ioutil.ReadFile(tmpDir + "/shadow")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "root:secret\n")
+}
@@ -6,11 +6,47 @@
 //
 // File({
 //     Path     = "/etc/ssh/ssh_config",
+//     Ensure   = "present",
+//
+//     -- octal, with optional setuid/setgid/sticky bits ("4755"), or
+//     -- symbolic ("u=rw,g=r,o=")
 //     Mode     = "0644",
+//
+//     -- optional, ownership is left unchanged when missing.
+//     -- Numeric ids are accepted for users unknown to NSS yet.
 //     Owner    = "root",
 //     Group    = "root",
-//     Ensure   = "present",
+//
+//     -- applied to the parent directories created for Path,
+//     -- regardless of the process umask
+//     ParentMode  = "0755",
+//     ParentOwner = "root",
+//     ParentGroup = "root",
+//
 //     Source = "/absolute/path/to/templates/etc/ssh_config",
+//
+//     -- or a list of sources, the first one which exists is used:
+//     Source = {
+//         "/absolute/path/to/templates/host-specific/ssh_config",
+//         "/absolute/path/to/templates/default/ssh_config",
+//     },
+//
+//     -- or a remote source, Checksum is mandatory in that case.
+//     -- sha256 is used when no algorithm is given:
+//     Source = "https://example.com/ssh_config",
+//     Checksum = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+//
+//     -- remote sources can be listed as mirrors. Checksum is verified
+//     -- for each of them so they must all serve the same content, a
+//     -- mirror serving anything else fails instead of falling back:
+//     Source = {
+//         "https://mirror-1.example.com/ssh_config",
+//         "https://mirror-2.example.com/ssh_config",
+//     },
+//
+//     -- or the content itself, for small files:
+//     Content = "some content",
+//
 //     TemplateVariables = {
 //         "VarString" = "some string",
 //         "VarBoolean" = false,
@@ -18,21 +54,50 @@
 //         "VarFloat" = 3.14,
 //         "VarTable" = {"one", "two", "three"},
 //         "VarMap" = {"a":"1", "b": "2"},
-//     }
+//     },
+//
+//     -- variables can also be loaded from JSON, YAML or TOML files.
+//     -- Files are merged in order then TemplateVariables are applied.
+//     TemplateVariablesFromFile = {
+//         "/absolute/path/to/vars/defaults.yaml",
+//         "/absolute/path/to/vars/generated.json",
+//     },
+//
+//     -- every file in this directory can be included in the template
+//     -- with {{template "file-name"}}
+//     TemplatesDir = "/absolute/path/to/templates/partials",
+//
+//     -- fail when the template uses a variable which is not defined
+//     StrictTemplate = true,
+//
+//     -- check the new content before installing it. The command is
+//     -- run by /bin/sh and must contain %s exactly once, it is replaced
+//     -- by the quoted path of a temporary file holding that content
+//     Validate = "/usr/sbin/visudo -cf %s",
+//     Validate = "sh -c 'nginx -t -c %s'",
+//
+//     -- optional, see Attributes
+//     Xattrs    = {["user.origin"] = "haiconf"},
+//     Immutable = true,
 // })
+//
+// See Template for the functions available in templates. An immutable
+// file is unlocked while haiconf rewrites it then locked again.
 
 package fs
 
 import (
+	"fmt"
 	"github.com/jeromer/haiconf/hacks"
 	"github.com/jeromer/haiconf/haiconf"
-	"io"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"github.com/jeromer/haiconf/haiconf/osutils"
+	"github.com/jeromer/haiconf/haiconf/utils/httpget"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"path"
-	"strconv"
-	"text/template"
+	"strings"
 )
 
 type File struct {
@@ -41,9 +106,25 @@ type File struct {
 	Ensure string
 	Owner  *user.User
 	Group  *hacks.Group
-	Source string
+
+	// Source is the source actually used to build the file, it is
+	// chosen among Sources when the file is created
+	Source   string
+	Sources  []string
+	Checksum string
+	Content  string
 
 	TemplateVariables map[string]interface{}
+	TemplatesDir      string
+	StrictTemplate    bool
+
+	Validate string
+
+	ParentMode  os.FileMode
+	ParentOwner *user.User
+	ParentGroup *hacks.Group
+
+	Attributes
 
 	rc *haiconf.RuntimeConfig
 }
@@ -52,11 +133,12 @@ func (f *File) SetDefault(rc *haiconf.RuntimeConfig) error {
 	*f = File{
 		Path:              "",
 		Mode:              DEFAULT_MODE_FILE,
-		Owner:             new(user.User),
-		Group:             new(hacks.Group),
+		Owner:             nil,
+		Group:             nil,
 		Ensure:            haiconf.ENSURE_PRESENT,
 		Source:            "",
 		TemplateVariables: nil,
+		ParentMode:        DEFAULT_MODE_DIRECTORY,
 		rc:                rc,
 	}
 
@@ -93,16 +175,45 @@ func (f *File) SetUserConfig(args haiconf.CommandArgs) error {
 		return err
 	}
 
-	err = f.setSource(args)
+	err = f.setParent(args)
+	if err != nil {
+		return err
+	}
+
+	err = f.setContent(args)
 	if err != nil {
 		return err
 	}
 
+	if f.Content == "" {
+		err = f.setSource(args)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = f.setTemplateVariables(args)
 	if err != nil {
 		return err
 	}
 
+	err = f.setTemplatesDir(args)
+	if err != nil {
+		return err
+	}
+
+	f.StrictTemplate = haiconf.CheckBool("StrictTemplate", args)
+
+	err = f.setValidate(args)
+	if err != nil {
+		return err
+	}
+
+	err = f.setAttributes(args)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -110,33 +221,116 @@ func (f *File) Run() error {
 	// XXX : acquire/release lock
 	if f.Ensure == haiconf.ENSURE_ABSENT {
 		haiconf.Output(f.rc, "Removing file %s", f.Path)
+		if f.rc.DryRun {
+			return nil
+		}
+
+		err := f.backup()
+		if err != nil {
+			return err
+		}
+
+		_, err = unlockFile(f.rc, f.Path)
+		if err != nil {
+			return err
+		}
+
 		return os.Remove(f.Path)
 	}
 
-	err := MkDir(path.Dir(f.Path), true, 0755)
+	buff, err := f.render()
 	if err != nil {
 		return err
 	}
 
-	haiconf.Output(f.rc, "Creating file %s", f.Path)
-	err = f.storeFile()
-	if err != nil {
+	current, err := ioutil.ReadFile(f.Path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	haiconf.Output(f.rc, "Chmod %s on %s", f.Mode, f.Path)
-	err = Chmod(f.Path, f.Mode)
+	contentChanged := !exists || Checksum(current) != Checksum(buff)
+
+	metaChanged := true
+	if exists {
+		metaChanged, err = MetadataDiffers(f.Path, f.Mode, f.Owner, f.Group)
+		if err != nil {
+			return err
+		}
+	}
+
+	attrsChanged := len(f.Xattrs) > 0 || f.manageFlags
+	if exists {
+		attrsChanged, err = f.attributesDiffer(f.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contentChanged && !metaChanged && !attrsChanged {
+		haiconf.Output(f.rc, "File %s is unchanged", f.Path)
+		return nil
+	}
+
+	if exists && contentChanged {
+		f.showDiff(current, buff)
+	}
+
+	if f.rc.DryRun {
+		haiconf.Output(f.rc, "Writing file %s", f.Path)
+		haiconf.Output(f.rc, "Chmod %s on %s", f.Mode, f.Path)
+		haiconf.Output(f.rc, "Chown %s on %s", ownership(f.Owner, f.Group), f.Path)
+		f.outputAttributes(f.rc, f.Path)
+		return nil
+	}
+
+	if exists && contentChanged {
+		err = f.backup()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = MkDirAll(path.Dir(f.Path), f.ParentMode, f.ParentOwner, f.ParentGroup)
 	if err != nil {
 		return err
 	}
 
-	haiconf.Output(f.rc, "Chown %s:%s on %s", f.Owner.Username, f.Group.Name, f.Path)
-	err = Chown(f.Path, f.Owner, f.Group)
+	return f.withAttributes(f.rc, f.Path, func() error {
+		return f.write(buff, contentChanged, metaChanged)
+	})
+}
+
+func (f *File) write(buff []byte, contentChanged bool, metaChanged bool) error {
+	if contentChanged {
+		// the file is replaced, a missing Owner or Group must not
+		// reset the ownership to the one of the haiconf process
+		usr, grp, err := keepOwnership(f.Path, f.Owner, f.Group)
+		if err != nil {
+			return err
+		}
+
+		// mode and owner are set on the temporary file before it is
+		// renamed so the file never appears with wrong permissions
+		haiconf.Output(f.rc, "Writing file %s", f.Path)
+		return WriteFileAtomicValidated(f.Path, buff, f.Mode, usr, grp, f.validate)
+	}
+
+	if !metaChanged {
+		return nil
+	}
+
+	uid, gid, err := lookupIds(f.Owner, f.Group)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if f.Owner != nil || f.Group != nil {
+		haiconf.Output(f.rc, "Chown %s on %s", ownership(f.Owner, f.Group), f.Path)
+	}
+
+	haiconf.Output(f.rc, "Chmod %s on %s", f.Mode, f.Path)
+	return haiconf.SetOwnerAndMode(haiconf.FilePath(f.Path), uid, gid, f.Mode)
 }
 
 func (f *File) setPath(args haiconf.CommandArgs) error {
@@ -162,17 +356,22 @@ func (f *File) setEnsure(args haiconf.CommandArgs) error {
 }
 
 func (f *File) setMode(args haiconf.CommandArgs) error {
-	m, err := haiconf.CheckInt64("Mode", args)
+	m, err := haiconf.CheckFileMode("Mode", args)
 	if err != nil {
 		return err
 	}
 
-	f.Mode = os.FileMode(m)
+	f.Mode = m
 
 	return nil
 }
 
 func (f *File) setOwner(args haiconf.CommandArgs) error {
+	_, present := args["Owner"]
+	if !present {
+		return nil
+	}
+
 	u, err := haiconf.CheckSystemUser("Owner", args)
 	if err != nil {
 		return err
@@ -183,6 +382,11 @@ func (f *File) setOwner(args haiconf.CommandArgs) error {
 }
 
 func (f *File) setGroup(args haiconf.CommandArgs) error {
+	_, present := args["Group"]
+	if !present {
+		return nil
+	}
+
 	grp, err := haiconf.CheckSystemGroup("Group", args)
 	if err != nil {
 		return err
@@ -192,72 +396,269 @@ func (f *File) setGroup(args haiconf.CommandArgs) error {
 	return nil
 }
 
-func (f *File) setTemplateVariables(args haiconf.CommandArgs) error {
-	tv, _ := args["TemplateVariables"].(map[string]interface{})
-	l := len(tv)
+func (f *File) setParent(args haiconf.CommandArgs) error {
+	var err error
 
-	if l <= 0 {
-		return nil
+	_, present := args["ParentMode"]
+	if present {
+		f.ParentMode, err = haiconf.CheckFileMode("ParentMode", args)
+		if err != nil {
+			return err
+		}
 	}
 
-	tmp := make(map[string]interface{}, l)
-
-	for k, v := range tv {
-		switch v.(type) {
-		case string:
-			if v == "true" || v == "false" {
-				b, err := strconv.ParseBool(v.(string))
-				if err != nil {
-					return err
-				}
-
-				tmp[k] = b
-				continue
-			}
+	_, present = args["ParentOwner"]
+	if present {
+		f.ParentOwner, err = haiconf.CheckSystemUser("ParentOwner", args)
+		if err != nil {
+			return err
+		}
+	}
 
-			tmp[k] = v
-		default:
-			tmp[k] = v
+	_, present = args["ParentGroup"]
+	if present {
+		f.ParentGroup, err = haiconf.CheckSystemGroup("ParentGroup", args)
+		if err != nil {
+			return err
 		}
 	}
 
-	f.TemplateVariables = tmp
 	return nil
 }
 
-func (f *File) setSource(args haiconf.CommandArgs) error {
-	src, err := haiconf.CheckAbsolutePath("Source", args)
+func (f *File) setTemplateVariables(args haiconf.CommandArgs) error {
+	tv, err := checkTemplateVariables(args)
 	if err != nil {
 		return err
 	}
 
-	f.Source = src
+	f.TemplateVariables = tv
 	return nil
 }
 
-func (f *File) storeFile() error {
-	from, err := os.Open(f.Source)
+func (f *File) setTemplatesDir(args haiconf.CommandArgs) error {
+	d, err := checkTemplatesDir(args)
 	if err != nil {
 		return err
 	}
 
-	to, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode)
+	f.TemplatesDir = d
+	return nil
+}
+
+func (f *File) setValidate(args haiconf.CommandArgs) error {
+	_, present := args["Validate"]
+	if !present {
+		return nil
+	}
+
+	v, err := haiconf.CheckString("Validate", args)
 	if err != nil {
 		return err
 	}
 
-	if f.TemplateVariables == nil {
-		// XXX : check bytes written ?
-		_, err = io.Copy(to, from)
+	if strings.Count(v, "%s") != 1 {
+		return haiconf.NewArgError("Validate must contain %s exactly once", args)
+	}
+
+	f.Validate = v
+	return nil
+}
+
+func (f *File) setContent(args haiconf.CommandArgs) error {
+	c, _ := args["Content"].(string)
+	f.Content = c
+
+	return nil
+}
+
+func (f *File) setSource(args haiconf.CommandArgs) error {
+	sources, err := haiconf.CheckStringList("Source", args)
+	if err != nil {
+		src, err := haiconf.CheckString("Source", args)
+		if err != nil {
+			return haiconf.NewArgError("Either Source or Content must be provided", args)
+		}
+
+		f.Source = src
+		sources = []string{src}
+	}
+
+	if len(sources) == 0 {
+		return haiconf.NewArgError("Source must not be empty", args)
+	}
+
+	hasUrl := false
+	for _, src := range sources {
+		if httpget.IsUrl(src) {
+			hasUrl = true
+			continue
+		}
+
+		if !path.IsAbs(src) {
+			return haiconf.NewArgError("Source must be absolute or an http(s) URL", args)
+		}
+	}
+
+	f.Sources = sources
+
+	if !hasUrl {
+		return nil
+	}
+
+	sum, err := haiconf.CheckString("Checksum", args)
+	if err != nil {
+		return haiconf.NewArgError("Checksum must be provided for remote sources", args)
+	}
+
+	_, _, err = ParseChecksum(sum)
+	if err != nil {
+		return haiconf.NewArgError(err.Error(), args)
+	}
+
+	f.Checksum = sum
+
+	return nil
+}
+
+// render returns the content the file must have, that is the
+// source file itself or the result of its template execution.
+// Any template option makes the source a template.
+func (f *File) render() ([]byte, error) {
+	buff, err := f.readSource()
+	if err != nil {
+		return nil, err
+	}
+
+	isTemplate := f.TemplateVariables != nil || f.TemplatesDir != "" || f.StrictTemplate
+	if !isTemplate {
+		return buff, nil
+	}
+
+	name := f.Source
+	if f.Content != "" {
+		name = path.Base(f.Path) + "-content"
+	}
+
+	t := Template{
+		Name:        name,
+		PartialsDir: f.TemplatesDir,
+		Strict:      f.StrictTemplate,
+	}
+
+	buff, err = t.Render(buff, f.TemplateVariables)
+	if err != nil {
+		herr, ok := err.(*haiconf.HaiconfError)
+		if ok {
+			herr.Args["Path"] = f.Path
+		}
+
+		return nil, err
+	}
+
+	return buff, nil
+}
+
+// readSource returns the inline content or the content of the
+// first available source.
+func (f *File) readSource() ([]byte, error) {
+	if f.Content != "" {
+		return []byte(f.Content), nil
+	}
+
+	var lastErr error
+
+	for _, src := range f.Sources {
+		buff, err := f.fetch(src)
+		if err == nil {
+			f.Source = src
+			return buff, nil
+		}
+
+		// a wrong checksum must never fall back to another source
+		_, isChecksumErr := err.(*ChecksumError)
+		if isChecksumErr {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	if len(f.Sources) == 1 {
+		return nil, lastErr
+	}
+
+	return nil, fmt.Errorf("None of the sources %s could be read. Last error was : %s", strings.Join(f.Sources, ", "), lastErr)
+}
+
+func (f *File) fetch(src string) ([]byte, error) {
+	if !httpget.IsUrl(src) {
+		return ioutil.ReadFile(src)
+	}
+
+	haiconf.Output(f.rc, "Downloading %s", src)
+	buff, err := httpget.Fetch(src)
+	if err != nil {
+		return nil, err
+	}
+
+	err = VerifyChecksum(src, buff, f.Checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff, nil
+}
+
+// showDiff outputs the differences between the current file
+// and what is going to be written.
+func (f *File) showDiff(current []byte, buff []byte) {
+	diff := UnifiedDiff(f.Path, f.Path, current, buff)
+	haiconf.Output(f.rc, "%s", strings.TrimRight(diff, "\n"))
+}
+
+// validate runs the Validate command against tmp, the file which
+// is about to replace f.Path
+func (f *File) validate(tmp string) error {
+	if f.Validate == "" {
+		return nil
+	}
+
+	// the shell splits the command, quotes included
+	sc := osutils.SystemCommand{
+		Path:                 strings.Replace(f.Validate, "%s", shellQuote(tmp), 1),
+		ExecDir:              os.TempDir(),
+		EnableShellExpansion: true,
+	}
+
+	haiconf.Output(f.rc, "Validating %s with %s", f.Path, f.Validate)
+
+	output := sc.Run()
+	if output.HasError() {
+		return haiconf.NewArgError("Validation failed, "+f.Path+" was not modified. "+output.Error(), haiconf.CommandArgs{
+			"Path":     f.Path,
+			"Validate": f.Validate,
+		})
+	}
+
+	return nil
+}
+
+func (f *File) backup() error {
+	// the file is written through a symbolic link, so is the backup
+	p, err := resolveSymlink(f.Path)
+	if err != nil {
 		return err
 	}
 
-	buff, err := ioutil.ReadAll(from)
+	e, err := backup.NewBucket(f.rc.BackupDir, f.rc.RunId).StoreFile(p)
 	if err != nil {
 		return err
 	}
 
-	tpl := template.New(path.Base(f.Path) + "-template")
-	t := template.Must(tpl.Parse(string(buff)))
-	return t.Execute(to, f.TemplateVariables)
+	if e != nil {
+		haiconf.Output(f.rc, "Backed up %s as %s", f.Path, e.Id)
+	}
+
+	return nil
 }
@@ -5,13 +5,19 @@
 package fs
 
 import (
+	"bytes"
 	"github.com/jeromer/haiconf/hacks"
 	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
 	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/user"
 	"path"
+	"strings"
+	"syscall"
 )
 
 type FileTestSuite struct {
@@ -29,10 +35,11 @@ func (s *FileTestSuite) TestSetDefault(c *C) {
 	expected := &File{
 		Path:              "",
 		Mode:              DEFAULT_MODE_FILE,
-		Owner:             new(user.User),
-		Group:             new(hacks.Group),
+		Owner:             nil,
+		Group:             nil,
 		Ensure:            haiconf.ENSURE_PRESENT,
 		TemplateVariables: nil,
+		ParentMode:        DEFAULT_MODE_DIRECTORY,
 		rc:                &dummyRuntimeConfig,
 	}
 
@@ -84,8 +91,8 @@ func (s *FileTestSuite) TestSetUserConfig_Absent(c *C) {
 
 	// Since we want to remove the directory we do not care about
 	// the value of attributes below
-	c.Assert(s.f.Owner, DeepEquals, new(user.User))
-	c.Assert(s.f.Group, DeepEquals, new(hacks.Group))
+	c.Assert(s.f.Owner, IsNil)
+	c.Assert(s.f.Group, IsNil)
 	c.Assert(s.f.TemplateVariables, IsNil)
 	c.Assert(s.f.Source, Equals, "")
 }
@@ -199,6 +206,26 @@ func (s *FileTestSuite) TestRun_CreateApplyTemplates(c *C) {
 	c.Assert(string(obtained), DeepEquals, expected)
 }
 
+func (s *FileTestSuite) TestRun_StrictTemplateWithoutVariables(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":           tmpFile,
+		"Ensure":         haiconf.ENSURE_PRESENT,
+		"Mode":           "0644",
+		"Content":        "{{.Missing}}",
+		"StrictTemplate": true,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, ErrorMatches, "Unable to render template : .*")
+	c.Assert(err.(*haiconf.HaiconfError).Args["Path"], Equals, tmpFile)
+
+	_, err = os.Stat(tmpFile)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
 func (s *FileTestSuite) TestRun_Remove(c *C) {
 	fileName := "foo.txt"
 	tmpFile := c.MkDir() + "/" + fileName
@@ -220,6 +247,339 @@ func (s *FileTestSuite) TestRun_Remove(c *C) {
 	c.Assert(f, IsNil)
 }
 
+func (s *FileTestSuite) TestRun_DryRunShowsDiff(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	tmpFile := c.MkDir() + "/foo.txt"
+	err = ioutil.WriteFile(tmpFile, []byte("Some old content.\n"), 0644)
+	c.Assert(err, IsNil)
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+	s.f.SetDefault(&rc)
+
+	err = s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0600",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Source": cwd + "/testdata/nontemplate.txt",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	expected := strings.Join([]string{
+		"--- " + tmpFile,
+		"+++ " + tmpFile,
+		"@@ -1 +1 @@",
+		"-Some old content.",
+		"+Some file with no template variables.",
+		"",
+	}, "\n")
+	c.Assert(strings.Contains(output.String(), expected), Equals, true)
+
+	// nothing must have been changed
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "Some old content.\n")
+
+	f, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(f.Mode().Perm(), Equals, os.FileMode(0644).Perm())
+}
+
+func (s *FileTestSuite) TestRun_DryRunDoesNotRemove(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+	err := ioutil.WriteFile(tmpFile, []byte{}, 0644)
+	c.Assert(err, IsNil)
+
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: new(bytes.Buffer),
+	}
+	s.f.SetDefault(&rc)
+
+	err = s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+}
+
+func (s *FileTestSuite) TestRun_BackupBeforeOverwrite(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	tmpFile := c.MkDir() + "/foo.txt"
+	err = ioutil.WriteFile(tmpFile, []byte("Some old content.\n"), 0644)
+	c.Assert(err, IsNil)
+
+	rc := haiconf.RuntimeConfig{
+		BackupDir: c.MkDir(),
+		RunId:     "run-1",
+	}
+	s.f.SetDefault(&rc)
+
+	err = s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0644",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Source": cwd + "/testdata/nontemplate.txt",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	bucket := backup.NewBucket(rc.BackupDir, rc.RunId)
+	e, err := bucket.Find(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(e.RunId, Equals, "run-1")
+
+	buff, err := bucket.Content(e)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "Some old content.\n")
+}
+
+func (s *FileTestSuite) TestRun_Unchanged(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	tmpDir := c.MkDir()
+	tmpFile := tmpDir + "/foo.txt"
+
+	args := haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0644",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Source": cwd + "/testdata/nontemplate.txt",
+	}
+
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	before, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		Verbose: true,
+		Output:  output,
+	}
+	s.f.SetDefault(&rc)
+
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+	c.Assert(output.String(), Equals, "File "+tmpFile+" is unchanged\n")
+
+	after, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(before, after), Equals, true)
+	c.Assert(after.ModTime(), Equals, before.ModTime())
+
+	// no temporary file left behind
+	names, err := ioutil.ReadDir(tmpDir)
+	c.Assert(err, IsNil)
+	c.Assert(len(names), Equals, 1)
+}
+
+func (s *FileTestSuite) TestRun_OnlyModeChanged(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	sourceFile := cwd + "/testdata/nontemplate.txt"
+	content, err := ioutil.ReadFile(sourceFile)
+	c.Assert(err, IsNil)
+
+	tmpFile := c.MkDir() + "/foo.txt"
+	err = ioutil.WriteFile(tmpFile, content, 0644)
+	c.Assert(err, IsNil)
+
+	before, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+
+	err = s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0600",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Source": sourceFile,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	after, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(before, after), Equals, true)
+	c.Assert(after.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *FileTestSuite) TestSetUserConfig_NoSourceNorContent(c *C) {
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/foo.txt",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0644",
+		"Owner":  "nobody",
+		"Group":  "nogroup",
+	})
+	c.Assert(err, ErrorMatches, "Either Source or Content must be provided. (.*)")
+}
+
+func (s *FileTestSuite) TestSetUserConfig_RemoteSourceWithoutChecksum(c *C) {
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/foo.txt",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0644",
+		"Owner":  "nobody",
+		"Group":  "nogroup",
+		"Source": "http://example.com/foo.txt",
+	})
+	c.Assert(err, ErrorMatches, "Checksum must be provided for remote sources. (.*)")
+}
+
+func (s *FileTestSuite) TestRun_Content(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":    tmpFile,
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Mode":    "0644",
+		"Owner":   currentUser.Username,
+		"Group":   dummyGroup,
+		"Content": "{{.String}}\n",
+		"TemplateVariables": map[string]interface{}{
+			"String": "foo",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "foo\n")
+}
+
+func (s *FileTestSuite) TestRun_FirstExistingSourceWins(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	tmpFile := c.MkDir() + "/foo.txt"
+	sourceFile := cwd + "/testdata/nontemplate.txt"
+
+	err = s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0644",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Source": []interface{}{
+			cwd + "/testdata/does-not-exist.txt",
+			sourceFile,
+			cwd + "/testdata/template.txt",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Source, Equals, sourceFile)
+
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	expected, err := ioutil.ReadFile(sourceFile)
+	c.Assert(err, IsNil)
+
+	c.Assert(obtained, DeepEquals, expected)
+}
+
+func (s *FileTestSuite) TestRun_RemoteSource(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("foo"))
+	}))
+	defer srv.Close()
+
+	tmpFile := c.MkDir() + "/foo.txt"
+	args := haiconf.CommandArgs{
+		"Path":     tmpFile,
+		"Ensure":   haiconf.ENSURE_PRESENT,
+		"Mode":     "0644",
+		"Owner":    currentUser.Username,
+		"Group":    dummyGroup,
+		"Source":   srv.URL + "/foo.txt",
+		"Checksum": "sha1:0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33",
+	}
+
+	err := s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "foo")
+
+	// sha256 of "bar"
+	args["Checksum"] = "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, ErrorMatches, "Checksum mismatch for .*")
+}
+
+func (s *FileTestSuite) TestSetTemplateVariables_FromFile(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	err = s.f.setTemplateVariables(haiconf.CommandArgs{
+		"TemplateVariablesFromFile": []interface{}{
+			cwd + "/testdata/variables/defaults.yaml",
+			cwd + "/testdata/variables/generated.json",
+		},
+		"TemplateVariables": map[string]interface{}{
+			"Debug": "true",
+		},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.f.TemplateVariables["Debug"], Equals, true)
+	c.Assert(s.f.TemplateVariables["Workers"], Equals, float64(4))
+
+	db := s.f.TemplateVariables["Database"].(map[string]interface{})
+	c.Assert(db["Host"], Equals, "db.example.com")
+	c.Assert(db["Port"], Equals, 5432)
+}
+
 func (s *FileTestSuite) TestSetTemplateVariables_NoVariables(c *C) {
 	err := s.f.setTemplateVariables(haiconf.CommandArgs{})
 	c.Assert(err, IsNil)
@@ -243,3 +603,232 @@ func (s *FileTestSuite) TestSetTemplateVariables_VariablesConverted(c *C) {
 	}
 	c.Assert(s.f.TemplateVariables, DeepEquals, expected)
 }
+
+func (s *FileTestSuite) TestSetUserConfig_ValidateWithoutPlaceholder(c *C) {
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":     "/etc/sudoers",
+		"Ensure":   haiconf.ENSURE_PRESENT,
+		"Mode":     "0440",
+		"Owner":    currentUser.Username,
+		"Group":    dummyGroup,
+		"Content":  "foo",
+		"Validate": "/usr/sbin/visudo -c",
+	})
+	c.Assert(err, ErrorMatches, "Validate must contain %s exactly once(.*)")
+}
+
+func (s *FileTestSuite) TestRun_Validate(c *C) {
+	tmpFile := c.MkDir() + "/sudoers"
+	err := ioutil.WriteFile(tmpFile, []byte("old\n"), 0644)
+	c.Assert(err, IsNil)
+
+	args := haiconf.CommandArgs{
+		"Path":     tmpFile,
+		"Ensure":   haiconf.ENSURE_PRESENT,
+		"Mode":     "0644",
+		"Owner":    currentUser.Username,
+		"Group":    dummyGroup,
+		"Content":  "broken\n",
+		"Validate": "/bin/grep -q valid %s",
+	}
+
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, ErrorMatches, "Validation failed, (.*) was not modified(.*)")
+
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "old\n")
+
+	// the temporary file is removed
+	files, err := ioutil.ReadDir(path.Dir(tmpFile))
+	c.Assert(err, IsNil)
+	c.Assert(len(files), Equals, 1)
+
+	args["Content"] = "valid\n"
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	obtained, err = ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "valid\n")
+}
+
+func (s *FileTestSuite) TestRun_ValidateQuotedCommand(c *C) {
+	tmpFile := c.MkDir() + "/nginx.conf"
+
+	args := haiconf.CommandArgs{
+		"Path":     tmpFile,
+		"Ensure":   haiconf.ENSURE_PRESENT,
+		"Mode":     "0644",
+		"Content":  "broken\n",
+		"Validate": `sh -c "grep -q 'valid config' %s"`,
+	}
+
+	err := s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, ErrorMatches, "Validation failed, (.*) was not modified(.*)")
+
+	_, err = os.Stat(tmpFile)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	args["Content"] = "valid config\n"
+
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "valid config\n")
+}
+
+func (s *FileTestSuite) TestRun_OwnerAndGroupAreOptional(c *C) {
+	tmpFile := c.MkDir() + "/foo.txt"
+
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":    tmpFile,
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Mode":    "u=rw,g=r,o=",
+		"Content": "foo",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Owner, IsNil)
+	c.Assert(s.f.Group, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0640))
+}
+
+func (s *FileTestSuite) TestRun_ContentChangeKeepsOwnership(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("changing the owner of a file requires root")
+	}
+
+	tmpFile := c.MkDir() + "/foo.txt"
+	err := ioutil.WriteFile(tmpFile, []byte("old"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.Chown(tmpFile, 65534, 65534)
+	c.Assert(err, IsNil)
+
+	err = s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":    tmpFile,
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Mode":    "0644",
+		"Content": "new",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	obtained, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "new")
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+
+	st := fi.Sys().(*syscall.Stat_t)
+	c.Assert(st.Uid, Equals, uint32(65534))
+	c.Assert(st.Gid, Equals, uint32(65534))
+}
+
+func (s *FileTestSuite) TestRun_SpecialBitsWithOwner(c *C) {
+	tmpFile := c.MkDir() + "/foo.sh"
+
+	args := haiconf.CommandArgs{
+		"Path":    tmpFile,
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Mode":    "0755",
+		"Content": "foo",
+	}
+
+	err := s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	// metadata only, chown must not clear the setuid bit
+	args["Mode"] = "4755"
+	args["Owner"] = currentUser.Uid
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0755)|os.ModeSetuid)
+}
+
+func (s *FileTestSuite) TestRun_SpecialBits(c *C) {
+	tmpFile := c.MkDir() + "/foo.sh"
+
+	args := haiconf.CommandArgs{
+		"Path":    tmpFile,
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Mode":    "0755",
+		"Content": "foo",
+	}
+
+	err := s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	// only the setgid bit changes
+	args["Mode"] = "2755"
+	err = s.f.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode(), Equals, os.FileMode(0755)|os.ModeSetgid)
+}
+
+func (s *FileTestSuite) TestRun_ParentMode(c *C) {
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+
+	tmpDir := c.MkDir()
+
+	err := s.f.SetUserConfig(haiconf.CommandArgs{
+		"Path":        tmpDir + "/a/b/foo.txt",
+		"Ensure":      haiconf.ENSURE_PRESENT,
+		"Mode":        "0644",
+		"Content":     "foo",
+		"ParentMode":  "0750",
+		"ParentOwner": currentUser.Uid,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.f.Run()
+	c.Assert(err, IsNil)
+
+	for _, d := range []string{"/a", "/a/b"} {
+		fi, err := os.Stat(tmpDir + d)
+		c.Assert(err, IsNil)
+		c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0750))
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Host({
+//     Ip      = "10.0.0.12",
+//     Name    = "db1.example.com",
+//     Aliases = {"db1", "db"},
+//     Ensure  = "present",
+//
+//     -- optional, /etc/hosts by default
+//     Path    = "/etc/hosts",
+// })
+//
+// Only the entry for Ip is managed. Name and Aliases are added to it,
+// names already listed there are kept. When absent, Name and Aliases
+// are removed from it and the entry is dropped once it has no host
+// name left. Other entries are never modified, even when they list
+// the same names, so entries such as "::1 localhost" or cloud-init's
+// "127.0.1.1 <hostname>" are preserved.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	DEFAULT_HOSTS_PATH = "/etc/hosts"
+)
+
+type Host struct {
+	Path    string
+	Ip      string
+	Name    string
+	Aliases []string
+	Ensure  string
+
+	rc *haiconf.RuntimeConfig
+}
+
+type hostEntry struct {
+	ip      string
+	names   []string
+	comment string
+}
+
+func (h *Host) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*h = Host{
+		Path:    DEFAULT_HOSTS_PATH,
+		Ip:      "",
+		Name:    "",
+		Aliases: nil,
+		Ensure:  haiconf.ENSURE_PRESENT,
+		rc:      rc,
+	}
+
+	return nil
+}
+
+func (h *Host) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		h.setPath,
+		h.setEnsure,
+		h.setIp,
+		h.setName,
+		h.setAliases,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Host) Run() error {
+	_, err := os.Stat(h.Path)
+	if h.Ensure == haiconf.ENSURE_ABSENT && os.IsNotExist(err) {
+		return nil
+	}
+
+	return editFile(h.rc, h.Path, true, DEFAULT_MODE_FILE, func(buff []byte) ([]byte, error) {
+		return joinFileLines(h.apply(splitFileLines(buff))), nil
+	})
+}
+
+func (h *Host) apply(lines []string) []string {
+	managed := append([]string{h.Name}, h.Aliases...)
+	out := make([]string, 0, len(lines)+1)
+	found := false
+
+	for _, line := range lines {
+		e, isEntry := parseHostEntry(line)
+		if !isEntry {
+			out = append(out, line)
+			continue
+		}
+
+		if !net.ParseIP(e.ip).Equal(net.ParseIP(h.Ip)) {
+			out = append(out, line)
+			continue
+		}
+
+		names := e.names
+
+		switch {
+		case h.Ensure == haiconf.ENSURE_ABSENT:
+			names = removeStrings(names, managed)
+
+		case !found:
+			found = true
+			for _, n := range managed {
+				if !containsString(names, n) {
+					names = append(names, n)
+				}
+			}
+
+		default:
+			// names only have to be listed once for Ip
+			names = removeStrings(names, managed)
+		}
+
+		if len(names) == len(e.names) {
+			out = append(out, line)
+			continue
+		}
+
+		if len(names) == 0 {
+			continue
+		}
+
+		e.names = names
+		out = append(out, e.String())
+	}
+
+	if h.Ensure == haiconf.ENSURE_PRESENT && !found {
+		out = append(out, hostEntry{ip: h.Ip, names: managed}.String())
+	}
+
+	return out
+}
+
+// parseHostEntry reads a line such as "127.0.0.1 localhost # comment",
+// blank lines, comments and lines without host names are not entries
+func parseHostEntry(line string) (hostEntry, bool) {
+	e := hostEntry{}
+
+	idx := strings.Index(line, "#")
+	if idx >= 0 {
+		e.comment = strings.TrimSpace(line[idx:])
+		line = line[:idx]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return e, false
+	}
+
+	e.ip, e.names = fields[0], fields[1:]
+	return e, true
+}
+
+func (e hostEntry) String() string {
+	s := e.ip + "\t" + strings.Join(e.names, " ")
+	if e.comment != "" {
+		s += " " + e.comment
+	}
+
+	return s
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeStrings(l []string, remove []string) []string {
+	out := make([]string, 0, len(l))
+	for _, v := range l {
+		if !containsString(remove, v) {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+func (h *Host) setPath(args haiconf.CommandArgs) error {
+	_, present := args["Path"]
+	if !present {
+		return nil
+	}
+
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	h.Path = p
+	return nil
+}
+
+func (h *Host) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	h.Ensure = e
+	return nil
+}
+
+func (h *Host) setIp(args haiconf.CommandArgs) error {
+	ip, err := haiconf.CheckString("Ip", args)
+	if err != nil {
+		return err
+	}
+
+	if net.ParseIP(ip) == nil {
+		return haiconf.NewArgError(ip+" is not a valid IP address", args)
+	}
+
+	h.Ip = ip
+	return nil
+}
+
+func (h *Host) setName(args haiconf.CommandArgs) error {
+	n, err := haiconf.CheckString("Name", args)
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(n, " \t#") {
+		return haiconf.NewArgError("Invalid host name "+n, args)
+	}
+
+	h.Name = n
+	return nil
+}
+
+func (h *Host) setAliases(args haiconf.CommandArgs) error {
+	_, present := args["Aliases"]
+	if !present {
+		return nil
+	}
+
+	aliases, err := haiconf.CheckStringList("Aliases", args)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range aliases {
+		if a == "" || strings.ContainsAny(a, " \t#") {
+			return haiconf.NewArgError("Invalid alias "+a, args)
+		}
+	}
+
+	h.Aliases = aliases
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+)
+
+type HostTestSuite struct {
+	h *Host
+}
+
+var _ = Suite(&HostTestSuite{})
+
+const etcHosts = `# managed by cloud-init
+127.0.0.1	localhost
+127.0.1.1	web1.example.com web1
+
+# The following lines are desirable for IPv6 capable hosts
+::1     localhost ip6-localhost ip6-loopback
+10.0.0.12	db1.example.com db1 # primary
+10.0.0.13	db2.example.com db
+`
+
+func (s *HostTestSuite) SetUpTest(c *C) {
+	s.h = new(Host)
+	err := s.h.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *HostTestSuite) run(c *C, initial string, args haiconf.CommandArgs) string {
+	tmpFile := c.MkDir() + "/hosts"
+	err := ioutil.WriteFile(tmpFile, []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	args["Path"] = tmpFile
+	if _, present := args["Ensure"]; !present {
+		args["Ensure"] = haiconf.ENSURE_PRESENT
+	}
+
+	err = s.h.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.h.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *HostTestSuite) TestSetDefault(c *C) {
+	c.Assert(s.h.Path, Equals, DEFAULT_HOSTS_PATH)
+	c.Assert(s.h.Ensure, Equals, haiconf.ENSURE_PRESENT)
+}
+
+func (s *HostTestSuite) TestSetUserConfig_InvalidIp(c *C) {
+	err := s.h.SetUserConfig(haiconf.CommandArgs{
+		"Ip":     "10.0.0.300",
+		"Name":   "db1",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "10.0.0.300 is not a valid IP address(.*)")
+}
+
+func (s *HostTestSuite) TestSetUserConfig_NoIp(c *C) {
+	err := s.h.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "db1",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Ip must be provided(.*)")
+
+	err = s.h.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "db1",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, ErrorMatches, "Ip must be provided(.*)")
+}
+
+func (s *HostTestSuite) TestSetUserConfig_InvalidAlias(c *C) {
+	err := s.h.SetUserConfig(haiconf.CommandArgs{
+		"Ip":      "10.0.0.12",
+		"Name":    "db1",
+		"Aliases": []interface{}{"db one"},
+		"Ensure":  haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Invalid alias db one(.*)")
+}
+
+func (s *HostTestSuite) TestRun_New(c *C) {
+	out := s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":      "10.0.0.14",
+		"Name":    "cache1.example.com",
+		"Aliases": []interface{}{"cache1"},
+	})
+	c.Assert(out, Equals, etcHosts+"10.0.0.14\tcache1.example.com cache1\n")
+}
+
+func (s *HostTestSuite) TestRun_MergeAliases(c *C) {
+	out := s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":      "10.0.0.12",
+		"Name":    "db1.example.com",
+		"Aliases": []interface{}{"db1", "db"},
+	})
+
+	expected := `# managed by cloud-init
+127.0.0.1	localhost
+127.0.1.1	web1.example.com web1
+
+# The following lines are desirable for IPv6 capable hosts
+::1     localhost ip6-localhost ip6-loopback
+10.0.0.12	db1.example.com db1 db # primary
+10.0.0.13	db2.example.com db
+`
+	c.Assert(out, Equals, expected)
+}
+
+func (s *HostTestSuite) TestRun_UpToDate(c *C) {
+	out := s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":   "::1",
+		"Name": "ip6-localhost",
+	})
+	c.Assert(out, Equals, etcHosts)
+}
+
+func (s *HostTestSuite) TestRun_OtherEntriesUntouched(c *C) {
+	// cloud-init's entry for the host name is preserved
+	out := s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":      "10.0.0.15",
+		"Name":    "web1.example.com",
+		"Aliases": []interface{}{"web1"},
+	})
+	c.Assert(out, Equals, etcHosts+"10.0.0.15\tweb1.example.com web1\n")
+
+	// as well as the IPv6 loopback
+	out = s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":      "127.0.0.1",
+		"Name":    "localhost",
+		"Aliases": []interface{}{"ip6-localhost"},
+	})
+
+	expected := `# managed by cloud-init
+127.0.0.1	localhost ip6-localhost
+127.0.1.1	web1.example.com web1
+
+# The following lines are desirable for IPv6 capable hosts
+::1     localhost ip6-localhost ip6-loopback
+10.0.0.12	db1.example.com db1 # primary
+10.0.0.13	db2.example.com db
+`
+	c.Assert(out, Equals, expected)
+}
+
+func (s *HostTestSuite) TestRun_DuplicateEntries(c *C) {
+	initial := "10.0.0.12\tdb1 db\n10.0.0.12\tdb\n10.0.0.12\tdb other\n"
+
+	out := s.run(c, initial, haiconf.CommandArgs{
+		"Ip":   "10.0.0.12",
+		"Name": "db",
+	})
+	c.Assert(out, Equals, "10.0.0.12\tdb1 db\n10.0.0.12\tother\n")
+}
+
+func (s *HostTestSuite) TestRun_Absent(c *C) {
+	out := s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":     "10.0.0.13",
+		"Name":   "db",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+
+	expected := `# managed by cloud-init
+127.0.0.1	localhost
+127.0.1.1	web1.example.com web1
+
+# The following lines are desirable for IPv6 capable hosts
+::1     localhost ip6-localhost ip6-loopback
+10.0.0.12	db1.example.com db1 # primary
+10.0.0.13	db2.example.com
+`
+	c.Assert(out, Equals, expected)
+}
+
+func (s *HostTestSuite) TestRun_AbsentWithIp(c *C) {
+	out := s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":      "10.0.0.12",
+		"Name":    "db1.example.com",
+		"Aliases": []interface{}{"db1"},
+		"Ensure":  haiconf.ENSURE_ABSENT,
+	})
+
+	expected := `# managed by cloud-init
+127.0.0.1	localhost
+127.0.1.1	web1.example.com web1
+
+# The following lines are desirable for IPv6 capable hosts
+::1     localhost ip6-localhost ip6-loopback
+10.0.0.13	db2.example.com db
+`
+	c.Assert(out, Equals, expected)
+
+	out = s.run(c, etcHosts, haiconf.CommandArgs{
+		"Ip":     "10.0.0.99",
+		"Name":   "db1.example.com",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(out, Equals, etcHosts)
+}
+
+func (s *HostTestSuite) TestRun_AbsentMissingFile(c *C) {
+	err := s.h.SetUserConfig(haiconf.CommandArgs{
+		"Path":   c.MkDir() + "/hosts",
+		"Ip":     "10.0.0.12",
+		"Name":   "db1",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.h.Run()
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,302 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// IniSetting({
+//     Path    = "/etc/php5/fpm/php.ini",
+//     Section = "PHP",
+//     Key     = "memory_limit",
+//     Value   = "256M",
+//     Ensure  = "present",
+//
+//     -- optional, " = " by default
+//     Separator = "=",
+//
+//     -- create the file when it does not exist
+//     Create = false,
+//     Mode   = "0644",
+// })
+//
+// Keys which appear before the first section are managed with an empty
+// or missing Section. Comments and unrelated lines are left untouched,
+// mode and owner of existing files are preserved.
+
+package fs
+
+import (
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	INI_DEFAULT_SEPARATOR = " = "
+)
+
+type IniSetting struct {
+	Path      string
+	Section   string
+	Key       string
+	Value     string
+	Ensure    string
+	Separator string
+	Create    bool
+	Mode      os.FileMode
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (i *IniSetting) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*i = IniSetting{
+		Path:      "",
+		Section:   "",
+		Key:       "",
+		Value:     "",
+		Ensure:    haiconf.ENSURE_PRESENT,
+		Separator: INI_DEFAULT_SEPARATOR,
+		Create:    false,
+		Mode:      DEFAULT_MODE_FILE,
+		rc:        rc,
+	}
+
+	return nil
+}
+
+func (i *IniSetting) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		i.setPath,
+		i.setSection,
+		i.setKey,
+		i.setEnsure,
+		i.setValue,
+		i.setSeparator,
+		i.setCreate,
+		i.setMode,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *IniSetting) Run() error {
+	_, err := os.Stat(i.Path)
+	if i.Ensure == haiconf.ENSURE_ABSENT && os.IsNotExist(err) {
+		return nil
+	}
+
+	create := i.Create && i.Ensure == haiconf.ENSURE_PRESENT
+
+	return editFile(i.rc, i.Path, create, i.Mode, func(buff []byte) ([]byte, error) {
+		return joinFileLines(i.apply(splitFileLines(buff))), nil
+	})
+}
+
+func (i *IniSetting) apply(lines []string) []string {
+	start, end, found := i.findSection(lines)
+	setting := i.Key + i.Separator + i.Value
+
+	if !found {
+		if i.Ensure == haiconf.ENSURE_ABSENT {
+			return lines
+		}
+
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+
+		return append(lines, "["+i.Section+"]", setting)
+	}
+
+	out := make([]string, 0, len(lines)+1)
+	out = append(out, lines[:start]...)
+
+	// the setting is added after the last non blank line of the section
+	last := start - 1
+	replaced := false
+
+	for n := start; n < end; n++ {
+		line := lines[n]
+
+		if iniKey(line) == i.Key {
+			if i.Ensure == haiconf.ENSURE_PRESENT && !replaced {
+				out = append(out, setting)
+				replaced = true
+			}
+			continue
+		}
+
+		out = append(out, line)
+		if strings.TrimSpace(line) != "" {
+			last = len(out) - 1
+		}
+	}
+
+	if i.Ensure == haiconf.ENSURE_PRESENT && !replaced {
+		pos := last + 1
+		out = append(out, "")
+		copy(out[pos+1:], out[pos:])
+		out[pos] = setting
+	}
+
+	return append(out, lines[end:]...)
+}
+
+// findSection returns the range of the lines belonging to Section,
+// headers excluded. The global section starts at the first line.
+func (i *IniSetting) findSection(lines []string) (int, int, bool) {
+	start, found := 0, i.Section == ""
+
+	for n, line := range lines {
+		name, isHeader := iniSection(line)
+		if !isHeader {
+			continue
+		}
+
+		if found {
+			return start, n, true
+		}
+
+		if name == i.Section {
+			start, found = n+1, true
+		}
+	}
+
+	return start, len(lines), found
+}
+
+func iniSection(line string) (string, bool) {
+	l := strings.TrimSpace(line)
+
+	if !strings.HasPrefix(l, "[") || !strings.HasSuffix(l, "]") {
+		return "", false
+	}
+
+	return strings.TrimSpace(l[1 : len(l)-1]), true
+}
+
+// iniKey returns the key defined on line, comments and
+// lines without a separator have no key
+func iniKey(line string) string {
+	l := strings.TrimSpace(line)
+
+	if l == "" || l[0] == ';' || l[0] == '#' {
+		return ""
+	}
+
+	idx := strings.IndexAny(l, "=:")
+	if idx < 0 {
+		return ""
+	}
+
+	return strings.TrimSpace(l[:idx])
+}
+
+func (i *IniSetting) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	i.Path = p
+	return nil
+}
+
+func (i *IniSetting) setSection(args haiconf.CommandArgs) error {
+	i.Section, _ = args["Section"].(string)
+	return nil
+}
+
+func (i *IniSetting) setKey(args haiconf.CommandArgs) error {
+	k, err := haiconf.CheckString("Key", args)
+	if err != nil {
+		return err
+	}
+
+	i.Key = k
+	return nil
+}
+
+func (i *IniSetting) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	i.Ensure = e
+	return nil
+}
+
+func (i *IniSetting) setValue(args haiconf.CommandArgs) error {
+	if i.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	v, present := args["Value"]
+	if !present {
+		return haiconf.NewArgError("Value must be provided", args)
+	}
+
+	switch t := v.(type) {
+	case float64:
+		// lua numbers are floats, 1048576 must not become 1.048576e+06
+		i.Value = strconv.FormatFloat(t, 'f', -1, 64)
+		return nil
+	case string, bool, int, int64:
+		i.Value = fmt.Sprint(v)
+		return nil
+	}
+
+	return haiconf.NewArgError("Value must be a string, a number or a boolean", args)
+}
+
+func (i *IniSetting) setSeparator(args haiconf.CommandArgs) error {
+	_, present := args["Separator"]
+	if !present {
+		return nil
+	}
+
+	s, err := haiconf.CheckString("Separator", args)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(s) != "=" && strings.TrimSpace(s) != ":" {
+		return haiconf.NewArgError("Separator must contain = or :", args)
+	}
+
+	i.Separator = s
+	return nil
+}
+
+func (i *IniSetting) setCreate(args haiconf.CommandArgs) error {
+	i.Create = haiconf.CheckBool("Create", args)
+	return nil
+}
+
+func (i *IniSetting) setMode(args haiconf.CommandArgs) error {
+	_, present := args["Mode"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckFileMode("Mode", args)
+	if err != nil {
+		return err
+	}
+
+	i.Mode = m
+	return nil
+}
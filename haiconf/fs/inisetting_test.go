@@ -0,0 +1,199 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+)
+
+type IniSettingTestSuite struct {
+	i *IniSetting
+}
+
+var _ = Suite(&IniSettingTestSuite{})
+
+const phpIni = `; global comment
+engine = On
+
+[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 128M
+short_open_tag = Off
+
+[Date]
+;date.timezone =
+`
+
+func (s *IniSettingTestSuite) SetUpTest(c *C) {
+	s.i = new(IniSetting)
+	err := s.i.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *IniSettingTestSuite) run(c *C, args haiconf.CommandArgs) string {
+	tmpFile := c.MkDir() + "/php.ini"
+	err := ioutil.WriteFile(tmpFile, []byte(phpIni), 0644)
+	c.Assert(err, IsNil)
+
+	args["Path"] = tmpFile
+	err = s.i.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.i.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *IniSettingTestSuite) TestSetUserConfig_NoValue(c *C) {
+	err := s.i.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/php.ini",
+		"Key":    "memory_limit",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Value must be provided(.*)")
+}
+
+func (s *IniSettingTestSuite) TestSetUserConfig_InvalidSeparator(c *C) {
+	err := s.i.SetUserConfig(haiconf.CommandArgs{
+		"Path":      "/etc/php.ini",
+		"Key":       "memory_limit",
+		"Value":     "256M",
+		"Ensure":    haiconf.ENSURE_PRESENT,
+		"Separator": "->",
+	})
+	c.Assert(err, ErrorMatches, "Separator must contain = or :(.*)")
+}
+
+func (s *IniSettingTestSuite) TestSetUserConfig_Numbers(c *C) {
+	values := map[float64]string{
+		1048576:    "1048576",
+		4294967295: "4294967295",
+		0.5:        "0.5",
+	}
+
+	for v, expected := range values {
+		err := s.i.SetUserConfig(haiconf.CommandArgs{
+			"Path":   "/etc/php.ini",
+			"Key":    "upload_max_filesize",
+			"Value":  v,
+			"Ensure": haiconf.ENSURE_PRESENT,
+		})
+		c.Assert(err, IsNil)
+		c.Assert(s.i.Value, Equals, expected)
+	}
+}
+
+func (s *IniSettingTestSuite) TestRun_Replace(c *C) {
+	obtained := s.run(c, haiconf.CommandArgs{
+		"Section": "PHP",
+		"Key":     "memory_limit",
+		"Value":   "256M",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `; global comment
+engine = On
+
+[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 256M
+short_open_tag = Off
+
+[Date]
+;date.timezone =
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *IniSettingTestSuite) TestRun_AddToSection(c *C) {
+	obtained := s.run(c, haiconf.CommandArgs{
+		"Section": "PHP",
+		"Key":     "max_execution_time",
+		"Value":   float64(30),
+		"Ensure":  haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `; global comment
+engine = On
+
+[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 128M
+short_open_tag = Off
+max_execution_time = 30
+
+[Date]
+;date.timezone =
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *IniSettingTestSuite) TestRun_GlobalSection(c *C) {
+	obtained := s.run(c, haiconf.CommandArgs{
+		"Key":    "expose_php",
+		"Value":  false,
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `; global comment
+engine = On
+expose_php = false
+
+[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 128M
+short_open_tag = Off
+
+[Date]
+;date.timezone =
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *IniSettingTestSuite) TestRun_NewSection(c *C) {
+	obtained := s.run(c, haiconf.CommandArgs{
+		"Section":   "opcache",
+		"Key":       "opcache.enable",
+		"Value":     "1",
+		"Separator": "=",
+		"Ensure":    haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(obtained, Equals, phpIni+"\n[opcache]\nopcache.enable=1\n")
+}
+
+func (s *IniSettingTestSuite) TestRun_Absent(c *C) {
+	obtained := s.run(c, haiconf.CommandArgs{
+		"Section": "PHP",
+		"Key":     "short_open_tag",
+		"Ensure":  haiconf.ENSURE_ABSENT,
+	})
+
+	expected := `; global comment
+engine = On
+
+[PHP]
+; Maximum amount of memory a script may consume
+memory_limit = 128M
+
+[Date]
+;date.timezone =
+`
+	c.Assert(obtained, Equals, expected)
+
+	// commented keys are not removed
+	s.SetUpTest(c)
+	obtained = s.run(c, haiconf.CommandArgs{
+		"Section": "Date",
+		"Key":     "date.timezone",
+		"Ensure":  haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(obtained, Equals, phpIni)
+}
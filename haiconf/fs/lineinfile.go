@@ -0,0 +1,321 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// LineInFile({
+//     Path   = "/etc/ssh/sshd_config",
+//     Line   = "PermitRootLogin no",
+//     Ensure = "present",
+//
+//     -- the last line matching Regexp is replaced by Line. When no line
+//     -- matches, Line is inserted according to InsertAfter/InsertBefore.
+//     -- With Ensure = "absent" every line matching Regexp is removed.
+//     Regexp = "^#?PermitRootLogin ",
+//
+//     -- Line is inserted after the last line matching InsertAfter or
+//     -- before the last line matching InsertBefore. "EOF" and "BOF"
+//     -- stand for the end and the beginning of the file.
+//     -- Line is appended at the end of the file by default.
+//     InsertAfter  = "^# Authentication",
+//     InsertBefore = "BOF",
+//
+//     -- Line may reference Regexp groups as $1 or ${name}. The file
+//     -- is left untouched when Regexp does not match.
+//     BackRefs = false,
+//
+//     -- create the file when it does not exist
+//     Create = false,
+//     Mode   = "0644",
+// })
+//
+// Mode and owner of existing files are preserved.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"regexp"
+)
+
+const (
+	INSERT_BOF = "BOF"
+	INSERT_EOF = "EOF"
+)
+
+type LineInFile struct {
+	Path   string
+	Line   string
+	Ensure string
+	Regexp *regexp.Regexp
+
+	// InsertAfter and InsertBefore are nil when INSERT_EOF, INSERT_BOF
+	// or nothing is provided, insertAtBOF tells where to insert then.
+	InsertAfter  *regexp.Regexp
+	InsertBefore *regexp.Regexp
+	insertAtBOF  bool
+
+	BackRefs bool
+	Create   bool
+	Mode     os.FileMode
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (l *LineInFile) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*l = LineInFile{
+		Path:   "",
+		Line:   "",
+		Ensure: haiconf.ENSURE_PRESENT,
+		Create: false,
+		Mode:   DEFAULT_MODE_FILE,
+		rc:     rc,
+	}
+
+	return nil
+}
+
+func (l *LineInFile) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		l.setPath,
+		l.setEnsure,
+		l.setRegexp,
+		l.setLine,
+		l.setInsert,
+		l.setBackRefs,
+		l.setCreate,
+		l.setMode,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *LineInFile) Run() error {
+	_, err := os.Stat(l.Path)
+	if l.Ensure == haiconf.ENSURE_ABSENT && os.IsNotExist(err) {
+		return nil
+	}
+
+	create := l.Create && l.Ensure == haiconf.ENSURE_PRESENT
+
+	return editFile(l.rc, l.Path, create, l.Mode, func(buff []byte) ([]byte, error) {
+		lines := splitFileLines(buff)
+
+		if l.Ensure == haiconf.ENSURE_ABSENT {
+			return joinFileLines(l.removeLines(lines)), nil
+		}
+
+		return joinFileLines(l.ensureLine(lines)), nil
+	})
+}
+
+func (l *LineInFile) removeLines(lines []string) []string {
+	kept := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		if l.Regexp != nil && l.Regexp.MatchString(line) {
+			continue
+		}
+
+		if l.Regexp == nil && line == l.Line {
+			continue
+		}
+
+		kept = append(kept, line)
+	}
+
+	return kept
+}
+
+func (l *LineInFile) ensureLine(lines []string) []string {
+	if l.Regexp != nil {
+		i := lastMatch(l.Regexp, lines)
+
+		if i >= 0 {
+			lines[i] = l.expand(lines[i])
+			return lines
+		}
+
+		if l.BackRefs {
+			return lines
+		}
+	} else {
+		for _, line := range lines {
+			if line == l.Line {
+				return lines
+			}
+		}
+	}
+
+	return l.insert(lines)
+}
+
+// expand replaces the group references found in Line by the
+// values matched in line
+func (l *LineInFile) expand(line string) string {
+	if !l.BackRefs {
+		return l.Line
+	}
+
+	m := l.Regexp.FindStringSubmatchIndex(line)
+	return string(l.Regexp.ExpandString(nil, l.Line, line, m))
+}
+
+func (l *LineInFile) insert(lines []string) []string {
+	pos := len(lines)
+
+	if l.insertAtBOF {
+		pos = 0
+	}
+
+	if l.InsertAfter != nil {
+		i := lastMatch(l.InsertAfter, lines)
+		if i >= 0 {
+			pos = i + 1
+		}
+	}
+
+	if l.InsertBefore != nil {
+		i := lastMatch(l.InsertBefore, lines)
+		if i >= 0 {
+			pos = i
+		}
+	}
+
+	lines = append(lines, "")
+	copy(lines[pos+1:], lines[pos:])
+	lines[pos] = l.Line
+
+	return lines
+}
+
+func lastMatch(re *regexp.Regexp, lines []string) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if re.MatchString(lines[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (l *LineInFile) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	l.Path = p
+	return nil
+}
+
+func (l *LineInFile) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	l.Ensure = e
+	return nil
+}
+
+func (l *LineInFile) setRegexp(args haiconf.CommandArgs) error {
+	re, err := checkRegexp("Regexp", args)
+	if err != nil {
+		return err
+	}
+
+	l.Regexp = re
+	return nil
+}
+
+func (l *LineInFile) setLine(args haiconf.CommandArgs) error {
+	// removing lines only needs a Regexp
+	if l.Ensure == haiconf.ENSURE_ABSENT && l.Regexp != nil {
+		return nil
+	}
+
+	line, err := haiconf.CheckString("Line", args)
+	if err != nil {
+		return err
+	}
+
+	l.Line = line
+	return nil
+}
+
+func (l *LineInFile) setInsert(args haiconf.CommandArgs) error {
+	_, hasAfter := args["InsertAfter"]
+	_, hasBefore := args["InsertBefore"]
+
+	if hasAfter && hasBefore {
+		return haiconf.NewArgError("InsertAfter and InsertBefore can not be used together", args)
+	}
+
+	if hasAfter && args["InsertAfter"] != INSERT_EOF {
+		re, err := checkRegexp("InsertAfter", args)
+		if err != nil {
+			return err
+		}
+
+		l.InsertAfter = re
+	}
+
+	if hasBefore {
+		if args["InsertBefore"] == INSERT_BOF {
+			l.insertAtBOF = true
+			return nil
+		}
+
+		re, err := checkRegexp("InsertBefore", args)
+		if err != nil {
+			return err
+		}
+
+		l.InsertBefore = re
+	}
+
+	return nil
+}
+
+func (l *LineInFile) setBackRefs(args haiconf.CommandArgs) error {
+	l.BackRefs = haiconf.CheckBool("BackRefs", args)
+
+	if l.BackRefs && l.Regexp == nil {
+		return haiconf.NewArgError("BackRefs requires a Regexp", args)
+	}
+
+	return nil
+}
+
+func (l *LineInFile) setCreate(args haiconf.CommandArgs) error {
+	l.Create = haiconf.CheckBool("Create", args)
+	return nil
+}
+
+func (l *LineInFile) setMode(args haiconf.CommandArgs) error {
+	_, present := args["Mode"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckFileMode("Mode", args)
+	if err != nil {
+		return err
+	}
+
+	l.Mode = m
+	return nil
+}
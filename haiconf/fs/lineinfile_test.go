@@ -0,0 +1,244 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type LineInFileTestSuite struct {
+	l *LineInFile
+}
+
+var _ = Suite(&LineInFileTestSuite{})
+
+const sshdConfig = `# Authentication:
+#PermitRootLogin yes
+PasswordAuthentication yes
+UsePAM yes
+`
+
+func (s *LineInFileTestSuite) SetUpTest(c *C) {
+	s.l = new(LineInFile)
+	err := s.l.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *LineInFileTestSuite) run(c *C, initial string, args haiconf.CommandArgs) string {
+	tmpFile := c.MkDir() + "/sshd_config"
+	err := ioutil.WriteFile(tmpFile, []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	args["Path"] = tmpFile
+	if _, present := args["Ensure"]; !present {
+		args["Ensure"] = haiconf.ENSURE_PRESENT
+	}
+
+	err = s.l.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *LineInFileTestSuite) TestSetUserConfig_NoLine(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/ssh/sshd_config",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Regexp": "^PermitRootLogin",
+	})
+	c.Assert(err, ErrorMatches, "Line must be provided(.*)")
+}
+
+func (s *LineInFileTestSuite) TestSetUserConfig_InvalidRegexp(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/ssh/sshd_config",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Line":   "foo",
+		"Regexp": "^(foo",
+	})
+	c.Assert(err, ErrorMatches, "Invalid Regexp : (.*)")
+}
+
+func (s *LineInFileTestSuite) TestSetUserConfig_InsertAfterAndBefore(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":         "/etc/ssh/sshd_config",
+		"Ensure":       haiconf.ENSURE_PRESENT,
+		"Line":         "foo",
+		"InsertAfter":  "EOF",
+		"InsertBefore": "BOF",
+	})
+	c.Assert(err, ErrorMatches, "InsertAfter and InsertBefore can not be used together(.*)")
+}
+
+func (s *LineInFileTestSuite) TestSetUserConfig_BackRefsWithoutRegexp(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":     "/etc/ssh/sshd_config",
+		"Ensure":   haiconf.ENSURE_PRESENT,
+		"Line":     "foo",
+		"BackRefs": true,
+	})
+	c.Assert(err, ErrorMatches, "BackRefs requires a Regexp(.*)")
+}
+
+func (s *LineInFileTestSuite) TestRun_Append(c *C) {
+	obtained := s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line": "X11Forwarding no",
+	})
+	c.Assert(obtained, Equals, sshdConfig+"X11Forwarding no\n")
+
+	// already present
+	obtained = s.run(c, obtained, haiconf.CommandArgs{
+		"Line": "X11Forwarding no",
+	})
+	c.Assert(obtained, Equals, sshdConfig+"X11Forwarding no\n")
+}
+
+func (s *LineInFileTestSuite) TestRun_Replace(c *C) {
+	obtained := s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line":   "PermitRootLogin no",
+		"Regexp": "^#?PermitRootLogin ",
+	})
+
+	expected := `# Authentication:
+PermitRootLogin no
+PasswordAuthentication yes
+UsePAM yes
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *LineInFileTestSuite) TestRun_InsertAfter(c *C) {
+	obtained := s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line":        "PubkeyAuthentication yes",
+		"Regexp":      "^PubkeyAuthentication ",
+		"InsertAfter": "^# Authentication",
+	})
+
+	expected := `# Authentication:
+PubkeyAuthentication yes
+#PermitRootLogin yes
+PasswordAuthentication yes
+UsePAM yes
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *LineInFileTestSuite) TestRun_InsertBefore(c *C) {
+	obtained := s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line":         "Port 2222",
+		"InsertBefore": "^UsePAM",
+	})
+
+	expected := `# Authentication:
+#PermitRootLogin yes
+PasswordAuthentication yes
+Port 2222
+UsePAM yes
+`
+	c.Assert(obtained, Equals, expected)
+
+	s.SetUpTest(c)
+	obtained = s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line":         "# managed by haiconf",
+		"InsertBefore": "BOF",
+	})
+	c.Assert(obtained, Equals, "# managed by haiconf\n"+sshdConfig)
+}
+
+func (s *LineInFileTestSuite) TestRun_BackRefs(c *C) {
+	obtained := s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line":     "${key} no",
+		"Regexp":   "^(?P<key>PasswordAuthentication) ",
+		"BackRefs": true,
+	})
+
+	expected := `# Authentication:
+#PermitRootLogin yes
+PasswordAuthentication no
+UsePAM yes
+`
+	c.Assert(obtained, Equals, expected)
+
+	// no match, nothing is inserted with BackRefs
+	s.SetUpTest(c)
+	obtained = s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Line":     "$1 no",
+		"Regexp":   "^(ChallengeResponseAuthentication) ",
+		"BackRefs": true,
+	})
+	c.Assert(obtained, Equals, sshdConfig)
+}
+
+func (s *LineInFileTestSuite) TestRun_Absent(c *C) {
+	obtained := s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Ensure": haiconf.ENSURE_ABSENT,
+		"Regexp": "^#",
+	})
+	c.Assert(obtained, Equals, "PasswordAuthentication yes\nUsePAM yes\n")
+
+	s.SetUpTest(c)
+	obtained = s.run(c, sshdConfig, haiconf.CommandArgs{
+		"Ensure": haiconf.ENSURE_ABSENT,
+		"Line":   "UsePAM yes",
+	})
+	c.Assert(obtained, Equals, "# Authentication:\n#PermitRootLogin yes\nPasswordAuthentication yes\n")
+}
+
+func (s *LineInFileTestSuite) TestRun_Create(c *C) {
+	tmpFile := c.MkDir() + "/default/haiconf"
+
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Line":   "ENABLED=1",
+		"Create": true,
+		"Mode":   "0600",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "ENABLED=1\n")
+
+	fi, err := os.Stat(tmpFile)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *LineInFileTestSuite) TestRun_DoesNotExist(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   c.MkDir() + "/foo",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Line":   "ENABLED=1",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, ErrorMatches, "(.*) does not exist")
+}
+
+func (s *LineInFileTestSuite) TestRun_AbsentDoesNotExist(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   c.MkDir() + "/foo",
+		"Ensure": haiconf.ENSURE_ABSENT,
+		"Line":   "ENABLED=1",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+}
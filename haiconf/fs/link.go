@@ -0,0 +1,341 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Link({
+//     Path   = "/etc/nginx/sites-enabled/default",
+//     Target = "/etc/nginx/sites-available/default",
+//     Type   = "symbolic", -- or "hard"
+//     Ensure = "present",
+//
+//     -- replace Path even when it is a regular file or a directory
+//     Force  = false,
+//
+//     -- optional, applied with lchown. For hard links this changes
+//     -- the owner of the target as well since they share the same inode
+//     Owner  = "root",
+//     Group  = "root",
+// })
+//
+// Target is optional when Ensure is "absent". Without Target a hard
+// link is any regular file which has other links to its inode.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"os"
+	"os/user"
+	"path"
+	"syscall"
+)
+
+const (
+	LINK_SYMBOLIC = "symbolic"
+	LINK_HARD     = "hard"
+)
+
+type Link struct {
+	Path   string
+	Target string
+	Type   string
+	Ensure string
+	Force  bool
+	Owner  *user.User
+	Group  *hacks.Group
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (l *Link) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*l = Link{
+		Path:   "",
+		Target: "",
+		Type:   LINK_SYMBOLIC,
+		Ensure: haiconf.ENSURE_PRESENT,
+		Force:  false,
+		Owner:  nil,
+		Group:  nil,
+		rc:     rc,
+	}
+
+	return nil
+}
+
+func (l *Link) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		l.setPath,
+		l.setEnsure,
+		l.setType,
+		l.setTarget,
+		l.setForce,
+		l.setOwner,
+		l.setGroup,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Link) Run() error {
+	fi, err := os.Lstat(l.Path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if l.Ensure == haiconf.ENSURE_ABSENT {
+		if !exists {
+			return nil
+		}
+
+		return l.remove(fi)
+	}
+
+	if exists {
+		upToDate, err := l.isUpToDate(fi)
+		if err != nil {
+			return err
+		}
+
+		if upToDate {
+			haiconf.Output(l.rc, "Link %s to %s is up to date", l.Path, l.Target)
+			return l.chown()
+		}
+
+		// links are replaced atomically below, other files must be removed first
+		if fi.Mode()&os.ModeSymlink == 0 {
+			err = l.remove(fi)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	haiconf.Output(l.rc, "Creating %s link %s to %s", l.Type, l.Path, l.Target)
+	if l.rc.DryRun {
+		return nil
+	}
+
+	err = MkDir(path.Dir(l.Path), true, DEFAULT_MODE_DIRECTORY)
+	if err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(l.Path), "."+path.Base(l.Path)+".haiconf")
+	os.Remove(tmp)
+
+	if l.Type == LINK_HARD {
+		err = os.Link(l.Target, tmp)
+	} else {
+		err = os.Symlink(l.Target, tmp)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, l.Path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return l.chown()
+}
+
+func (l *Link) isUpToDate(fi os.FileInfo) (bool, error) {
+	if l.Type == LINK_SYMBOLIC {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return false, nil
+		}
+
+		t, err := os.Readlink(l.Path)
+		if err != nil {
+			return false, err
+		}
+
+		return t == l.Target, nil
+	}
+
+	target, err := os.Stat(l.Target)
+	if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(fi, target), nil
+}
+
+// remove deletes whatever is at l.Path. Only links can be removed
+// unless Force is set.
+func (l *Link) remove(fi os.FileInfo) error {
+	isLink := fi.Mode()&os.ModeSymlink != 0
+
+	if l.Type == LINK_HARD && fi.Mode().IsRegular() {
+		isLink = isHardLink(fi)
+
+		if l.Target != "" {
+			target, err := os.Stat(l.Target)
+			isLink = err == nil && os.SameFile(fi, target)
+		}
+	}
+
+	if !isLink && !l.Force {
+		return haiconf.NewArgError(l.Path+" exists and is not a link, use Force to replace it", haiconf.CommandArgs{
+			"Path":   l.Path,
+			"Target": l.Target,
+		})
+	}
+
+	haiconf.Output(l.rc, "Removing %s", l.Path)
+	if l.rc.DryRun {
+		return nil
+	}
+
+	if !isLink {
+		_, err := backup.NewBucket(l.rc.BackupDir, l.rc.RunId).StoreTree(l.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(l.Path)
+}
+
+// isHardLink tells whether other paths point to the inode of fi,
+// removing it then does not lose any data
+func isHardLink(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Nlink > 1
+}
+
+func (l *Link) chown() error {
+	if l.Owner == nil && l.Group == nil {
+		return nil
+	}
+
+	if l.rc.DryRun {
+		return nil
+	}
+
+	fi, err := os.Lstat(l.Path)
+	if err != nil {
+		return err
+	}
+
+	uid, gid, err := lookupIds(l.Owner, l.Group)
+	if err != nil {
+		return err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if ok && (uid == -1 || int(st.Uid) == uid) && (gid == -1 || int(st.Gid) == gid) {
+		return nil
+	}
+
+	haiconf.Output(l.rc, "Lchown %s on %s", ownership(l.Owner, l.Group), l.Path)
+	return os.Lchown(l.Path, uid, gid)
+}
+
+func (l *Link) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	l.Path = p
+	return nil
+}
+
+func (l *Link) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	l.Ensure = e
+	return nil
+}
+
+func (l *Link) setType(args haiconf.CommandArgs) error {
+	_, present := args["Type"]
+	if !present {
+		return nil
+	}
+
+	t, err := haiconf.CheckStringChoice("Type", args, []string{LINK_SYMBOLIC, LINK_HARD})
+	if err != nil {
+		return err
+	}
+
+	l.Type = t
+	return nil
+}
+
+func (l *Link) setTarget(args haiconf.CommandArgs) error {
+	_, present := args["Target"]
+	if !present && l.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	// relative targets are valid for symbolic links
+	t, err := haiconf.CheckString("Target", args)
+	if err != nil {
+		return err
+	}
+
+	if l.Type == LINK_HARD && !path.IsAbs(t) {
+		return haiconf.NewArgError("Target must be absolute for hard links", args)
+	}
+
+	l.Target = t
+	return nil
+}
+
+func (l *Link) setForce(args haiconf.CommandArgs) error {
+	l.Force = haiconf.CheckBool("Force", args)
+	return nil
+}
+
+func (l *Link) setOwner(args haiconf.CommandArgs) error {
+	_, present := args["Owner"]
+	if !present {
+		return nil
+	}
+
+	u, err := haiconf.CheckSystemUser("Owner", args)
+	if err != nil {
+		return err
+	}
+
+	l.Owner = u
+	return nil
+}
+
+func (l *Link) setGroup(args haiconf.CommandArgs) error {
+	_, present := args["Group"]
+	if !present {
+		return nil
+	}
+
+	grp, err := haiconf.CheckSystemGroup("Group", args)
+	if err != nil {
+		return err
+	}
+
+	l.Group = grp
+	return nil
+}
@@ -0,0 +1,273 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type LinkTestSuite struct {
+	l *Link
+}
+
+var _ = Suite(&LinkTestSuite{})
+
+func (s *LinkTestSuite) SetUpTest(c *C) {
+	s.l = new(Link)
+	err := s.l.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *LinkTestSuite) TestSetDefault(c *C) {
+	expected := &Link{
+		Type:   LINK_SYMBOLIC,
+		Ensure: haiconf.ENSURE_PRESENT,
+		rc:     &dummyRuntimeConfig,
+	}
+	c.Assert(s.l, DeepEquals, expected)
+}
+
+func (s *LinkTestSuite) TestSetUserConfig(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/nginx/sites-enabled/default",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": "../sites-available/default",
+		"Force":  true,
+		"Owner":  currentUser.Username,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.l.Path, Equals, "/etc/nginx/sites-enabled/default")
+	c.Assert(s.l.Target, Equals, "../sites-available/default")
+	c.Assert(s.l.Type, Equals, LINK_SYMBOLIC)
+	c.Assert(s.l.Ensure, Equals, haiconf.ENSURE_PRESENT)
+	c.Assert(s.l.Force, Equals, true)
+	c.Assert(s.l.Owner.Username, Equals, currentUser.Username)
+	c.Assert(s.l.Group, IsNil)
+}
+
+func (s *LinkTestSuite) TestSetUserConfig_InvalidType(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/tmp/foo",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": "/tmp/bar",
+		"Type":   "soft",
+	})
+	c.Assert(err, NotNil)
+}
+
+func (s *LinkTestSuite) TestSetUserConfig_RelativeHardLink(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/tmp/foo",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": "bar",
+		"Type":   LINK_HARD,
+	})
+	c.Assert(err, ErrorMatches, "Target must be absolute for hard links(.*)")
+}
+
+func (s *LinkTestSuite) TestSetUserConfig_AbsentWithoutTarget(c *C) {
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/tmp/foo",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *LinkTestSuite) TestRun_Symbolic(c *C) {
+	tmpDir := c.MkDir()
+
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/sites-enabled/default",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": "../sites-available/default",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	t, err := os.Readlink(tmpDir + "/sites-enabled/default")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, "../sites-available/default")
+
+	// existing links are replaced
+	s.l.Target = "../sites-available/other"
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	t, err = os.Readlink(tmpDir + "/sites-enabled/default")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, "../sites-available/other")
+
+	// running twice is a no-op
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+}
+
+func (s *LinkTestSuite) TestRun_Hard(c *C) {
+	tmpDir := c.MkDir()
+	err := ioutil.WriteFile(tmpDir+"/target", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/link",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": tmpDir + "/target",
+		"Type":   LINK_HARD,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	fi1, err := os.Stat(tmpDir + "/link")
+	c.Assert(err, IsNil)
+	fi2, err := os.Stat(tmpDir + "/target")
+	c.Assert(err, IsNil)
+	c.Assert(os.SameFile(fi1, fi2), Equals, true)
+
+	// removing a hard link does not require Force
+	s.l.Ensure = haiconf.ENSURE_ABSENT
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Lstat(tmpDir + "/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = os.Stat(tmpDir + "/target")
+	c.Assert(err, IsNil)
+}
+
+func (s *LinkTestSuite) TestRun_Force(c *C) {
+	tmpDir := c.MkDir()
+	err := os.MkdirAll(tmpDir+"/link/sub", 0755)
+	c.Assert(err, IsNil)
+
+	err = s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/link",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": tmpDir + "/target",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, ErrorMatches, "(.*) exists and is not a link, use Force to replace it(.*)")
+
+	s.l.Force = true
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	t, err := os.Readlink(tmpDir + "/link")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, tmpDir+"/target")
+}
+
+func (s *LinkTestSuite) TestRun_Absent(c *C) {
+	tmpDir := c.MkDir()
+	err := os.Symlink(tmpDir, tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	err = s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/link",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Lstat(tmpDir + "/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// already absent
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+}
+
+func (s *LinkTestSuite) TestRun_AbsentHard(c *C) {
+	tmpDir := c.MkDir()
+	err := ioutil.WriteFile(tmpDir+"/target", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.Link(tmpDir+"/target", tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	err = s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/link",
+		"Type":   LINK_HARD,
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Lstat(tmpDir + "/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// the last link to the content is not a hard link anymore
+	s.l.Path = tmpDir + "/target"
+	err = s.l.Run()
+	c.Assert(err, ErrorMatches, ".* exists and is not a link, use Force to replace it.*")
+}
+
+func (s *LinkTestSuite) TestRun_AbsentHardWithTarget(c *C) {
+	tmpDir := c.MkDir()
+	err := ioutil.WriteFile(tmpDir+"/target", []byte("foo"), 0644)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(tmpDir+"/other", []byte("bar"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.Link(tmpDir+"/target", tmpDir+"/link")
+	c.Assert(err, IsNil)
+
+	err = s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/link",
+		"Target": tmpDir + "/other",
+		"Type":   LINK_HARD,
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+
+	// linked to another file
+	err = s.l.Run()
+	c.Assert(err, NotNil)
+
+	s.l.Target = tmpDir + "/target"
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Lstat(tmpDir + "/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *LinkTestSuite) TestRun_DryRun(c *C) {
+	tmpDir := c.MkDir()
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+
+	s.l.SetDefault(&rc)
+	err := s.l.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpDir + "/link",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Target": tmpDir,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.l.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Lstat(tmpDir + "/link")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(output.String(), Matches, "Creating symbolic link (.*)\n")
+}
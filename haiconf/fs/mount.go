@@ -0,0 +1,527 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Mount({
+//     Device     = "UUID=5b1f8c0e-1d9b-4a3c-9c55-3f0c1b7d2a61",
+//     MountPoint = "/var/lib/postgresql",
+//     FsType     = "ext4",
+//
+//     -- optional, "defaults" by default
+//     Options    = {"noatime", "nodiratime"},
+//     Dump       = 0,
+//     Pass       = 2,
+//
+//     -- mounted   : the entry is in fstab and the device is mounted
+//     -- unmounted : the device is not mounted, fstab is left untouched
+//     -- present   : the entry is in fstab, the mount state is left untouched
+//     -- absent    : the device is unmounted and its entry removed
+//     Ensure     = "mounted",
+//
+//     -- optional
+//     Fstab      = "/etc/fstab",
+//     MountInfo  = "/proc/self/mountinfo",
+// })
+//
+// Entries are identified by their mount point. Comments and other
+// entries are left untouched. When a mounted entry changes it is
+// remounted, which applies new options but not a new device.
+
+package fs
+
+import (
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/osutils"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	ENSURE_MOUNTED   = "mounted"
+	ENSURE_UNMOUNTED = "unmounted"
+
+	DEFAULT_FSTAB_PATH     = "/etc/fstab"
+	DEFAULT_MOUNTINFO_PATH = "/proc/self/mountinfo"
+
+	MOUNT_PATH  = "/bin/mount"
+	UMOUNT_PATH = "/bin/umount"
+)
+
+var (
+	mountPath  = MOUNT_PATH
+	umountPath = UMOUNT_PATH
+)
+
+type Mount struct {
+	Device     string
+	MountPoint string
+	FsType     string
+	Options    []string
+	Dump       int
+	Pass       int
+	Ensure     string
+	Fstab      string
+	MountInfo  string
+
+	rc *haiconf.RuntimeConfig
+}
+
+type fstabEntry struct {
+	device     string
+	mountPoint string
+	fsType     string
+	options    string
+	dump       int
+	pass       int
+}
+
+func (m *Mount) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*m = Mount{
+		Device:     "",
+		MountPoint: "",
+		FsType:     "",
+		Options:    []string{"defaults"},
+		Dump:       0,
+		Pass:       0,
+		Ensure:     ENSURE_MOUNTED,
+		Fstab:      DEFAULT_FSTAB_PATH,
+		MountInfo:  DEFAULT_MOUNTINFO_PATH,
+		rc:         rc,
+	}
+
+	return nil
+}
+
+func (m *Mount) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		m.setMountPoint,
+		m.setEnsure,
+		m.setDevice,
+		m.setFsType,
+		m.setOptions,
+		m.setDump,
+		m.setPass,
+		m.setFstab,
+		m.setMountInfo,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Mount) Run() error {
+	mounted, err := m.isMounted()
+	if err != nil {
+		return err
+	}
+
+	switch m.Ensure {
+	case ENSURE_UNMOUNTED:
+		if !mounted {
+			return nil
+		}
+
+		return m.umount()
+
+	case haiconf.ENSURE_ABSENT:
+		if mounted {
+			err = m.umount()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = m.updateFstab()
+		return err
+	}
+
+	changed, err := m.updateFstab()
+	if err != nil {
+		return err
+	}
+
+	if m.Ensure == haiconf.ENSURE_PRESENT {
+		return nil
+	}
+
+	if !mounted {
+		return m.mount()
+	}
+
+	if changed {
+		return m.remount()
+	}
+
+	haiconf.Output(m.rc, "%s is already mounted", m.MountPoint)
+	return nil
+}
+
+// updateFstab adds, replaces or removes the entry for MountPoint and
+// reports whether fstab was changed
+func (m *Mount) updateFstab() (bool, error) {
+	_, err := os.Stat(m.Fstab)
+	if m.Ensure == haiconf.ENSURE_ABSENT && os.IsNotExist(err) {
+		return false, nil
+	}
+
+	changed := false
+
+	err = editFile(m.rc, m.Fstab, true, DEFAULT_MODE_FILE, func(buff []byte) ([]byte, error) {
+		lines, c := m.edit(splitFileLines(buff))
+		changed = c
+		return joinFileLines(lines), nil
+	})
+
+	return changed, err
+}
+
+func (m *Mount) edit(lines []string) ([]string, bool) {
+	wanted := m.entry()
+	out := make([]string, 0, len(lines)+1)
+	changed, found := false, false
+
+	for _, line := range lines {
+		e, isEntry := parseFstabEntry(line)
+		if !isEntry || e.mountPoint != m.MountPoint {
+			out = append(out, line)
+			continue
+		}
+
+		if m.Ensure == haiconf.ENSURE_ABSENT || found {
+			changed = true
+			continue
+		}
+
+		found = true
+		if e == wanted {
+			out = append(out, line)
+			continue
+		}
+
+		changed = true
+		out = append(out, wanted.String())
+	}
+
+	if m.Ensure != haiconf.ENSURE_ABSENT && !found {
+		changed = true
+		out = append(out, wanted.String())
+	}
+
+	return out, changed
+}
+
+func (m *Mount) entry() fstabEntry {
+	return fstabEntry{
+		device:     m.Device,
+		mountPoint: m.MountPoint,
+		fsType:     m.FsType,
+		options:    strings.Join(m.Options, ","),
+		dump:       m.Dump,
+		pass:       m.Pass,
+	}
+}
+
+// isMounted looks for MountPoint in the mount points
+// listed in the fifth field of mountinfo
+func (m *Mount) isMounted() (bool, error) {
+	buff, err := ioutil.ReadFile(m.MountInfo)
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range splitFileLines(buff) {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+
+		if unescapeMountField(fields[4]) == m.MountPoint {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *Mount) mount() error {
+	haiconf.Output(m.rc, "Mounting %s on %s", m.Device, m.MountPoint)
+	if m.rc.DryRun {
+		return nil
+	}
+
+	err := MkDir(m.MountPoint, true, DEFAULT_MODE_DIRECTORY)
+	if err != nil {
+		return err
+	}
+
+	return runMountCommand(mountPath, "-t", m.FsType, "-o", strings.Join(m.Options, ","), m.Device, m.MountPoint)
+}
+
+func (m *Mount) remount() error {
+	haiconf.Output(m.rc, "Remounting %s", m.MountPoint)
+	if m.rc.DryRun {
+		return nil
+	}
+
+	return runMountCommand(mountPath, "-o", "remount,"+strings.Join(m.Options, ","), m.MountPoint)
+}
+
+func (m *Mount) umount() error {
+	haiconf.Output(m.rc, "Unmounting %s", m.MountPoint)
+	if m.rc.DryRun {
+		return nil
+	}
+
+	return runMountCommand(umountPath, m.MountPoint)
+}
+
+// runMountCommand does not use shell expansion
+// since mount points may contain spaces
+func runMountCommand(p string, args ...string) error {
+	sc := osutils.SystemCommand{
+		Path:    p,
+		Args:    append([]string{path.Base(p)}, args...),
+		ExecDir: os.TempDir(),
+	}
+
+	output := sc.Run()
+	if output.HasError() {
+		return output
+	}
+
+	return nil
+}
+
+// parseFstabEntry reads lines such as
+// "/dev/sdb1 /srv ext4 defaults,noatime 0 2", dump
+// and pass default to 0 when missing
+func parseFstabEntry(line string) (fstabEntry, bool) {
+	l := strings.TrimSpace(line)
+	if l == "" || l[0] == '#' {
+		return fstabEntry{}, false
+	}
+
+	fields := strings.Fields(l)
+	if len(fields) < 4 {
+		return fstabEntry{}, false
+	}
+
+	e := fstabEntry{
+		device:     unescapeMountField(fields[0]),
+		mountPoint: unescapeMountField(fields[1]),
+		fsType:     fields[2],
+		options:    fields[3],
+	}
+
+	if len(fields) > 4 {
+		e.dump, _ = strconv.Atoi(fields[4])
+	}
+
+	if len(fields) > 5 {
+		e.pass, _ = strconv.Atoi(fields[5])
+	}
+
+	return e, true
+}
+
+func (e fstabEntry) String() string {
+	return fmt.Sprintf(
+		"%s\t%s\t%s\t%s\t%d\t%d",
+		escapeMountField(e.device), escapeMountField(e.mountPoint), e.fsType, e.options, e.dump, e.pass,
+	)
+}
+
+var mountFieldEscapes = []string{
+	" ", `\040`,
+	"\t", `\011`,
+	"\n", `\012`,
+	`\`, `\134`,
+}
+
+// escapeMountField encodes the characters which fstab
+// and mountinfo write as octal sequences
+func escapeMountField(s string) string {
+	return strings.NewReplacer(mountFieldEscapes...).Replace(s)
+}
+
+func unescapeMountField(s string) string {
+	reversed := make([]string, len(mountFieldEscapes))
+	for i := 0; i < len(mountFieldEscapes); i += 2 {
+		reversed[i], reversed[i+1] = mountFieldEscapes[i+1], mountFieldEscapes[i]
+	}
+
+	return strings.NewReplacer(reversed...).Replace(s)
+}
+
+func (m *Mount) setMountPoint(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("MountPoint", args)
+	if err != nil {
+		return err
+	}
+
+	if p != "/" {
+		p = strings.TrimRight(p, "/")
+	}
+
+	m.MountPoint = p
+	return nil
+}
+
+func (m *Mount) setEnsure(args haiconf.CommandArgs) error {
+	choices := []string{
+		ENSURE_MOUNTED,
+		ENSURE_UNMOUNTED,
+		haiconf.ENSURE_PRESENT,
+		haiconf.ENSURE_ABSENT,
+	}
+
+	e, err := haiconf.CheckStringChoice("Ensure", args, choices)
+	if err != nil {
+		return err
+	}
+
+	m.Ensure = e
+	return nil
+}
+
+// needsEntry tells whether Device and FsType are required
+func (m *Mount) needsEntry() bool {
+	return m.Ensure == ENSURE_MOUNTED || m.Ensure == haiconf.ENSURE_PRESENT
+}
+
+func (m *Mount) setDevice(args haiconf.CommandArgs) error {
+	if !m.needsEntry() {
+		return nil
+	}
+
+	d, err := haiconf.CheckString("Device", args)
+	if err != nil {
+		return err
+	}
+
+	m.Device = d
+	return nil
+}
+
+func (m *Mount) setFsType(args haiconf.CommandArgs) error {
+	if !m.needsEntry() {
+		return nil
+	}
+
+	t, err := haiconf.CheckString("FsType", args)
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(t, " \t") {
+		return haiconf.NewArgError("Invalid FsType "+t, args)
+	}
+
+	m.FsType = t
+	return nil
+}
+
+func (m *Mount) setOptions(args haiconf.CommandArgs) error {
+	_, present := args["Options"]
+	if !present {
+		return nil
+	}
+
+	opts, err := haiconf.CheckStringList("Options", args)
+	if err != nil {
+		o, err := haiconf.CheckString("Options", args)
+		if err != nil {
+			return err
+		}
+
+		opts = strings.Split(o, ",")
+	}
+
+	for _, o := range opts {
+		if o == "" || strings.ContainsAny(o, " \t,") {
+			return haiconf.NewArgError("Invalid option "+o, args)
+		}
+	}
+
+	m.Options = opts
+	return nil
+}
+
+func (m *Mount) setDump(args haiconf.CommandArgs) error {
+	n, err := checkMountNumber("Dump", args)
+	if err != nil {
+		return err
+	}
+
+	m.Dump = n
+	return nil
+}
+
+func (m *Mount) setPass(args haiconf.CommandArgs) error {
+	n, err := checkMountNumber("Pass", args)
+	if err != nil {
+		return err
+	}
+
+	m.Pass = n
+	return nil
+}
+
+func checkMountNumber(k string, args haiconf.CommandArgs) (int, error) {
+	v, present := args[k]
+	if !present {
+		return 0, nil
+	}
+
+	n, ok := v.(float64)
+	if !ok || n < 0 || n != float64(int(n)) {
+		return 0, haiconf.NewArgError(k+" must be a positive integer", args)
+	}
+
+	return int(n), nil
+}
+
+func (m *Mount) setFstab(args haiconf.CommandArgs) error {
+	_, present := args["Fstab"]
+	if !present {
+		return nil
+	}
+
+	p, err := haiconf.CheckAbsolutePath("Fstab", args)
+	if err != nil {
+		return err
+	}
+
+	m.Fstab = p
+	return nil
+}
+
+func (m *Mount) setMountInfo(args haiconf.CommandArgs) error {
+	_, present := args["MountInfo"]
+	if !present {
+		return nil
+	}
+
+	p, err := haiconf.CheckAbsolutePath("MountInfo", args)
+	if err != nil {
+		return err
+	}
+
+	m.MountInfo = p
+	return nil
+}
@@ -0,0 +1,332 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type MountTestSuite struct {
+	m      *Mount
+	dir    string
+	output *bytes.Buffer
+}
+
+var _ = Suite(&MountTestSuite{})
+
+const fstab = `# /etc/fstab: static file system information.
+#
+# <file system> <mount point>   <type>  <options>       <dump>  <pass>
+UUID=0a3407de-014b-458b-b5c1-848e92a327a3 /               ext4    errors=remount-ro 0       1
+/dev/sdb1	/srv/My\040Data	ext4	defaults	0	2
+tmpfs /tmp tmpfs defaults
+`
+
+const mountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+23 22 0:21 / /tmp rw,nosuid,nodev shared:2 - tmpfs tmpfs rw
+24 22 8:17 / /srv/My\040Data rw,relatime shared:3 - ext4 /dev/sdb1 rw
+`
+
+func (s *MountTestSuite) SetUpTest(c *C) {
+	s.output = new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		Verbose: true,
+		Output:  s.output,
+	}
+
+	s.m = new(Mount)
+	err := s.m.SetDefault(&rc)
+	c.Assert(err, IsNil)
+
+	s.dir = c.MkDir()
+
+	err = ioutil.WriteFile(s.dir+"/fstab", []byte(fstab), 0644)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(s.dir+"/mountinfo", []byte(mountInfo), 0444)
+	c.Assert(err, IsNil)
+
+	// mounting requires root, commands are only checked by their output
+	mountPath, umountPath = "/bin/true", "/bin/true"
+}
+
+func (s *MountTestSuite) TearDownTest(c *C) {
+	mountPath, umountPath = MOUNT_PATH, UMOUNT_PATH
+}
+
+func (s *MountTestSuite) run(c *C, args haiconf.CommandArgs) string {
+	args["Fstab"] = s.dir + "/fstab"
+	args["MountInfo"] = s.dir + "/mountinfo"
+
+	err := s.m.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.m.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(s.dir + "/fstab")
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *MountTestSuite) TestSetDefault(c *C) {
+	c.Assert(s.m.Options, DeepEquals, []string{"defaults"})
+	c.Assert(s.m.Ensure, Equals, ENSURE_MOUNTED)
+	c.Assert(s.m.Fstab, Equals, DEFAULT_FSTAB_PATH)
+	c.Assert(s.m.MountInfo, Equals, DEFAULT_MOUNTINFO_PATH)
+}
+
+func (s *MountTestSuite) TestSetUserConfig(c *C) {
+	err := s.m.SetUserConfig(haiconf.CommandArgs{
+		"Device":     "LABEL=data",
+		"MountPoint": "/srv/data/",
+		"FsType":     "xfs",
+		"Options":    "noatime,nodiratime",
+		"Dump":       float64(1),
+		"Pass":       float64(2),
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.m.MountPoint, Equals, "/srv/data")
+	c.Assert(s.m.Options, DeepEquals, []string{"noatime", "nodiratime"})
+	c.Assert(s.m.entry().String(), Equals, "LABEL=data\t/srv/data\txfs\tnoatime,nodiratime\t1\t2")
+}
+
+func (s *MountTestSuite) TestSetUserConfig_MissingDevice(c *C) {
+	err := s.m.SetUserConfig(haiconf.CommandArgs{
+		"MountPoint": "/srv/data",
+		"FsType":     "xfs",
+		"Ensure":     haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Device must be provided(.*)")
+
+	err = s.m.SetUserConfig(haiconf.CommandArgs{
+		"MountPoint": "/srv/data",
+		"Ensure":     ENSURE_UNMOUNTED,
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *MountTestSuite) TestSetUserConfig_InvalidValues(c *C) {
+	err := s.m.SetUserConfig(haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": "/srv/data",
+		"FsType":     "xfs",
+		"Ensure":     "remounted",
+	})
+	c.Assert(err, ErrorMatches, "Invalid choice for Ensure(.*)")
+
+	err = s.m.SetUserConfig(haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": "/srv/data",
+		"FsType":     "xfs",
+		"Options":    []interface{}{"noatime", "no atime"},
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(err, ErrorMatches, "Invalid option no atime(.*)")
+
+	err = s.m.SetUserConfig(haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": "/srv/data",
+		"FsType":     "xfs",
+		"Pass":       float64(1.5),
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(err, ErrorMatches, "Pass must be a positive integer(.*)")
+}
+
+func (s *MountTestSuite) TestParseFstabEntry(c *C) {
+	e, isEntry := parseFstabEntry(`/dev/sdb1	/srv/My\040Data	ext4	defaults	0	2`)
+	c.Assert(isEntry, Equals, true)
+	c.Assert(e, Equals, fstabEntry{"/dev/sdb1", "/srv/My Data", "ext4", "defaults", 0, 2})
+	c.Assert(e.String(), Equals, `/dev/sdb1	/srv/My\040Data	ext4	defaults	0	2`)
+
+	e, isEntry = parseFstabEntry("tmpfs /tmp tmpfs defaults")
+	c.Assert(isEntry, Equals, true)
+	c.Assert(e, Equals, fstabEntry{"tmpfs", "/tmp", "tmpfs", "defaults", 0, 0})
+
+	for _, l := range []string{"", "   ", "# /dev/sdc1 /srv ext4 defaults 0 0", "/dev/sdc1 /srv"} {
+		_, isEntry = parseFstabEntry(l)
+		c.Assert(isEntry, Equals, false)
+	}
+}
+
+func (s *MountTestSuite) TestIsMounted(c *C) {
+	s.m.MountInfo = s.dir + "/mountinfo"
+
+	for mp, expected := range map[string]bool{"/srv/My Data": true, "/tmp": true, "/srv": false} {
+		s.m.MountPoint = mp
+
+		mounted, err := s.m.isMounted()
+		c.Assert(err, IsNil)
+		c.Assert(mounted, Equals, expected, Commentf(mp))
+	}
+}
+
+func (s *MountTestSuite) TestRun_Present(c *C) {
+	out := s.run(c, haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": "/var/lib/postgresql",
+		"FsType":     "ext4",
+		"Options":    []interface{}{"noatime"},
+		"Pass":       float64(2),
+		"Ensure":     haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(out, Equals, fstab+"/dev/sdc1\t/var/lib/postgresql\text4\tnoatime\t0\t2\n")
+}
+
+func (s *MountTestSuite) TestRun_PresentUpToDate(c *C) {
+	out := s.run(c, haiconf.CommandArgs{
+		"Device":     "tmpfs",
+		"MountPoint": "/tmp",
+		"FsType":     "tmpfs",
+		"Ensure":     haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(out, Equals, fstab)
+}
+
+func (s *MountTestSuite) TestRun_PresentLeavesMountState(c *C) {
+	// any call to mount or umount makes Run fail
+	mountPath, umountPath = "/bin/false", "/bin/false"
+
+	// /tmp is mounted, /var/lib/postgresql is not
+	s.run(c, haiconf.CommandArgs{
+		"Device":     "tmpfs",
+		"MountPoint": "/tmp",
+		"FsType":     "tmpfs",
+		"Options":    "nosuid",
+		"Ensure":     haiconf.ENSURE_PRESENT,
+	})
+
+	s.run(c, haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": "/var/lib/postgresql",
+		"FsType":     "ext4",
+		"Ensure":     haiconf.ENSURE_PRESENT,
+	})
+
+	c.Assert(s.output.String(), Not(Matches), "(?s).*(Mounting|Unmounting|Remounting).*")
+}
+
+func (s *MountTestSuite) TestRun_Replace(c *C) {
+	out := s.run(c, haiconf.CommandArgs{
+		"Device":     "tmpfs",
+		"MountPoint": "/tmp",
+		"FsType":     "tmpfs",
+		"Options":    []interface{}{"nosuid", "size=512m"},
+		"Ensure":     haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `# /etc/fstab: static file system information.
+#
+# <file system> <mount point>   <type>  <options>       <dump>  <pass>
+UUID=0a3407de-014b-458b-b5c1-848e92a327a3 /               ext4    errors=remount-ro 0       1
+/dev/sdb1	/srv/My\040Data	ext4	defaults	0	2
+tmpfs	/tmp	tmpfs	nosuid,size=512m	0	0
+`
+	c.Assert(out, Equals, expected)
+}
+
+func (s *MountTestSuite) TestRun_Mounted(c *C) {
+	mp := s.dir + "/postgresql"
+
+	out := s.run(c, haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": mp,
+		"FsType":     "ext4",
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(out, Equals, fstab+"/dev/sdc1\t"+mp+"\text4\tdefaults\t0\t0\n")
+	c.Assert(s.output.String(), Matches, "(?s).*Mounting /dev/sdc1 on "+mp+"\n")
+
+	fi, err := os.Stat(mp)
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *MountTestSuite) TestRun_MountedDryRun(c *C) {
+	s.m.rc.DryRun = true
+	mp := s.dir + "/postgresql"
+
+	out := s.run(c, haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": mp,
+		"FsType":     "ext4",
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(out, Equals, fstab)
+	c.Assert(s.output.String(), Matches, "(?s).*Mounting /dev/sdc1 on "+mp+"\n")
+
+	_, err := os.Stat(mp)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MountTestSuite) TestRun_MountFailure(c *C) {
+	mountPath = "/bin/false"
+
+	err := s.m.SetUserConfig(haiconf.CommandArgs{
+		"Device":     "/dev/sdc1",
+		"MountPoint": s.dir + "/postgresql",
+		"FsType":     "ext4",
+		"Ensure":     ENSURE_MOUNTED,
+		"Fstab":      s.dir + "/fstab",
+		"MountInfo":  s.dir + "/mountinfo",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.m.Run()
+	c.Assert(err, ErrorMatches, "Error with command \"/bin/false false -t ext4 (.*)")
+}
+
+func (s *MountTestSuite) TestRun_Remount(c *C) {
+	s.run(c, haiconf.CommandArgs{
+		"Device":     "tmpfs",
+		"MountPoint": "/tmp",
+		"FsType":     "tmpfs",
+		"Options":    "nosuid",
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(s.output.String(), Matches, "(?s).*Remounting /tmp\n")
+}
+
+func (s *MountTestSuite) TestRun_AlreadyMounted(c *C) {
+	s.run(c, haiconf.CommandArgs{
+		"Device":     "tmpfs",
+		"MountPoint": "/tmp",
+		"FsType":     "tmpfs",
+		"Ensure":     ENSURE_MOUNTED,
+	})
+	c.Assert(s.output.String(), Matches, "(?s).*/tmp is already mounted\n")
+}
+
+func (s *MountTestSuite) TestRun_Unmounted(c *C) {
+	out := s.run(c, haiconf.CommandArgs{
+		"MountPoint": "/srv/My Data",
+		"Ensure":     ENSURE_UNMOUNTED,
+	})
+	c.Assert(out, Equals, fstab)
+	c.Assert(s.output.String(), Equals, "Unmounting /srv/My Data\n")
+}
+
+func (s *MountTestSuite) TestRun_Absent(c *C) {
+	out := s.run(c, haiconf.CommandArgs{
+		"MountPoint": "/srv/My Data",
+		"Ensure":     haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(s.output.String(), Matches, "Unmounting /srv/My Data\n(?s).*")
+
+	expected := `# /etc/fstab: static file system information.
+#
+# <file system> <mount point>   <type>  <options>       <dump>  <pass>
+UUID=0a3407de-014b-458b-b5c1-848e92a327a3 /               ext4    errors=remount-ro 0       1
+tmpfs /tmp tmpfs defaults
+`
+	c.Assert(out, Equals, expected)
+}
@@ -0,0 +1,290 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Permissions({
+//     Path    = "/etc/ssl",
+//
+//     -- globs are matched against the path relative to Path or the
+//     -- file name, "**" matches any number of directories
+//     Matches = {"private/**/*.key", "*.pem"},
+//
+//     -- optional, matching paths are left untouched
+//     Exclude = {"**/ssl-cert-snakeoil.*"},
+//
+//     -- at least one of Mode, Owner and Group must be given
+//     Mode    = "0600",
+//     Owner   = "root",
+//     Group   = "ssl-cert",
+//
+//     -- optional, directories are only matched when true
+//     MatchDirectories = false,
+// })
+//
+// Symbolic links are neither followed nor modified.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+type Permissions struct {
+	Path             string
+	Matches          []string
+	Exclude          []string
+	Mode             os.FileMode
+	Owner            *user.User
+	Group            *hacks.Group
+	MatchDirectories bool
+
+	// Mode may legitimately be 0
+	hasMode bool
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (p *Permissions) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*p = Permissions{
+		Path:             "",
+		Matches:          nil,
+		Exclude:          nil,
+		Mode:             0,
+		Owner:            nil,
+		Group:            nil,
+		MatchDirectories: false,
+		rc:               rc,
+	}
+
+	return nil
+}
+
+func (p *Permissions) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		p.setPath,
+		p.setMatches,
+		p.setExclude,
+		p.setMode,
+		p.setOwner,
+		p.setGroup,
+		p.setMatchDirectories,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !p.hasMode && p.Owner == nil && p.Group == nil {
+		return haiconf.NewArgError("Mode, Owner or Group must be provided", args)
+	}
+
+	return nil
+}
+
+func (p *Permissions) Run() error {
+	uid, gid, err := lookupIds(p.Owner, p.Group)
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+
+	err = filepath.Walk(p.Path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(p.Path, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." || fi.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		if matchAnyGlob(p.Exclude, rel) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if fi.IsDir() && !p.MatchDirectories {
+			return nil
+		}
+
+		if !matchAnyGlob(p.Matches, rel) {
+			return nil
+		}
+
+		c, err := p.apply(path, fi, uid, gid)
+		if c {
+			changed++
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	haiconf.Output(p.rc, "Changed permissions or ownership of %d entries under %s", changed, p.Path)
+
+	return nil
+}
+
+func (p *Permissions) apply(path string, fi os.FileInfo, uid int, gid int) (bool, error) {
+	chown := ownershipDiffers(fi, uid, gid)
+	if chown {
+		haiconf.Output(p.rc, "Chown %s on %s", ownership(p.Owner, p.Group), path)
+	} else {
+		uid, gid = -1, -1
+	}
+
+	chmod := p.hasMode && fi.Mode()&haiconf.MODE_MASK != p.Mode&haiconf.MODE_MASK
+	if chmod {
+		haiconf.Output(p.rc, "Chmod %s on %s", p.Mode, path)
+	}
+
+	if p.rc.DryRun || (!chown && !chmod) {
+		return chown || chmod, nil
+	}
+
+	if !p.hasMode {
+		return true, haiconf.FilePath(path).Chown(uid, gid)
+	}
+
+	return true, haiconf.SetOwnerAndMode(haiconf.FilePath(path), uid, gid, p.Mode)
+}
+
+func matchAnyGlob(globs []string, rel string) bool {
+	for _, g := range globs {
+		if MatchGlob(g, rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkGlobs(k string, args haiconf.CommandArgs) ([]string, error) {
+	globs, err := haiconf.CheckStringList(k, args)
+	if err != nil {
+		g, err := haiconf.CheckString(k, args)
+		if err != nil {
+			return nil, err
+		}
+
+		globs = []string{g}
+	}
+
+	for _, g := range globs {
+		_, err = filepath.Match(g, "")
+		if err != nil {
+			return nil, haiconf.NewArgError("Invalid glob "+g+" : "+err.Error(), args)
+		}
+	}
+
+	return globs, nil
+}
+
+func (p *Permissions) setPath(args haiconf.CommandArgs) error {
+	path, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	p.Path = path
+	return nil
+}
+
+func (p *Permissions) setMatches(args haiconf.CommandArgs) error {
+	globs, err := checkGlobs("Matches", args)
+	if err != nil {
+		return err
+	}
+
+	p.Matches = globs
+	return nil
+}
+
+func (p *Permissions) setExclude(args haiconf.CommandArgs) error {
+	_, present := args["Exclude"]
+	if !present {
+		return nil
+	}
+
+	globs, err := checkGlobs("Exclude", args)
+	if err != nil {
+		return err
+	}
+
+	p.Exclude = globs
+	return nil
+}
+
+func (p *Permissions) setMode(args haiconf.CommandArgs) error {
+	_, present := args["Mode"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckFileMode("Mode", args)
+	if err != nil {
+		return err
+	}
+
+	p.Mode = m
+	p.hasMode = true
+	return nil
+}
+
+func (p *Permissions) setOwner(args haiconf.CommandArgs) error {
+	_, present := args["Owner"]
+	if !present {
+		return nil
+	}
+
+	u, err := haiconf.CheckSystemUser("Owner", args)
+	if err != nil {
+		return err
+	}
+
+	p.Owner = u
+	return nil
+}
+
+func (p *Permissions) setGroup(args haiconf.CommandArgs) error {
+	_, present := args["Group"]
+	if !present {
+		return nil
+	}
+
+	grp, err := haiconf.CheckSystemGroup("Group", args)
+	if err != nil {
+		return err
+	}
+
+	p.Group = grp
+	return nil
+}
+
+func (p *Permissions) setMatchDirectories(args haiconf.CommandArgs) error {
+	p.MatchDirectories = haiconf.CheckBool("MatchDirectories", args)
+	return nil
+}
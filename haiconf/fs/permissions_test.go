@@ -0,0 +1,184 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type PermissionsTestSuite struct {
+	p *Permissions
+}
+
+var _ = Suite(&PermissionsTestSuite{})
+
+func (s *PermissionsTestSuite) SetUpTest(c *C) {
+	s.p = new(Permissions)
+	err := s.p.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func createTree(c *C, root string, files []string) {
+	for _, f := range files {
+		err := os.MkdirAll(root+"/"+dirName(f), 0755)
+		c.Assert(err, IsNil)
+
+		err = ioutil.WriteFile(root+"/"+f, []byte(f), 0644)
+		c.Assert(err, IsNil)
+	}
+}
+
+func assertMode(c *C, p string, expected os.FileMode) {
+	fi, err := os.Stat(p)
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode()&haiconf.MODE_MASK, Equals, expected, Commentf(p))
+}
+
+func (s *PermissionsTestSuite) TestSetUserConfig_NothingToApply(c *C) {
+	err := s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path":    "/etc/ssl",
+		"Matches": "*.key",
+	})
+	c.Assert(err, ErrorMatches, "Mode, Owner or Group must be provided(.*)")
+}
+
+func (s *PermissionsTestSuite) TestSetUserConfig_NoMatches(c *C) {
+	err := s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path": "/etc/ssl",
+		"Mode": "0600",
+	})
+	c.Assert(err, ErrorMatches, "Matches must be provided(.*)")
+}
+
+func (s *PermissionsTestSuite) TestSetUserConfig(c *C) {
+	err := s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path":    "/etc/ssl",
+		"Matches": []interface{}{"**/*.key"},
+		"Exclude": "snakeoil*",
+		"Mode":    "0000",
+		"Owner":   currentUser.Username,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.p.Matches, DeepEquals, []string{"**/*.key"})
+	c.Assert(s.p.Exclude, DeepEquals, []string{"snakeoil*"})
+	c.Assert(s.p.Mode, Equals, os.FileMode(0))
+	c.Assert(s.p.hasMode, Equals, true)
+	c.Assert(s.p.Owner.Username, Equals, currentUser.Username)
+	c.Assert(s.p.Group, IsNil)
+}
+
+func (s *PermissionsTestSuite) TestRun(c *C) {
+	root := c.MkDir()
+	createTree(c, root, []string{
+		"server.key",
+		"server.pem",
+		"private/a.key",
+		"private/deep/b.key",
+		"private/snakeoil.key",
+		"excluded/c.key",
+	})
+
+	err := s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path":    root,
+		"Matches": "**/*.key",
+		"Exclude": []interface{}{"snakeoil.*", "excluded"},
+		"Mode":    "0600",
+		"Owner":   currentUser.Username,
+		"Group":   dummyGroup,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.p.Run()
+	c.Assert(err, IsNil)
+
+	for _, f := range []string{"server.key", "private/a.key", "private/deep/b.key"} {
+		assertMode(c, root+"/"+f, 0600)
+	}
+
+	for _, f := range []string{"server.pem", "private/snakeoil.key", "excluded/c.key"} {
+		assertMode(c, root+"/"+f, 0644)
+	}
+
+	// directories are left untouched
+	assertMode(c, root+"/private", 0755)
+}
+
+func (s *PermissionsTestSuite) TestRun_MatchDirectories(c *C) {
+	root := c.MkDir()
+	createTree(c, root, []string{"private/a.key"})
+
+	err := s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path":             root,
+		"Matches":          "private",
+		"Mode":             "u=rwx,g=rxs,o=",
+		"MatchDirectories": true,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.p.Run()
+	c.Assert(err, IsNil)
+
+	assertMode(c, root+"/private", 0750|os.ModeSetgid)
+	assertMode(c, root+"/private/a.key", 0644)
+}
+
+func (s *PermissionsTestSuite) TestRun_ChownKeepsSpecialBits(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("changing the owner of a file requires root")
+	}
+
+	root := c.MkDir()
+	createTree(c, root, []string{"bin/tool"})
+
+	mode := os.FileMode(0755) | os.ModeSetuid
+	err := os.Chmod(root+"/bin/tool", mode)
+	c.Assert(err, IsNil)
+
+	err = os.Chown(root+"/bin/tool", 65534, 65534)
+	c.Assert(err, IsNil)
+
+	// the mode is already right, only the owner changes
+	err = s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path":    root,
+		"Matches": "bin/*",
+		"Mode":    "4755",
+		"Owner":   currentUser.Uid,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.p.Run()
+	c.Assert(err, IsNil)
+
+	assertMode(c, root+"/bin/tool", mode)
+}
+
+func (s *PermissionsTestSuite) TestRun_DryRun(c *C) {
+	root := c.MkDir()
+	createTree(c, root, []string{"a.key"})
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+	s.p.SetDefault(&rc)
+
+	err := s.p.SetUserConfig(haiconf.CommandArgs{
+		"Path":    root,
+		"Matches": "*.key",
+		"Mode":    "0600",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.p.Run()
+	c.Assert(err, IsNil)
+
+	assertMode(c, root+"/a.key", 0644)
+	c.Assert(output.String(), Matches, "(?s)Chmod -rw------- on .*/a.key\nChanged permissions or ownership of 1 entries under .*")
+}
@@ -0,0 +1,520 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// JsonSetting({
+//     Path   = "/etc/elasticsearch/config.json",
+//     Key    = "http.port",
+//     Value  = 9200,
+//     Ensure = "present",
+//
+//     -- create the file when it does not exist
+//     Create = false,
+//     Mode   = "0644",
+// })
+//
+// YamlSetting({
+//     Path   = "/etc/app/config.yml",
+//     Key    = "database.hosts.0",
+//     Value  = {Name = "db-1", Port = 5432},
+//     Ensure = "present",
+// })
+//
+// Key is a dotted path, numeric parts index existing lists. Missing
+// maps are created. Ensure = "absent" removes the key.
+//
+// The order of the keys is preserved. JSON files keep their indentation,
+// YAML files are written back with yaml.v2 which drops comments, so the
+// file is only rewritten when the value actually changes.
+
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+
+	JSON_DEFAULT_INDENT = "  "
+)
+
+type JsonSetting struct {
+	structuredSetting
+}
+
+type YamlSetting struct {
+	structuredSetting
+}
+
+type structuredSetting struct {
+	Path   string
+	Key    string
+	Value  interface{}
+	Ensure string
+	Create bool
+	Mode   os.FileMode
+
+	format string
+	rc     *haiconf.RuntimeConfig
+}
+
+func (j *JsonSetting) SetDefault(rc *haiconf.RuntimeConfig) error {
+	j.setDefault(rc, FORMAT_JSON)
+	return nil
+}
+
+func (y *YamlSetting) SetDefault(rc *haiconf.RuntimeConfig) error {
+	y.setDefault(rc, FORMAT_YAML)
+	return nil
+}
+
+func (s *structuredSetting) setDefault(rc *haiconf.RuntimeConfig, format string) {
+	*s = structuredSetting{
+		Path:   "",
+		Key:    "",
+		Value:  nil,
+		Ensure: haiconf.ENSURE_PRESENT,
+		Create: false,
+		Mode:   DEFAULT_MODE_FILE,
+		format: format,
+		rc:     rc,
+	}
+}
+
+func (s *structuredSetting) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		s.setPath,
+		s.setKey,
+		s.setEnsure,
+		s.setValue,
+		s.setCreate,
+		s.setMode,
+	}
+
+	for _, set := range setters {
+		err = set(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *structuredSetting) Run() error {
+	_, err := os.Stat(s.Path)
+	if s.Ensure == haiconf.ENSURE_ABSENT && os.IsNotExist(err) {
+		return nil
+	}
+
+	create := s.Create && s.Ensure == haiconf.ENSURE_PRESENT
+
+	return editFile(s.rc, s.Path, create, s.Mode, s.apply)
+}
+
+func (s *structuredSetting) apply(buff []byte) ([]byte, error) {
+	doc, err := s.decode(buff)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s : %s", s.Path, err)
+	}
+
+	keys := strings.Split(s.Key, ".")
+	current, found := lookupSetting(doc, keys)
+
+	if s.Ensure == haiconf.ENSURE_ABSENT {
+		if !found {
+			return buff, nil
+		}
+
+		doc = deleteSetting(doc, keys)
+		return s.encode(doc, buff)
+	}
+
+	if found && sameSetting(current, s.Value) {
+		return buff, nil
+	}
+
+	doc, err = setSetting(doc, keys, s.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to set %s in %s : %s", s.Key, s.Path, err)
+	}
+
+	return s.encode(doc, buff)
+}
+
+// decode reads maps as yaml.MapSlice to keep the order of the keys
+func (s *structuredSetting) decode(buff []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(buff)) == 0 {
+		return yaml.MapSlice{}, nil
+	}
+
+	if s.format == FORMAT_YAML {
+		var doc yaml.MapSlice
+		err := yaml.Unmarshal(buff, &doc)
+		return doc, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buff))
+	dec.UseNumber()
+
+	return decodeJSON(dec)
+}
+
+func (s *structuredSetting) encode(doc interface{}, original []byte) ([]byte, error) {
+	if s.format == FORMAT_YAML {
+		return yaml.Marshal(doc)
+	}
+
+	out := new(bytes.Buffer)
+	err := encodeJSON(out, doc, jsonIndent(original), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+func lookupSetting(node interface{}, keys []string) (interface{}, bool) {
+	if len(keys) == 0 {
+		return node, true
+	}
+
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for _, item := range n {
+			if fmt.Sprint(item.Key) == keys[0] {
+				return lookupSetting(item.Value, keys[1:])
+			}
+		}
+	case []interface{}:
+		idx, err := strconv.Atoi(keys[0])
+		if err == nil && idx >= 0 && idx < len(n) {
+			return lookupSetting(n[idx], keys[1:])
+		}
+	}
+
+	return nil, false
+}
+
+func setSetting(node interface{}, keys []string, value interface{}) (interface{}, error) {
+	if len(keys) == 0 {
+		return toMapSlice(value), nil
+	}
+
+	var err error
+
+	switch n := node.(type) {
+	case nil:
+		return setSetting(yaml.MapSlice{}, keys, value)
+	case yaml.MapSlice:
+		for i, item := range n {
+			if fmt.Sprint(item.Key) == keys[0] {
+				n[i].Value, err = setSetting(item.Value, keys[1:], value)
+				return n, err
+			}
+		}
+
+		v, err := setSetting(nil, keys[1:], value)
+		return append(n, yaml.MapItem{Key: keys[0], Value: v}), err
+	case []interface{}:
+		idx, err := strconv.Atoi(keys[0])
+		if err != nil || idx < 0 || idx >= len(n) {
+			return nil, fmt.Errorf("%s is not a valid index", keys[0])
+		}
+
+		n[idx], err = setSetting(n[idx], keys[1:], value)
+		return n, err
+	}
+
+	return nil, fmt.Errorf("%v is not a map nor a list", node)
+}
+
+func deleteSetting(node interface{}, keys []string) interface{} {
+	last := len(keys) == 1
+
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for i, item := range n {
+			if fmt.Sprint(item.Key) != keys[0] {
+				continue
+			}
+
+			if last {
+				return append(n[:i], n[i+1:]...)
+			}
+
+			n[i].Value = deleteSetting(item.Value, keys[1:])
+			return n
+		}
+	case []interface{}:
+		idx, _ := strconv.Atoi(keys[0])
+
+		if last {
+			return append(n[:idx], n[idx+1:]...)
+		}
+
+		n[idx] = deleteSetting(n[idx], keys[1:])
+		return n
+	}
+
+	return node
+}
+
+// sameSetting compares values through their JSON representation
+// so numbers decoded from files match the ones coming from lua
+func sameSetting(a interface{}, b interface{}) bool {
+	bufA, bufB := new(bytes.Buffer), new(bytes.Buffer)
+
+	errA := encodeJSON(bufA, toMapSlice(a), "", 0)
+	errB := encodeJSON(bufB, toMapSlice(b), "", 0)
+
+	return errA == nil && errB == nil && bufA.String() == bufB.String()
+}
+
+// toMapSlice converts the maps received from lua to yaml.MapSlice,
+// keys are sorted.
+func toMapSlice(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		m := make(yaml.MapSlice, 0, len(t))
+		for _, k := range keys {
+			m = append(m, yaml.MapItem{Key: k, Value: toMapSlice(t[k])})
+		}
+		return m
+	case map[interface{}]interface{}:
+		return toMapSlice(normalizeYAML(t))
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			l[i] = toMapSlice(val)
+		}
+		return l
+	}
+
+	return v
+}
+
+func decodeJSON(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, isDelim := t.(json.Delim)
+	if !isDelim {
+		return t, nil
+	}
+
+	switch delim {
+	case '{':
+		m := yaml.MapSlice{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			m = append(m, yaml.MapItem{Key: k, Value: v})
+		}
+
+		_, err = dec.Token()
+		return m, err
+	case '[':
+		l := []interface{}{}
+		for dec.More() {
+			v, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			l = append(l, v)
+		}
+
+		_, err = dec.Token()
+		return l, err
+	}
+
+	return nil, fmt.Errorf("Unexpected %s", delim)
+}
+
+// encodeJSON writes v with one item per line, an empty indent
+// writes everything on a single line.
+func encodeJSON(w io.Writer, v interface{}, indent string, level int) error {
+	nl, pad, sep := "", "", ":"
+	if indent != "" {
+		nl = "\n"
+		pad = strings.Repeat(indent, level+1)
+		sep = ": "
+	}
+	closePad := strings.Repeat(indent, level)
+
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		if len(t) == 0 {
+			_, err := io.WriteString(w, "{}")
+			return err
+		}
+
+		io.WriteString(w, "{"+nl)
+		for i, item := range t {
+			k, _ := json.Marshal(fmt.Sprint(item.Key))
+			io.WriteString(w, pad+string(k)+sep)
+
+			err := encodeJSON(w, item.Value, indent, level+1)
+			if err != nil {
+				return err
+			}
+
+			if i < len(t)-1 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, nl)
+		}
+		_, err := io.WriteString(w, closePad+"}")
+		return err
+	case []interface{}:
+		if len(t) == 0 {
+			_, err := io.WriteString(w, "[]")
+			return err
+		}
+
+		io.WriteString(w, "["+nl)
+		for i, item := range t {
+			io.WriteString(w, pad)
+
+			err := encodeJSON(w, item, indent, level+1)
+			if err != nil {
+				return err
+			}
+
+			if i < len(t)-1 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, nl)
+		}
+		_, err := io.WriteString(w, closePad+"]")
+		return err
+	}
+
+	buff := new(bytes.Buffer)
+	enc := json.NewEncoder(buff)
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(bytes.TrimRight(buff.Bytes(), "\n"))
+	return err
+}
+
+// jsonIndent returns the indentation used by the first indented line
+func jsonIndent(buff []byte) string {
+	for _, line := range splitFileLines(buff) {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != line && trimmed != "" {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+
+	return JSON_DEFAULT_INDENT
+}
+
+func (s *structuredSetting) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	s.Path = p
+	return nil
+}
+
+func (s *structuredSetting) setKey(args haiconf.CommandArgs) error {
+	k, err := haiconf.CheckString("Key", args)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range strings.Split(k, ".") {
+		if part == "" {
+			return haiconf.NewArgError("Key must not contain empty parts", args)
+		}
+	}
+
+	s.Key = k
+	return nil
+}
+
+func (s *structuredSetting) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	s.Ensure = e
+	return nil
+}
+
+func (s *structuredSetting) setValue(args haiconf.CommandArgs) error {
+	if s.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	v, present := args["Value"]
+	if !present || v == nil {
+		return haiconf.NewArgError("Value must be provided", args)
+	}
+
+	s.Value = v
+	return nil
+}
+
+func (s *structuredSetting) setCreate(args haiconf.CommandArgs) error {
+	s.Create = haiconf.CheckBool("Create", args)
+	return nil
+}
+
+func (s *structuredSetting) setMode(args haiconf.CommandArgs) error {
+	_, present := args["Mode"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckFileMode("Mode", args)
+	if err != nil {
+		return err
+	}
+
+	s.Mode = m
+	return nil
+}
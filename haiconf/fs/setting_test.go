@@ -0,0 +1,195 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+)
+
+type SettingTestSuite struct{}
+
+var _ = Suite(&SettingTestSuite{})
+
+const jsonConfig = `{
+    "name": "app",
+    "http": {
+        "port": 8080,
+        "hosts": ["a", "b"]
+    },
+    "debug": true
+}
+`
+
+const yamlConfig = `name: app
+http:
+  port: 8080
+  hosts:
+  - a
+  - b
+debug: true
+`
+
+func runSetting(c *C, cmd haiconf.Commander, initial string, args haiconf.CommandArgs) string {
+	tmpFile := c.MkDir() + "/config"
+	err := ioutil.WriteFile(tmpFile, []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	err = cmd.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+
+	args["Path"] = tmpFile
+	err = cmd.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = cmd.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(tmpFile)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *SettingTestSuite) TestSetUserConfig_EmptyKeyPart(c *C) {
+	j := new(JsonSetting)
+	j.SetDefault(&dummyRuntimeConfig)
+
+	err := j.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/etc/config.json",
+		"Key":    "http..port",
+		"Value":  "1",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Key must not contain empty parts(.*)")
+}
+
+func (s *SettingTestSuite) TestJsonSetting_Unchanged(c *C) {
+	obtained := runSetting(c, new(JsonSetting), jsonConfig, haiconf.CommandArgs{
+		"Key":    "http.port",
+		"Value":  float64(8080),
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(obtained, Equals, jsonConfig)
+}
+
+func (s *SettingTestSuite) TestJsonSetting_Set(c *C) {
+	obtained := runSetting(c, new(JsonSetting), jsonConfig, haiconf.CommandArgs{
+		"Key":    "http.port",
+		"Value":  float64(9200),
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `{
+    "name": "app",
+    "http": {
+        "port": 9200,
+        "hosts": [
+            "a",
+            "b"
+        ]
+    },
+    "debug": true
+}
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *SettingTestSuite) TestJsonSetting_Create(c *C) {
+	obtained := runSetting(c, new(JsonSetting), "", haiconf.CommandArgs{
+		"Key":    "log.level",
+		"Value":  map[string]interface{}{"root": "info", "app": "debug"},
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `{
+  "log": {
+    "level": {
+      "app": "debug",
+      "root": "info"
+    }
+  }
+}
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *SettingTestSuite) TestJsonSetting_Absent(c *C) {
+	obtained := runSetting(c, new(JsonSetting), jsonConfig, haiconf.CommandArgs{
+		"Key":    "http.hosts.0",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+
+	expected := `{
+    "name": "app",
+    "http": {
+        "port": 8080,
+        "hosts": [
+            "b"
+        ]
+    },
+    "debug": true
+}
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *SettingTestSuite) TestJsonSetting_NotAMap(c *C) {
+	tmpFile := c.MkDir() + "/config.json"
+	err := ioutil.WriteFile(tmpFile, []byte(jsonConfig), 0644)
+	c.Assert(err, IsNil)
+
+	j := new(JsonSetting)
+	j.SetDefault(&dummyRuntimeConfig)
+
+	err = j.SetUserConfig(haiconf.CommandArgs{
+		"Path":   tmpFile,
+		"Key":    "name.first",
+		"Value":  "foo",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, IsNil)
+
+	err = j.Run()
+	c.Assert(err, ErrorMatches, "Unable to set name.first in (.*) : app is not a map nor a list")
+}
+
+func (s *SettingTestSuite) TestYamlSetting_Set(c *C) {
+	obtained := runSetting(c, new(YamlSetting), yamlConfig, haiconf.CommandArgs{
+		"Key":    "http.hosts.1",
+		"Value":  "c",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+
+	expected := `name: app
+http:
+  port: 8080
+  hosts:
+  - a
+  - c
+debug: true
+`
+	c.Assert(obtained, Equals, expected)
+}
+
+func (s *SettingTestSuite) TestYamlSetting_Unchanged(c *C) {
+	initial := "# comments are kept when nothing changes\n" + yamlConfig
+
+	obtained := runSetting(c, new(YamlSetting), initial, haiconf.CommandArgs{
+		"Key":    "debug",
+		"Value":  true,
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(obtained, Equals, initial)
+}
+
+func (s *SettingTestSuite) TestYamlSetting_Absent(c *C) {
+	obtained := runSetting(c, new(YamlSetting), yamlConfig, haiconf.CommandArgs{
+		"Key":    "http",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(obtained, Equals, "name: app\ndebug: true\n")
+}
@@ -0,0 +1,212 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MatchGlob reports whether rel, a relative path, or its base name
+// matches glob. A "**" path element matches any number of directories.
+func MatchGlob(glob string, rel string) bool {
+	if strings.Contains(glob, "**") {
+		return matchGlobElements(strings.Split(glob, "/"), strings.Split(rel, "/"))
+	}
+
+	m, _ := filepath.Match(glob, rel)
+	if m {
+		return true
+	}
+
+	m, _ = filepath.Match(glob, filepath.Base(rel))
+	return m
+}
+
+func matchGlobElements(glob []string, elements []string) bool {
+	if len(glob) == 0 {
+		return len(elements) == 0
+	}
+
+	if glob[0] == "**" {
+		for i := 0; i <= len(elements); i++ {
+			if matchGlobElements(glob[1:], elements[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(elements) == 0 {
+		return false
+	}
+
+	m, _ := filepath.Match(glob[0], elements[0])
+	return m && matchGlobElements(glob[1:], elements[1:])
+}
+
+// sync copies every file from d.Source to d.Path and, when d.Purge is
+// set, removes everything in d.Path which does not exist in d.Source.
+func (d *Directory) sync() error {
+	if d.Source == "" {
+		return nil
+	}
+
+	haiconf.Output(d.rc, "Synchronizing %s with %s", d.Path, d.Source)
+
+	managed, err := d.copySource()
+	if err != nil {
+		return err
+	}
+
+	if !d.Purge {
+		return nil
+	}
+
+	return d.purge(managed)
+}
+
+// copySource returns the relative paths of the files and directories
+// found in d.Source.
+func (d *Directory) copySource() (map[string]bool, error) {
+	managed := map[string]bool{}
+
+	err := filepath.Walk(d.Source, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(d.Source, src)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if d.isIgnored(rel) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		managed[rel] = true
+		dest := filepath.Join(d.Path, rel)
+
+		if fi.IsDir() {
+			sub := Directory{
+				Path:    dest,
+				Mode:    d.Mode,
+				Owner:   d.Owner,
+				Group:   d.Group,
+				Recurse: true,
+				Ensure:  haiconf.ENSURE_PRESENT,
+				rc:      d.rc,
+			}
+
+			return sub.Run()
+		}
+
+		// checksums are compared by File, unchanged files are not rewritten
+		f := File{
+			Path:    dest,
+			Mode:    fi.Mode().Perm(),
+			Ensure:  haiconf.ENSURE_PRESENT,
+			Owner:   d.Owner,
+			Group:   d.Group,
+			Source:  src,
+			Sources: []string{src},
+			rc:      d.rc,
+		}
+
+		return f.Run()
+	})
+
+	return managed, err
+}
+
+func (d *Directory) purge(managed map[string]bool) error {
+	var unmanaged []string
+
+	err := filepath.Walk(d.Path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// does not exist yet in dry-run mode
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(d.Path, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." || managed[rel] {
+			return nil
+		}
+
+		if d.isIgnored(rel) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		unmanaged = append(unmanaged, p)
+
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(unmanaged)
+	bucket := backup.NewBucket(d.rc.BackupDir, d.rc.RunId)
+
+	for _, p := range unmanaged {
+		haiconf.Output(d.rc, "Purging %s", p)
+		if d.rc.DryRun {
+			continue
+		}
+
+		_, err = bucket.StoreTree(p)
+		if err != nil {
+			return err
+		}
+
+		err = os.RemoveAll(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Directory) isIgnored(rel string) bool {
+	for _, g := range d.Ignore {
+		if MatchGlob(g, rel) {
+			return true
+		}
+	}
+
+	return false
+}
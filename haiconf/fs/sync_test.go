@@ -0,0 +1,132 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type SyncTestSuite struct {
+	d *Directory
+}
+
+var _ = Suite(&SyncTestSuite{})
+
+func (s *SyncTestSuite) SetUpTest(c *C) {
+	s.d = new(Directory)
+	err := s.d.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *SyncTestSuite) args(dest string, src string) haiconf.CommandArgs {
+	return haiconf.CommandArgs{
+		"Path":   dest,
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0755",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Source": src,
+		"Purge":  true,
+		"Ignore": []interface{}{"README"},
+	}
+}
+
+func (s *SyncTestSuite) TestSetUserConfig_PurgeWithoutSource(c *C) {
+	err := s.d.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/tmp/foo",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Mode":   "0755",
+		"Owner":  currentUser.Username,
+		"Group":  dummyGroup,
+		"Purge":  true,
+	})
+	c.Assert(err, ErrorMatches, "Purge requires a Source. (.*)")
+}
+
+func (s *SyncTestSuite) TestRun_SyncAndPurge(c *C) {
+	src := c.MkDir()
+	dest := c.MkDir() + "/sudoers.d"
+
+	err := os.MkdirAll(src+"/sub", 0755)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(src+"/admins", []byte("%admin ALL=(ALL) ALL\n"), 0440)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(src+"/sub/foo", []byte("foo\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.MkdirAll(dest+"/stray-dir", 0755)
+	c.Assert(err, IsNil)
+
+	for _, f := range []string{"/stray", "/stray-dir/file", "/README", "/admins"} {
+		err = ioutil.WriteFile(dest+f, []byte("old\n"), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	err = s.d.SetUserConfig(s.args(dest, src))
+	c.Assert(err, IsNil)
+
+	err = s.d.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(dest + "/admins")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "%admin ALL=(ALL) ALL\n")
+
+	fi, err := os.Stat(dest + "/admins")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0440))
+
+	buff, err = ioutil.ReadFile(dest + "/sub/foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "foo\n")
+
+	_, err = os.Stat(dest + "/stray")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = os.Stat(dest + "/stray-dir")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// ignored
+	_, err = os.Stat(dest + "/README")
+	c.Assert(err, IsNil)
+}
+
+func (s *SyncTestSuite) TestRun_DryRunDoesNotPurge(c *C) {
+	src := c.MkDir()
+	dest := c.MkDir()
+
+	err := ioutil.WriteFile(dest+"/stray", []byte("old\n"), 0644)
+	c.Assert(err, IsNil)
+
+	rc := haiconf.RuntimeConfig{DryRun: true, Output: ioutil.Discard}
+	s.d.SetDefault(&rc)
+
+	err = s.d.SetUserConfig(s.args(dest, src))
+	c.Assert(err, IsNil)
+
+	err = s.d.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(dest + "/stray")
+	c.Assert(err, IsNil)
+}
+
+func (s *SyncTestSuite) TestMatchGlob(c *C) {
+	c.Assert(MatchGlob("*.dpkg-*", "foo.dpkg-old"), Equals, true)
+	c.Assert(MatchGlob("*.dpkg-*", "sub/foo.dpkg-old"), Equals, true)
+	c.Assert(MatchGlob("sub/*", "sub/foo"), Equals, true)
+	c.Assert(MatchGlob("sub/*", "foo"), Equals, false)
+	c.Assert(MatchGlob("**/*.key", "foo.key"), Equals, true)
+	c.Assert(MatchGlob("**/*.key", "a/b/foo.key"), Equals, true)
+	c.Assert(MatchGlob("a/**/foo.key", "a/foo.key"), Equals, true)
+	c.Assert(MatchGlob("a/**/foo.key", "a/b/c/foo.key"), Equals, true)
+	c.Assert(MatchGlob("a/**", "a/b/c"), Equals, true)
+	c.Assert(MatchGlob("a/**/*.key", "b/foo.key"), Equals, false)
+}
@@ -0,0 +1,312 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Sysctl({
+//     Name    = "vm.swappiness",
+//     Value   = 10,
+//     Ensure  = "present",
+//
+//     -- optional, also write the value to /etc/sysctl.d/<File>.conf
+//     -- so it survives reboots
+//     Persist = true,
+//     File    = "60-database",
+//
+//     -- optional, / by default
+//     Root    = "/",
+// })
+//
+// The value is applied live by writing to /proc/sys. When absent the
+// parameter is removed from the sysctl.d file, the live value is kept
+// since the kernel has no notion of an unset parameter.
+
+package fs
+
+import (
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_SYSCTL_FILE = "99-haiconf"
+)
+
+var sysctlNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+([./][A-Za-z0-9_-]+)*$`)
+
+type Sysctl struct {
+	Name    string
+	Value   string
+	Ensure  string
+	Persist bool
+	File    string
+	Root    string
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (s *Sysctl) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*s = Sysctl{
+		Name:    "",
+		Value:   "",
+		Ensure:  haiconf.ENSURE_PRESENT,
+		Persist: false,
+		File:    DEFAULT_SYSCTL_FILE,
+		Root:    "/",
+		rc:      rc,
+	}
+
+	return nil
+}
+
+func (s *Sysctl) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		s.setName,
+		s.setEnsure,
+		s.setValue,
+		s.setPersist,
+		s.setFile,
+		s.setRoot,
+	}
+
+	for _, f := range setters {
+		err = f(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Sysctl) Run() error {
+	if s.Ensure == haiconf.ENSURE_ABSENT {
+		return s.unpersist()
+	}
+
+	err := s.apply()
+	if err != nil {
+		return err
+	}
+
+	if !s.Persist {
+		return nil
+	}
+
+	return s.persist()
+}
+
+// apply writes Value to /proc/sys when the current value differs
+func (s *Sysctl) apply() error {
+	p := s.procPath()
+
+	buff, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Unknown kernel parameter %s", s.Name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	current := normalizeSysctlValue(string(buff))
+	if current == s.Value {
+		haiconf.Output(s.rc, "Kernel parameter %s is already set to %s", s.Name, s.Value)
+		return nil
+	}
+
+	haiconf.Output(s.rc, "Setting kernel parameter %s to %s (was %s)", s.Name, s.Value, current)
+	if s.rc.DryRun {
+		return nil
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(s.Value + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *Sysctl) persist() error {
+	return editFile(s.rc, s.confPath(), true, DEFAULT_MODE_FILE, func(buff []byte) ([]byte, error) {
+		return joinFileLines(s.edit(splitFileLines(buff))), nil
+	})
+}
+
+func (s *Sysctl) unpersist() error {
+	_, err := os.Stat(s.confPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return editFile(s.rc, s.confPath(), false, DEFAULT_MODE_FILE, func(buff []byte) ([]byte, error) {
+		return joinFileLines(s.edit(splitFileLines(buff))), nil
+	})
+}
+
+// edit replaces the first setting for Name, other settings
+// for Name are removed since the last one would win
+func (s *Sysctl) edit(lines []string) []string {
+	setting := s.Name + " = " + s.Value
+	name := sysctlPath(s.Name)
+	out := make([]string, 0, len(lines)+1)
+	replaced := false
+
+	for _, line := range lines {
+		k := strings.TrimPrefix(iniKey(line), "-")
+		if sysctlPath(k) != name {
+			out = append(out, line)
+			continue
+		}
+
+		if s.Ensure == haiconf.ENSURE_PRESENT && !replaced {
+			out = append(out, setting)
+			replaced = true
+		}
+	}
+
+	if s.Ensure == haiconf.ENSURE_PRESENT && !replaced {
+		out = append(out, setting)
+	}
+
+	return out
+}
+
+func (s *Sysctl) procPath() string {
+	return path.Join(s.Root, "proc", "sys", sysctlPath(s.Name))
+}
+
+// sysctlPath follows sysctl(8): names whose first separator is a slash
+// are already paths, otherwise dots and slashes are swapped so that
+// net.ipv4.conf.eth0/100.rp_filter refers to the eth0.100 interface
+func sysctlPath(name string) string {
+	idx := strings.IndexAny(name, "./")
+	if idx < 0 || name[idx] == '/' {
+		return name
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.':
+			return '/'
+		case '/':
+			return '.'
+		}
+
+		return r
+	}, name)
+}
+
+func (s *Sysctl) confPath() string {
+	return path.Join(s.Root, "etc", "sysctl.d", s.File+".conf")
+}
+
+// normalizeSysctlValue collapses the tabs and spaces separating
+// multiple values, such as net.ipv4.ip_local_port_range
+func normalizeSysctlValue(v string) string {
+	return strings.Join(strings.Fields(v), " ")
+}
+
+func (s *Sysctl) setName(args haiconf.CommandArgs) error {
+	n, err := haiconf.CheckString("Name", args)
+	if err != nil {
+		return err
+	}
+
+	if !sysctlNameRegexp.MatchString(n) {
+		return haiconf.NewArgError("Invalid kernel parameter name "+n, args)
+	}
+
+	s.Name = n
+	return nil
+}
+
+func (s *Sysctl) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	s.Ensure = e
+	return nil
+}
+
+func (s *Sysctl) setValue(args haiconf.CommandArgs) error {
+	if s.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	v, present := args["Value"]
+	if !present {
+		return haiconf.NewArgError("Value must be provided", args)
+	}
+
+	switch t := v.(type) {
+	case float64:
+		s.Value = strconv.FormatFloat(t, 'f', -1, 64)
+		return nil
+	case string:
+		s.Value = normalizeSysctlValue(t)
+		if s.Value != "" {
+			return nil
+		}
+	}
+
+	return haiconf.NewArgError("Value must be a string or a number", args)
+}
+
+func (s *Sysctl) setPersist(args haiconf.CommandArgs) error {
+	s.Persist = haiconf.CheckBool("Persist", args)
+	return nil
+}
+
+func (s *Sysctl) setFile(args haiconf.CommandArgs) error {
+	_, present := args["File"]
+	if !present {
+		return nil
+	}
+
+	f, err := haiconf.CheckString("File", args)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(f, "/") {
+		return haiconf.NewArgError("File must be a file name, not a path", args)
+	}
+
+	s.File = strings.TrimSuffix(f, ".conf")
+	return nil
+}
+
+func (s *Sysctl) setRoot(args haiconf.CommandArgs) error {
+	_, present := args["Root"]
+	if !present {
+		return nil
+	}
+
+	r, err := haiconf.CheckAbsolutePath("Root", args)
+	if err != nil {
+		return err
+	}
+
+	s.Root = r
+	return nil
+}
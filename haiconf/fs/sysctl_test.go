@@ -0,0 +1,306 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type SysctlTestSuite struct {
+	s    *Sysctl
+	root string
+}
+
+var _ = Suite(&SysctlTestSuite{})
+
+func (s *SysctlTestSuite) SetUpTest(c *C) {
+	s.s = new(Sysctl)
+	err := s.s.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+
+	s.root = c.MkDir()
+
+	err = os.MkdirAll(s.root+"/proc/sys/vm", 0755)
+	c.Assert(err, IsNil)
+
+	err = os.MkdirAll(s.root+"/proc/sys/net/ipv4", 0755)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(s.root+"/proc/sys/vm/swappiness", []byte("60\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(s.root+"/proc/sys/net/ipv4/ip_local_port_range", []byte("32768\t60999\n"), 0644)
+	c.Assert(err, IsNil)
+}
+
+func (s *SysctlTestSuite) assertFile(c *C, p string, expected string) {
+	buff, err := ioutil.ReadFile(s.root + p)
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, expected)
+}
+
+func (s *SysctlTestSuite) TestSetDefault(c *C) {
+	c.Assert(s.s.File, Equals, DEFAULT_SYSCTL_FILE)
+	c.Assert(s.s.Root, Equals, "/")
+	c.Assert(s.s.Persist, Equals, false)
+}
+
+func (s *SysctlTestSuite) TestSetUserConfig(c *C) {
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":    "net.ipv4.ip_local_port_range",
+		"Value":   "1024  65000",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Persist": true,
+		"File":    "60-database.conf",
+		"Root":    s.root,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.s.Value, Equals, "1024 65000")
+	c.Assert(s.s.File, Equals, "60-database")
+	c.Assert(s.s.procPath(), Equals, s.root+"/proc/sys/net/ipv4/ip_local_port_range")
+	c.Assert(s.s.confPath(), Equals, s.root+"/etc/sysctl.d/60-database.conf")
+
+	err = s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "kernel.shmmax",
+		"Value":  float64(4294967295),
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.s.Value, Equals, "4294967295")
+}
+
+func (s *SysctlTestSuite) TestProcPath(c *C) {
+	paths := map[string]string{
+		"kernel.shmmax":                     "/proc/sys/kernel/shmmax",
+		"net.ipv4.conf.eth0/100.rp_filter":  "/proc/sys/net/ipv4/conf/eth0.100/rp_filter",
+		"net/ipv4/conf/eth0.100/forwarding": "/proc/sys/net/ipv4/conf/eth0.100/forwarding",
+		"net/ipv4/ip_forward":               "/proc/sys/net/ipv4/ip_forward",
+		"vm.swappiness":                     "/proc/sys/vm/swappiness",
+	}
+
+	s.s.Root = s.root
+
+	for name, expected := range paths {
+		s.s.Name = name
+		c.Assert(s.s.procPath(), Equals, s.root+expected, Commentf(name))
+	}
+}
+
+func (s *SysctlTestSuite) TestSetUserConfig_InvalidName(c *C) {
+	for _, n := range []string{"../../etc/passwd", "vm..swappiness", "vm swappiness"} {
+		err := s.s.SetUserConfig(haiconf.CommandArgs{
+			"Name":   n,
+			"Value":  "1",
+			"Ensure": haiconf.ENSURE_PRESENT,
+		})
+		c.Assert(err, ErrorMatches, "Invalid kernel parameter name (.*)")
+	}
+}
+
+func (s *SysctlTestSuite) TestSetUserConfig_NoValue(c *C) {
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "vm.swappiness",
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, ErrorMatches, "Value must be provided(.*)")
+
+	err = s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "vm.swappiness",
+		"Ensure": haiconf.ENSURE_ABSENT,
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *SysctlTestSuite) TestRun(c *C) {
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "vm.swappiness",
+		"Value":  float64(10),
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Root":   s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	s.assertFile(c, "/proc/sys/vm/swappiness", "10\n")
+
+	_, err = os.Stat(s.root + "/etc/sysctl.d")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SysctlTestSuite) TestRun_UnknownParameter(c *C) {
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "vm.foo",
+		"Value":  "1",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Root":   s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, ErrorMatches, "Unknown kernel parameter vm.foo")
+}
+
+func (s *SysctlTestSuite) TestRun_UpToDate(c *C) {
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		Verbose: true,
+		Output:  output,
+	}
+	s.s.SetDefault(&rc)
+
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "net.ipv4.ip_local_port_range",
+		"Value":  "32768 60999",
+		"Ensure": haiconf.ENSURE_PRESENT,
+		"Root":   s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	c.Assert(output.String(), Equals, "Kernel parameter net.ipv4.ip_local_port_range is already set to 32768 60999\n")
+	s.assertFile(c, "/proc/sys/net/ipv4/ip_local_port_range", "32768\t60999\n")
+}
+
+func (s *SysctlTestSuite) TestRun_DryRun(c *C) {
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+	s.s.SetDefault(&rc)
+
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":    "vm.swappiness",
+		"Value":   "10",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Persist": true,
+		"Root":    s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	c.Assert(output.String(), Matches, "Setting kernel parameter vm.swappiness to 10 \\(was 60\\)\n(?s).*")
+	s.assertFile(c, "/proc/sys/vm/swappiness", "60\n")
+
+	_, err = os.Stat(s.root + "/etc/sysctl.d")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SysctlTestSuite) TestRun_Persist(c *C) {
+	err := os.MkdirAll(s.root+"/etc/sysctl.d", 0755)
+	c.Assert(err, IsNil)
+
+	initial := "# database tuning\nvm/swappiness=60\nvm.dirty_ratio = 10\nvm.swappiness = 30\n"
+	err = ioutil.WriteFile(s.root+"/etc/sysctl.d/60-database.conf", []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":    "vm.swappiness",
+		"Value":   "10",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Persist": true,
+		"File":    "60-database",
+		"Root":    s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	s.assertFile(c, "/proc/sys/vm/swappiness", "10\n")
+	s.assertFile(c, "/etc/sysctl.d/60-database.conf", "# database tuning\nvm.swappiness = 10\nvm.dirty_ratio = 10\n")
+}
+
+func (s *SysctlTestSuite) TestRun_PersistVlanInterface(c *C) {
+	err := os.MkdirAll(s.root+"/proc/sys/net/ipv4/conf/eth0.100", 0755)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(s.root+"/proc/sys/net/ipv4/conf/eth0.100/rp_filter", []byte("1\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.MkdirAll(s.root+"/etc/sysctl.d", 0755)
+	c.Assert(err, IsNil)
+
+	// the first and last lines set the same parameter, the second one
+	// refers to conf/eth0/100
+	initial := "net/ipv4/conf/eth0.100/rp_filter = 1\nnet.ipv4.conf.eth0.100.rp_filter = 1\nnet.ipv4.conf.eth0/100.rp_filter = 1\n"
+	err = ioutil.WriteFile(s.root+"/etc/sysctl.d/"+DEFAULT_SYSCTL_FILE+".conf", []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":    "net.ipv4.conf.eth0/100.rp_filter",
+		"Value":   "2",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Persist": true,
+		"Root":    s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	s.assertFile(c, "/proc/sys/net/ipv4/conf/eth0.100/rp_filter", "2\n")
+	s.assertFile(c, "/etc/sysctl.d/"+DEFAULT_SYSCTL_FILE+".conf", "net.ipv4.conf.eth0/100.rp_filter = 2\nnet.ipv4.conf.eth0.100.rp_filter = 1\n")
+}
+
+func (s *SysctlTestSuite) TestRun_PersistNewFile(c *C) {
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":    "vm.swappiness",
+		"Value":   "10",
+		"Ensure":  haiconf.ENSURE_PRESENT,
+		"Persist": true,
+		"Root":    s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	s.assertFile(c, "/etc/sysctl.d/"+DEFAULT_SYSCTL_FILE+".conf", "vm.swappiness = 10\n")
+}
+
+func (s *SysctlTestSuite) TestRun_Absent(c *C) {
+	err := os.MkdirAll(s.root+"/etc/sysctl.d", 0755)
+	c.Assert(err, IsNil)
+
+	initial := "vm.swappiness = 10\nvm.dirty_ratio = 10\n"
+	err = ioutil.WriteFile(s.root+"/etc/sysctl.d/"+DEFAULT_SYSCTL_FILE+".conf", []byte(initial), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "vm.swappiness",
+		"Ensure": haiconf.ENSURE_ABSENT,
+		"Root":   s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+
+	s.assertFile(c, "/etc/sysctl.d/"+DEFAULT_SYSCTL_FILE+".conf", "vm.dirty_ratio = 10\n")
+	s.assertFile(c, "/proc/sys/vm/swappiness", "60\n")
+}
+
+func (s *SysctlTestSuite) TestRun_AbsentMissingFile(c *C) {
+	err := s.s.SetUserConfig(haiconf.CommandArgs{
+		"Name":   "vm.swappiness",
+		"Ensure": haiconf.ENSURE_ABSENT,
+		"Root":   s.root,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.s.Run()
+	c.Assert(err, IsNil)
+}
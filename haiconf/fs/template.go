@@ -0,0 +1,212 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	// text/template errors look like
+	// "template: /path/to/file:12: unexpected ..."
+	// "template: /path/to/file:12:4: executing ..."
+	templateErrorRegexp = regexp.MustCompile(`^template: (.*?):(\d+):`)
+)
+
+// Template renders text/template files with the haiconf function library.
+//
+// Available functions:
+//
+//	join ", " .List          split "," .String
+//	default "foo" .Value     upper .String / lower .String
+//	indent 4 .String         toJSON .Value / toYAML .Value
+//	fact "hostname"          env "HOME"
+//
+// Partials are all the files in PartialsDir, they can be included
+// with {{template "file-name"}}.
+type Template struct {
+	Name        string
+	PartialsDir string
+
+	// Strict makes the rendering fail when a variable is missing
+	Strict bool
+}
+
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"join":    templateJoin,
+		"split":   templateSplit,
+		"default": templateDefault,
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+		"indent":  templateIndent,
+		"toJSON":  templateToJSON,
+		"toYAML":  templateToYAML,
+		"fact":    templateFact,
+		"env":     os.Getenv,
+	}
+}
+
+func (t *Template) Render(buff []byte, vars map[string]interface{}) ([]byte, error) {
+	tpl := template.New(t.Name).Funcs(TemplateFuncs())
+
+	if t.Strict {
+		tpl = tpl.Option("missingkey=error")
+	}
+
+	_, err := tpl.Parse(string(buff))
+	if err != nil {
+		return nil, t.newError(err)
+	}
+
+	err = t.parsePartials(tpl)
+	if err != nil {
+		return nil, t.newError(err)
+	}
+
+	out := new(bytes.Buffer)
+	err = tpl.Execute(out, vars)
+	if err != nil {
+		return nil, t.newError(err)
+	}
+
+	return out.Bytes(), nil
+}
+
+func (t *Template) parsePartials(tpl *template.Template) error {
+	if t.PartialsDir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(t.PartialsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+
+		p := filepath.Join(t.PartialsDir, fi.Name())
+		buff, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		_, err = tpl.New(fi.Name()).Parse(string(buff))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newError locates err in the template. Variables are left out
+// of the error as they may hold secrets.
+func (t *Template) newError(err error) *haiconf.HaiconfError {
+	args := haiconf.CommandArgs{
+		"Template": t.Name,
+	}
+
+	m := templateErrorRegexp.FindStringSubmatch(err.Error())
+	if m != nil {
+		args["Template"] = m[1]
+		args["Line"], _ = strconv.Atoi(m[2])
+	}
+
+	return haiconf.NewArgError("Unable to render template : "+err.Error(), args)
+}
+
+func templateJoin(sep string, list interface{}) string {
+	return strings.Join(toStringSlice(list), sep)
+}
+
+func templateSplit(sep string, s string) []string {
+	return strings.Split(s, sep)
+}
+
+// templateDefault returns def when given is missing or empty
+func templateDefault(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || given[0] == nil {
+		return def
+	}
+
+	v := reflect.ValueOf(given[0])
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	}
+
+	return given[0]
+}
+
+func templateIndent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	lines := strings.Split(s, "\n")
+
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = pad + l
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func templateToJSON(v interface{}) (string, error) {
+	buff, err := json.Marshal(v)
+	return string(buff), err
+}
+
+func templateToYAML(v interface{}) (string, error) {
+	buff, err := yaml.Marshal(v)
+	return strings.TrimRight(string(buff), "\n"), err
+}
+
+func templateFact(name string) (string, error) {
+	switch name {
+	case "hostname":
+		return os.Hostname()
+	case "os":
+		return runtime.GOOS, nil
+	case "arch":
+		return runtime.GOARCH, nil
+	case "cpus":
+		return strconv.Itoa(runtime.NumCPU()), nil
+	}
+
+	return "", fmt.Errorf("Unknown fact %s", name)
+}
+
+func toStringSlice(list interface{}) []string {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []string{fmt.Sprint(list)}
+	}
+
+	strList := make([]string, v.Len())
+	for i := range strList {
+		strList[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+
+	return strList
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type TemplateTestSuite struct{}
+
+var _ = Suite(&TemplateTestSuite{})
+
+func (s *TemplateTestSuite) render(c *C, tpl string, vars map[string]interface{}) string {
+	t := Template{Name: "test"}
+
+	buff, err := t.Render([]byte(tpl), vars)
+	c.Assert(err, IsNil)
+
+	return string(buff)
+}
+
+func (s *TemplateTestSuite) TestFuncs(c *C) {
+	vars := map[string]interface{}{
+		"List":   []interface{}{"a", "b", "c"},
+		"String": "Foo,Bar",
+		"Empty":  "",
+		"Text":   "a\nb",
+		"Map":    map[string]interface{}{"b": 1, "a": []interface{}{"x", "y"}},
+	}
+
+	c.Assert(s.render(c, `{{join ", " .List}}`, vars), Equals, "a, b, c")
+	c.Assert(s.render(c, `{{index (split "," .String) 1}}`, vars), Equals, "Bar")
+	c.Assert(s.render(c, `{{default "foo" .Empty}}`, vars), Equals, "foo")
+	c.Assert(s.render(c, `{{default "foo" .Missing}}`, vars), Equals, "foo")
+	c.Assert(s.render(c, `{{default "foo" .String}}`, vars), Equals, "Foo,Bar")
+	c.Assert(s.render(c, `{{upper .String}} {{lower .String}}`, vars), Equals, "FOO,BAR foo,bar")
+	c.Assert(s.render(c, `{{indent 2 .Text}}`, vars), Equals, "  a\n  b")
+	c.Assert(s.render(c, `{{toJSON .List}}`, vars), Equals, `["a","b","c"]`)
+	c.Assert(s.render(c, `{{toYAML .Map}}`, vars), Equals, "a:\n- x\n- \"y\"\nb: 1")
+	c.Assert(s.render(c, `{{toYAML .String}} {{toYAML "true"}}`, vars), Equals, `Foo,Bar "true"`)
+	c.Assert(s.render(c, `{{env "HAICONF_TEMPLATE_TEST"}}`, vars), Equals, "")
+
+	hostname, err := os.Hostname()
+	c.Assert(err, IsNil)
+	c.Assert(s.render(c, `{{fact "hostname"}}`, vars), Equals, hostname)
+}
+
+func (s *TemplateTestSuite) TestRender_Partials(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	t := Template{
+		Name:        "test",
+		PartialsDir: cwd + "/testdata/partials",
+	}
+
+	buff, err := t.Render([]byte(`{{template "header" .}}foo`), map[string]interface{}{"Host": "bar"})
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "# managed by haiconf, host bar\nfoo")
+}
+
+func (s *TemplateTestSuite) TestRender_Strict(c *C) {
+	t := Template{
+		Name:   "test",
+		Strict: true,
+	}
+
+	_, err := t.Render([]byte("foo\n{{.Missing}}"), map[string]interface{}{})
+	c.Assert(err, FitsTypeOf, &haiconf.HaiconfError{})
+	c.Assert(err.(*haiconf.HaiconfError).Args["Template"], Equals, "test")
+	c.Assert(err.(*haiconf.HaiconfError).Args["Line"], Equals, 2)
+}
+
+func (s *TemplateTestSuite) TestRender_ErrorHidesVariables(c *C) {
+	t := Template{Name: "test"}
+
+	_, err := t.Render([]byte("{{.Password.Foo}}"), map[string]interface{}{"Password": "secret"})
+	c.Assert(err, NotNil)
+	_, hasVariables := err.(*haiconf.HaiconfError).Args["TemplateVariables"]
+	c.Assert(hasVariables, Equals, false)
+	c.Assert(err, Not(ErrorMatches), ".*secret.*")
+}
+
+func (s *TemplateTestSuite) TestRender_SyntaxError(c *C) {
+	t := Template{Name: "/path/to/tpl"}
+
+	_, err := t.Render([]byte("foo\nbar\n{{.Foo"), map[string]interface{}{})
+	c.Assert(err, ErrorMatches, "Unable to render template : template: /path/to/tpl:3: .*")
+	c.Assert(err.(*haiconf.HaiconfError).Args["Template"], Equals, "/path/to/tpl")
+	c.Assert(err.(*haiconf.HaiconfError).Args["Line"], Equals, 3)
+}
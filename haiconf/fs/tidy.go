@@ -0,0 +1,385 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Tidy({
+//     Path    = "/var/log/app",
+//
+//     -- optional, everything under Path matches by default. Globs
+//     -- are matched against the path relative to Path or the file name
+//     Matches = {"*.log.*", "*.gz"},
+//
+//     -- at least one of Age and Size must be given.
+//     -- Units are s, m, h, d and w
+//     Age     = "2w",
+//
+//     -- units are k, M, G and T (powers of 1024)
+//     Size    = "100M",
+//
+//     -- optional, "any" removes entries older than Age or at least
+//     -- as large as Size, "all" requires both
+//     Combine = "any",
+//
+//     -- optional, look into sub directories
+//     Recurse = true,
+//
+//     -- optional, directories matching Matches and Age are removed
+//     -- with their content. Size does not apply to directories
+//     MatchDirectories = false,
+//
+//     -- optional, the 5 most recently modified entries matching
+//     -- Matches are always kept
+//     KeepNewest = 5,
+// })
+//
+// Removed entries are not backed up since Tidy is meant to free
+// disk space.
+
+package fs
+
+import (
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TIDY_COMBINE_ANY = "any"
+	TIDY_COMBINE_ALL = "all"
+)
+
+type Tidy struct {
+	Path             string
+	Matches          []string
+	Age              time.Duration
+	Size             int64
+	Combine          string
+	Recurse          bool
+	MatchDirectories bool
+	KeepNewest       int
+
+	rc *haiconf.RuntimeConfig
+}
+
+type tidyEntry struct {
+	path string
+	fi   os.FileInfo
+}
+
+type byModTime []tidyEntry
+
+func (b byModTime) Len() int           { return len(b) }
+func (b byModTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byModTime) Less(i, j int) bool { return b[i].fi.ModTime().After(b[j].fi.ModTime()) }
+
+func (t *Tidy) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*t = Tidy{
+		Path:             "",
+		Matches:          []string{"*"},
+		Age:              0,
+		Size:             0,
+		Combine:          TIDY_COMBINE_ANY,
+		Recurse:          false,
+		MatchDirectories: false,
+		KeepNewest:       0,
+		rc:               rc,
+	}
+
+	return nil
+}
+
+func (t *Tidy) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		t.setPath,
+		t.setMatches,
+		t.setAge,
+		t.setSize,
+		t.setCombine,
+		t.setRecurse,
+		t.setKeepNewest,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Tidy) Run() error {
+	_, err := os.Stat(t.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	entries, err := t.collect()
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(byModTime(entries))
+
+	if t.KeepNewest >= len(entries) {
+		entries = nil
+	} else {
+		entries = entries[t.KeepNewest:]
+	}
+
+	now := time.Now()
+	removed := 0
+
+	for _, e := range entries {
+		if !t.expired(e, now) {
+			continue
+		}
+
+		haiconf.Output(t.rc, "Removing %s", e.path)
+		removed++
+
+		if t.rc.DryRun {
+			continue
+		}
+
+		err = os.RemoveAll(e.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	haiconf.Output(t.rc, "Removed %d entries under %s", removed, t.Path)
+
+	return nil
+}
+
+// expired tells whether e is old or large enough to be removed
+func (t *Tidy) expired(e tidyEntry, now time.Time) bool {
+	old := t.Age > 0 && now.Sub(e.fi.ModTime()) >= t.Age
+
+	// the size of a directory is the one of its inode, not of its content
+	if e.fi.IsDir() {
+		return old
+	}
+	large := t.Size > 0 && e.fi.Size() >= t.Size
+
+	if t.Combine == TIDY_COMBINE_ALL {
+		return (t.Age == 0 || old) && (t.Size == 0 || large)
+	}
+
+	return old || large
+}
+
+// collect returns the entries under t.Path matching t.Matches. Matching
+// directories are not walked into since they are removed as a whole.
+func (t *Tidy) collect() ([]tidyEntry, error) {
+	var entries []tidyEntry
+
+	err := filepath.Walk(t.Path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == t.Path {
+			return nil
+		}
+
+		rel, err := filepath.Rel(t.Path, p)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if t.MatchDirectories && matchAnyGlob(t.Matches, rel) {
+				entries = append(entries, tidyEntry{path: p, fi: fi})
+				return filepath.SkipDir
+			}
+
+			if !t.Recurse {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if matchAnyGlob(t.Matches, rel) {
+			entries = append(entries, tidyEntry{path: p, fi: fi})
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// ParseAge reads durations such as "30m", "12h", "7d" or "2w"
+func ParseAge(s string) (time.Duration, error) {
+	units := map[string]int64{
+		"s": int64(time.Second),
+		"m": int64(time.Minute),
+		"h": int64(time.Hour),
+		"d": int64(24 * time.Hour),
+		"w": int64(7 * 24 * time.Hour),
+	}
+
+	age, err := parseWithUnit(s, units)
+	return time.Duration(age), err
+}
+
+// ParseSize reads sizes such as "512", "10k", "100M" or "2G"
+func ParseSize(s string) (int64, error) {
+	units := map[string]int64{
+		"":  1,
+		"k": 1 << 10,
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+
+	return parseWithUnit(s, units)
+}
+
+func parseWithUnit(s string, units map[string]int64) (int64, error) {
+	numStr := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	unit, known := units[s[len(numStr):]]
+
+	if !known || numStr == "" {
+		return 0, fmt.Errorf("Invalid value %s", s)
+	}
+
+	n, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid value %s", s)
+	}
+
+	return n * unit, nil
+}
+
+func (t *Tidy) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	if p == "/" {
+		return haiconf.NewArgError("Path must not be /", args)
+	}
+
+	t.Path = p
+	return nil
+}
+
+func (t *Tidy) setMatches(args haiconf.CommandArgs) error {
+	_, present := args["Matches"]
+	if !present {
+		return nil
+	}
+
+	matches, err := checkGlobs("Matches", args)
+	if err != nil {
+		return err
+	}
+
+	t.Matches = matches
+	return nil
+}
+
+func (t *Tidy) setAge(args haiconf.CommandArgs) error {
+	_, present := args["Age"]
+	if !present {
+		return nil
+	}
+
+	s, err := haiconf.CheckString("Age", args)
+	if err != nil {
+		return err
+	}
+
+	t.Age, err = ParseAge(s)
+	if err != nil {
+		return haiconf.NewArgError(err.Error(), args)
+	}
+
+	return nil
+}
+
+// setCombine also makes sure entries are not all removed
+// because no criteria was given
+func (t *Tidy) setCombine(args haiconf.CommandArgs) error {
+	if t.Age == 0 && t.Size == 0 {
+		return haiconf.NewArgError("Age or Size must be provided", args)
+	}
+
+	_, present := args["Combine"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckStringChoice("Combine", args, []string{TIDY_COMBINE_ANY, TIDY_COMBINE_ALL})
+	if err != nil {
+		return err
+	}
+
+	t.Combine = m
+	return nil
+}
+
+func (t *Tidy) setSize(args haiconf.CommandArgs) error {
+	v, present := args["Size"]
+	if !present {
+		return nil
+	}
+
+	// lua numbers are bytes
+	f, isNumber := v.(float64)
+	if isNumber {
+		t.Size = int64(f)
+		return nil
+	}
+
+	s, err := haiconf.CheckString("Size", args)
+	if err != nil {
+		return err
+	}
+
+	t.Size, err = ParseSize(s)
+	if err != nil {
+		return haiconf.NewArgError(err.Error(), args)
+	}
+
+	return nil
+}
+
+func (t *Tidy) setRecurse(args haiconf.CommandArgs) error {
+	t.Recurse = haiconf.CheckBool("Recurse", args)
+	t.MatchDirectories = haiconf.CheckBool("MatchDirectories", args)
+
+	return nil
+}
+
+func (t *Tidy) setKeepNewest(args haiconf.CommandArgs) error {
+	v, present := args["KeepNewest"]
+	if !present {
+		return nil
+	}
+
+	n, ok := v.(float64)
+	if !ok || n < 0 {
+		return haiconf.NewArgError("KeepNewest must be a positive number", args)
+	}
+
+	t.KeepNewest = int(n)
+	return nil
+}
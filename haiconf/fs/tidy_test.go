@@ -0,0 +1,303 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"time"
+)
+
+type TidyTestSuite struct {
+	t *Tidy
+}
+
+var _ = Suite(&TidyTestSuite{})
+
+func (s *TidyTestSuite) SetUpTest(c *C) {
+	s.t = new(Tidy)
+	err := s.t.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+// createFiles creates files under root, each one being one day
+// older than the previous one
+func createFiles(c *C, root string, files []string) {
+	now := time.Now()
+
+	for i, f := range files {
+		err := os.MkdirAll(root+"/"+dirName(f), 0755)
+		c.Assert(err, IsNil)
+
+		err = ioutil.WriteFile(root+"/"+f, bytes.Repeat([]byte("x"), 1024*(i+1)), 0644)
+		c.Assert(err, IsNil)
+
+		mtime := now.Add(-time.Duration(i) * 24 * time.Hour)
+		err = os.Chtimes(root+"/"+f, mtime, mtime)
+		c.Assert(err, IsNil)
+	}
+}
+
+func dirName(f string) string {
+	for i := len(f) - 1; i >= 0; i-- {
+		if f[i] == '/' {
+			return f[:i]
+		}
+	}
+
+	return ""
+}
+
+func assertExist(c *C, root string, files []string, exist bool) {
+	for _, f := range files {
+		_, err := os.Stat(root + "/" + f)
+		c.Assert(err == nil, Equals, exist, Commentf(f))
+	}
+}
+
+func (s *TidyTestSuite) TestParseAge(c *C) {
+	age, err := ParseAge("2w")
+	c.Assert(err, IsNil)
+	c.Assert(age, Equals, 14*24*time.Hour)
+
+	age, err = ParseAge("30m")
+	c.Assert(err, IsNil)
+	c.Assert(age, Equals, 30*time.Minute)
+
+	for _, invalid := range []string{"", "d", "7y", "-1d", "1.5h"} {
+		_, err = ParseAge(invalid)
+		c.Assert(err, NotNil, Commentf(invalid))
+	}
+}
+
+func (s *TidyTestSuite) TestParseSize(c *C) {
+	size, err := ParseSize("100M")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(100*1024*1024))
+
+	size, err = ParseSize("512")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(512))
+
+	_, err = ParseSize("10X")
+	c.Assert(err, NotNil)
+}
+
+func (s *TidyTestSuite) TestSetUserConfig(c *C) {
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":       "/var/log/app",
+		"Matches":    []interface{}{"*.gz", "*.log.*"},
+		"Age":        "7d",
+		"Size":       "1M",
+		"Recurse":    true,
+		"KeepNewest": float64(3),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.t.Matches, DeepEquals, []string{"*.gz", "*.log.*"})
+	c.Assert(s.t.Age, Equals, 7*24*time.Hour)
+	c.Assert(s.t.Size, Equals, int64(1024*1024))
+	c.Assert(s.t.Recurse, Equals, true)
+	c.Assert(s.t.KeepNewest, Equals, 3)
+}
+
+func (s *TidyTestSuite) TestSetUserConfig_NoCriteria(c *C) {
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":       "/var/log/app",
+		"KeepNewest": float64(3),
+	})
+	c.Assert(err, ErrorMatches, "Age or Size must be provided(.*)")
+}
+
+func (s *TidyTestSuite) TestSetUserConfig_InvalidCombine(c *C) {
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":    "/var/log/app",
+		"Age":     "1d",
+		"Combine": "none",
+	})
+	c.Assert(err, NotNil)
+}
+
+func (s *TidyTestSuite) TestSetUserConfig_Root(c *C) {
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path": "/",
+		"Age":  "1d",
+	})
+	c.Assert(err, ErrorMatches, "Path must not be /(.*)")
+}
+
+func (s *TidyTestSuite) TestRun_Age(c *C) {
+	root := c.MkDir()
+	files := []string{"app.log", "app.log.1", "app.log.2", "app.log.3", "sub/app.log.4"}
+	createFiles(c, root, files)
+
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":    root,
+		"Matches": "*.log.*",
+		"Age":     "36h",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, []string{"app.log", "app.log.1", "sub/app.log.4"}, true)
+	assertExist(c, root, []string{"app.log.2", "app.log.3"}, false)
+}
+
+func (s *TidyTestSuite) TestRun_RecurseAndKeepNewest(c *C) {
+	root := c.MkDir()
+	files := []string{"a.gz", "sub/b.gz", "sub/c.gz", "sub/deep/d.gz", "e.txt"}
+	createFiles(c, root, files)
+
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":       root,
+		"Matches":    "*.gz",
+		"Age":        "1h",
+		"Recurse":    true,
+		"KeepNewest": float64(2),
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, []string{"a.gz", "sub/b.gz", "e.txt"}, true)
+	assertExist(c, root, []string{"sub/c.gz", "sub/deep/d.gz"}, false)
+}
+
+func (s *TidyTestSuite) TestRun_Size(c *C) {
+	root := c.MkDir()
+	files := []string{"small", "medium", "large"}
+	createFiles(c, root, files)
+
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path": root,
+		"Size": "2k",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, []string{"small"}, true)
+	assertExist(c, root, []string{"medium", "large"}, false)
+}
+
+func (s *TidyTestSuite) TestRun_AgeOrSize(c *C) {
+	root := c.MkDir()
+
+	// 1k of today, 2k of yesterday, 3k of 2 days ago
+	files := []string{"new-small", "old-medium", "older-large"}
+	createFiles(c, root, files)
+
+	err := os.Truncate(root+"/new-small", 4096)
+	c.Assert(err, IsNil)
+
+	err = s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path": root,
+		"Age":  "36h",
+		"Size": "4k",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.t.Combine, Equals, TIDY_COMBINE_ANY)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, []string{"old-medium"}, true)
+	assertExist(c, root, []string{"new-small", "older-large"}, false)
+}
+
+func (s *TidyTestSuite) TestRun_AgeAndSize(c *C) {
+	root := c.MkDir()
+	files := []string{"new-small", "old-medium", "older-large"}
+	createFiles(c, root, files)
+
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":    root,
+		"Age":     "12h",
+		"Size":    "3k",
+		"Combine": TIDY_COMBINE_ALL,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, []string{"new-small", "old-medium"}, true)
+	assertExist(c, root, []string{"older-large"}, false)
+}
+
+func (s *TidyTestSuite) TestRun_MatchDirectories(c *C) {
+	root := c.MkDir()
+	files := []string{"release-1/app", "release-2/app", "current.txt"}
+	createFiles(c, root, files)
+
+	old := time.Now().Add(-48 * time.Hour)
+	err := os.Chtimes(root+"/release-2", old, old)
+	c.Assert(err, IsNil)
+
+	err = s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":             root,
+		"Matches":          "release-*",
+		"Age":              "1h",
+		"MatchDirectories": true,
+		"KeepNewest":       float64(1),
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, []string{"current.txt", "release-1/app"}, true)
+	assertExist(c, root, []string{"release-2"}, false)
+}
+
+func (s *TidyTestSuite) TestRun_MatchDirectoriesIgnoresSize(c *C) {
+	root := c.MkDir()
+	files := []string{"release-1/app", "release-2/app"}
+	createFiles(c, root, files)
+
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":             root,
+		"Matches":          "release-*",
+		"Size":             "1",
+		"MatchDirectories": true,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, files, true)
+}
+
+func (s *TidyTestSuite) TestRun_DryRun(c *C) {
+	root := c.MkDir()
+	files := []string{"a.log", "b.log"}
+	createFiles(c, root, files)
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+	s.t.SetDefault(&rc)
+
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path": root,
+		"Size": "1k",
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	assertExist(c, root, files, true)
+	c.Assert(output.String(), Matches, "(?s).*Removed 2 entries under .*")
+}
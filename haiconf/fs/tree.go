@@ -0,0 +1,373 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Usage in lua configuration file
+//
+// Tree({
+//     Path    = "/etc/nginx",
+//     Source  = "/absolute/path/to/templates/etc/nginx",
+//     Mode    = "0644",
+//     DirMode = "0755",
+//
+//     -- optional, ownership is left unchanged when missing.
+//     -- Numeric ids are accepted.
+//     Owner   = "root",
+//     Group   = "root",
+//
+//     -- files ending with .tpl are rendered, the .tpl suffix is
+//     -- removed from the destination file name
+//     TemplateVariables = {
+//         Workers = 4,
+//     },
+//
+//     -- globs are matched against the path relative to Source
+//     -- or the file name, the last matching rule wins
+//     Rules = {
+//         {Glob = "*.key", Mode = "0600", Group = "ssl-cert"},
+//         {Glob = "sites-available/*", Owner = "www-data"},
+//     },
+// })
+//
+// TemplateVariablesFromFile, TemplatesDir and StrictTemplate are also
+// supported, see File.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/hacks"
+	"github.com/jeromer/haiconf/haiconf"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	TEMPLATE_SUFFIX = ".tpl"
+)
+
+type Tree struct {
+	Path    string
+	Source  string
+	Mode    os.FileMode
+	DirMode os.FileMode
+	Owner   *user.User
+	Group   *hacks.Group
+	Rules   []TreeRule
+
+	TemplateVariables map[string]interface{}
+	TemplatesDir      string
+	StrictTemplate    bool
+
+	rc *haiconf.RuntimeConfig
+}
+
+// TreeRule overrides the mode, owner or group of the files matching
+// Glob. Zero values mean the Tree values are used.
+type TreeRule struct {
+	Glob  string
+	Mode  os.FileMode
+	Owner *user.User
+	Group *hacks.Group
+}
+
+func (t *Tree) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*t = Tree{
+		Path:    "",
+		Source:  "",
+		Mode:    DEFAULT_MODE_FILE,
+		DirMode: DEFAULT_MODE_DIRECTORY,
+		Owner:   nil,
+		Group:   nil,
+		Rules:   []TreeRule{},
+		rc:      rc,
+	}
+
+	return nil
+}
+
+func (t *Tree) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		t.setPath,
+		t.setSource,
+		t.setMode,
+		t.setDirMode,
+		t.setOwner,
+		t.setGroup,
+		t.setRules,
+		t.setTemplateVariables,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Tree) Run() error {
+	haiconf.Output(t.rc, "Deploying tree %s to %s", t.Source, t.Path)
+
+	return filepath.Walk(t.Source, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(t.Source, src)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return t.runDirectory(filepath.Join(t.Path, rel))
+		}
+
+		return t.runFile(src, rel)
+	})
+}
+
+func (t *Tree) runDirectory(p string) error {
+	d := Directory{
+		Path:    p,
+		Mode:    t.DirMode,
+		Owner:   t.Owner,
+		Group:   t.Group,
+		Recurse: true,
+		Ensure:  haiconf.ENSURE_PRESENT,
+		rc:      t.rc,
+	}
+
+	return d.Run()
+}
+
+func (t *Tree) runFile(src string, rel string) error {
+	f := File{
+		Path:    filepath.Join(t.Path, rel),
+		Mode:    t.Mode,
+		Ensure:  haiconf.ENSURE_PRESENT,
+		Owner:   t.Owner,
+		Group:   t.Group,
+		Source:  src,
+		Sources: []string{src},
+		rc:      t.rc,
+	}
+
+	if strings.HasSuffix(rel, TEMPLATE_SUFFIX) {
+		f.Path = strings.TrimSuffix(f.Path, TEMPLATE_SUFFIX)
+		f.TemplatesDir = t.TemplatesDir
+		f.StrictTemplate = t.StrictTemplate
+
+		// templates are rendered even without variables
+		f.TemplateVariables = t.TemplateVariables
+		if f.TemplateVariables == nil {
+			f.TemplateVariables = map[string]interface{}{}
+		}
+	}
+
+	for _, r := range t.Rules {
+		if !r.Match(rel) {
+			continue
+		}
+
+		if r.Mode != 0 {
+			f.Mode = r.Mode
+		}
+
+		if r.Owner != nil {
+			f.Owner = r.Owner
+		}
+
+		if r.Group != nil {
+			f.Group = r.Group
+		}
+	}
+
+	return f.Run()
+}
+
+// Match reports whether rel, a path relative to the tree source,
+// or its base name matches the rule glob.
+func (r *TreeRule) Match(rel string) bool {
+	return MatchGlob(r.Glob, rel)
+}
+
+func (t *Tree) setPath(args haiconf.CommandArgs) error {
+	p, err := haiconf.CheckAbsolutePath("Path", args)
+	if err != nil {
+		return err
+	}
+
+	t.Path = p
+	return nil
+}
+
+func (t *Tree) setSource(args haiconf.CommandArgs) error {
+	src, err := haiconf.CheckAbsolutePath("Source", args)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return haiconf.NewArgError(err.Error(), args)
+	}
+
+	if !fi.IsDir() {
+		return haiconf.NewArgError(src+" is not a directory", args)
+	}
+
+	t.Source = src
+	return nil
+}
+
+func (t *Tree) setMode(args haiconf.CommandArgs) error {
+	m, err := haiconf.CheckFileMode("Mode", args)
+	if err != nil {
+		return err
+	}
+
+	t.Mode = m
+	return nil
+}
+
+func (t *Tree) setDirMode(args haiconf.CommandArgs) error {
+	_, present := args["DirMode"]
+	if !present {
+		return nil
+	}
+
+	m, err := haiconf.CheckFileMode("DirMode", args)
+	if err != nil {
+		return err
+	}
+
+	t.DirMode = m
+	return nil
+}
+
+func (t *Tree) setOwner(args haiconf.CommandArgs) error {
+	_, present := args["Owner"]
+	if !present {
+		return nil
+	}
+
+	u, err := haiconf.CheckSystemUser("Owner", args)
+	if err != nil {
+		return err
+	}
+
+	t.Owner = u
+	return nil
+}
+
+func (t *Tree) setGroup(args haiconf.CommandArgs) error {
+	_, present := args["Group"]
+	if !present {
+		return nil
+	}
+
+	grp, err := haiconf.CheckSystemGroup("Group", args)
+	if err != nil {
+		return err
+	}
+
+	t.Group = grp
+	return nil
+}
+
+func (t *Tree) setRules(args haiconf.CommandArgs) error {
+	_, present := args["Rules"]
+	if !present {
+		return nil
+	}
+
+	rules, ok := args["Rules"].([]interface{})
+	if !ok {
+		return haiconf.NewArgError("Rules must be a list", args)
+	}
+
+	for _, r := range rules {
+		ruleArgs, ok := r.(map[string]interface{})
+		if !ok {
+			return haiconf.NewArgError("Each rule must be a table", args)
+		}
+
+		rule, err := checkTreeRule(haiconf.CommandArgs(ruleArgs))
+		if err != nil {
+			return err
+		}
+
+		t.Rules = append(t.Rules, rule)
+	}
+
+	return nil
+}
+
+func checkTreeRule(args haiconf.CommandArgs) (TreeRule, error) {
+	var rule TreeRule
+
+	g, err := haiconf.CheckString("Glob", args)
+	if err != nil {
+		return rule, err
+	}
+
+	_, err = filepath.Match(g, "")
+	if err != nil {
+		return rule, haiconf.NewArgError("Invalid Glob : "+err.Error(), args)
+	}
+
+	rule.Glob = g
+
+	_, present := args["Mode"]
+	if present {
+		m, err := haiconf.CheckFileMode("Mode", args)
+		if err != nil {
+			return rule, err
+		}
+
+		rule.Mode = m
+	}
+
+	_, present = args["Owner"]
+	if present {
+		rule.Owner, err = haiconf.CheckSystemUser("Owner", args)
+		if err != nil {
+			return rule, err
+		}
+	}
+
+	_, present = args["Group"]
+	if present {
+		rule.Group, err = haiconf.CheckSystemGroup("Group", args)
+		if err != nil {
+			return rule, err
+		}
+	}
+
+	return rule, nil
+}
+
+func (t *Tree) setTemplateVariables(args haiconf.CommandArgs) error {
+	tv, err := checkTemplateVariables(args)
+	if err != nil {
+		return err
+	}
+
+	t.TemplateVariables = tv
+
+	t.TemplatesDir, err = checkTemplatesDir(args)
+	if err != nil {
+		return err
+	}
+
+	t.StrictTemplate = haiconf.CheckBool("StrictTemplate", args)
+
+	return nil
+}
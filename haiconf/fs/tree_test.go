@@ -0,0 +1,154 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"syscall"
+)
+
+type TreeTestSuite struct {
+	t *Tree
+}
+
+var _ = Suite(&TreeTestSuite{})
+
+func (s *TreeTestSuite) SetUpTest(c *C) {
+	s.t = new(Tree)
+	err := s.t.SetDefault(&dummyRuntimeConfig)
+	c.Assert(err, IsNil)
+}
+
+func (s *TreeTestSuite) TestSetUserConfig_SourceNotADirectory(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	err = s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":   "/tmp/foo",
+		"Source": cwd + "/testdata/nontemplate.txt",
+	})
+	c.Assert(err, ErrorMatches, ".* is not a directory. (.*)")
+}
+
+func (s *TreeTestSuite) TestSetUserConfig_Rules(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	err = s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":    "/tmp/foo",
+		"Source":  cwd + "/testdata/tree",
+		"Mode":    "0644",
+		"DirMode": "0750",
+		"Owner":   currentUser.Username,
+		"Group":   dummyGroup,
+		"Rules": []interface{}{
+			map[string]interface{}{"Glob": "*.key", "Mode": "0600"},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.t.DirMode, Equals, os.FileMode(0750))
+	c.Assert(len(s.t.Rules), Equals, 1)
+	c.Assert(s.t.Rules[0].Glob, Equals, "*.key")
+	c.Assert(s.t.Rules[0].Mode, Equals, os.FileMode(0600))
+	c.Assert(s.t.Rules[0].Owner, IsNil)
+}
+
+func (s *TreeTestSuite) TestRun(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	dest := c.MkDir() + "/nginx"
+
+	err = s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":    dest,
+		"Source":  cwd + "/testdata/tree",
+		"Mode":    "0644",
+		"DirMode": "0750",
+		"Owner":   currentUser.Username,
+		"Group":   dummyGroup,
+		"TemplateVariables": map[string]interface{}{
+			"Workers": 4,
+		},
+		"Rules": []interface{}{
+			map[string]interface{}{"Glob": "*.key", "Mode": "0600"},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	fi, err := os.Stat(dest + "/conf.d")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0750))
+
+	buff, err := ioutil.ReadFile(dest + "/plain.conf")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "plain file\n")
+
+	buff, err = ioutil.ReadFile(dest + "/conf.d/workers.conf")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "workers 4;\n")
+
+	_, err = os.Stat(dest + "/conf.d/workers.conf.tpl")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	fi, err = os.Stat(dest + "/conf.d/server.key")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *TreeTestSuite) TestRun_OptionalOwnership(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("changing the owner of a file requires root")
+	}
+
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	dest := c.MkDir() + "/nginx"
+	err = os.MkdirAll(dest, 0755)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(dest+"/plain.conf", []byte("old\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = os.Chown(dest+"/plain.conf", 65534, 65534)
+	c.Assert(err, IsNil)
+
+	err = s.t.SetUserConfig(haiconf.CommandArgs{
+		"Path":   dest,
+		"Source": cwd + "/testdata/tree",
+		"Mode":   "0644",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.t.Owner, IsNil)
+	c.Assert(s.t.Group, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(dest + "/plain.conf")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "plain file\n")
+
+	fi, err := os.Stat(dest + "/plain.conf")
+	c.Assert(err, IsNil)
+	st := fi.Sys().(*syscall.Stat_t)
+	c.Assert(st.Uid, Equals, uint32(65534))
+	c.Assert(st.Gid, Equals, uint32(65534))
+}
+
+func (s *TreeTestSuite) TestRuleMatch(c *C) {
+	r := TreeRule{Glob: "conf.d/*.conf"}
+	c.Assert(r.Match("conf.d/foo.conf"), Equals, true)
+	c.Assert(r.Match("foo.conf"), Equals, false)
+
+	r = TreeRule{Glob: "*.key"}
+	c.Assert(r.Match("conf.d/server.key"), Equals, true)
+}
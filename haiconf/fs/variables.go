@@ -0,0 +1,186 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/jeromer/haiconf/haiconf"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkTemplateVariables reads TemplateVariablesFromFile and
+// TemplateVariables, the latter having precedence.
+func checkTemplateVariables(args haiconf.CommandArgs) (map[string]interface{}, error) {
+	fromFiles, err := checkTemplateVariablesFromFile(args)
+	if err != nil {
+		return nil, err
+	}
+
+	tv, _ := args["TemplateVariables"].(map[string]interface{})
+	l := len(tv)
+
+	if l <= 0 {
+		return fromFiles, nil
+	}
+
+	tmp := make(map[string]interface{}, l)
+
+	for k, v := range tv {
+		switch v.(type) {
+		case string:
+			if v == "true" || v == "false" {
+				b, err := strconv.ParseBool(v.(string))
+				if err != nil {
+					return nil, err
+				}
+
+				tmp[k] = b
+				continue
+			}
+
+			tmp[k] = v
+		default:
+			tmp[k] = v
+		}
+	}
+
+	if fromFiles != nil {
+		tmp = MergeVariables(fromFiles, tmp)
+	}
+
+	return tmp, nil
+}
+
+func checkTemplateVariablesFromFile(args haiconf.CommandArgs) (map[string]interface{}, error) {
+	_, present := args["TemplateVariablesFromFile"]
+	if !present {
+		return nil, nil
+	}
+
+	paths, err := haiconf.CheckStringList("TemplateVariablesFromFile", args)
+	if err != nil {
+		p, err := haiconf.CheckString("TemplateVariablesFromFile", args)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = []string{p}
+	}
+
+	for _, p := range paths {
+		if !path.IsAbs(p) {
+			return nil, haiconf.NewArgError("TemplateVariablesFromFile must be absolute", args)
+		}
+	}
+
+	vars, err := LoadVariablesFiles(paths)
+	if err != nil {
+		return nil, haiconf.NewArgError(err.Error(), args)
+	}
+
+	return vars, nil
+}
+
+func checkTemplatesDir(args haiconf.CommandArgs) (string, error) {
+	_, present := args["TemplatesDir"]
+	if !present {
+		return "", nil
+	}
+
+	return haiconf.CheckAbsolutePath("TemplatesDir", args)
+}
+
+// LoadVariablesFiles reads template variables from JSON, YAML or TOML
+// files. The format is guessed from the file extension. Files are
+// merged in order, values from later files win.
+func LoadVariablesFiles(paths []string) (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+
+	for _, p := range paths {
+		v, err := loadVariablesFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		vars = MergeVariables(vars, v)
+	}
+
+	return vars, nil
+}
+
+// MergeVariables merges src into dst. Nested maps are merged
+// recursively, any other value in src replaces the one in dst.
+func MergeVariables(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			dst[k] = MergeVariables(dstMap, srcMap)
+			continue
+		}
+
+		dst[k] = v
+	}
+
+	return dst
+}
+
+func loadVariablesFile(p string) (map[string]interface{}, error) {
+	buff, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".json":
+		err = json.Unmarshal(buff, &vars)
+	case ".yaml", ".yml":
+		var raw map[interface{}]interface{}
+		err = yaml.Unmarshal(buff, &raw)
+		if err == nil {
+			vars = normalizeYAML(raw).(map[string]interface{})
+		}
+	case ".toml":
+		_, err = toml.Decode(string(buff), &vars)
+	default:
+		return nil, fmt.Errorf("Unsupported variables file %s, use .json, .yaml, .yml or .toml", p)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load variables from %s : %s", p, err)
+	}
+
+	return vars, nil
+}
+
+// The YAML decoder produces map[interface{}]interface{} which can not be
+// used with templates or merged with other variables
+func normalizeYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = normalizeYAML(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range t {
+			t[i] = normalizeYAML(val)
+		}
+		return t
+	}
+
+	return v
+}
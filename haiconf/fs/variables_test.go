@@ -0,0 +1,65 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fs
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type VariablesTestSuite struct{}
+
+var _ = Suite(&VariablesTestSuite{})
+
+func (s *VariablesTestSuite) TestLoadVariablesFiles(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	vars, err := LoadVariablesFiles([]string{
+		cwd + "/testdata/variables/defaults.yaml",
+		cwd + "/testdata/variables/generated.json",
+		cwd + "/testdata/variables/extra.toml",
+	})
+	c.Assert(err, IsNil)
+
+	expected := map[string]interface{}{
+		"Database": map[string]interface{}{
+			"Host":     "db.example.com",
+			"Port":     5432,
+			"Replicas": []interface{}{"db1", "db2"},
+			"User":     "app",
+		},
+		"Debug":   false,
+		"Workers": float64(4),
+		"Name":    "app",
+	}
+	c.Assert(vars, DeepEquals, expected)
+}
+
+func (s *VariablesTestSuite) TestLoadVariablesFiles_UnsupportedFormat(c *C) {
+	cwd, err := os.Getwd()
+	c.Assert(err, IsNil)
+
+	_, err = LoadVariablesFiles([]string{cwd + "/testdata/nontemplate.txt"})
+	c.Assert(err, ErrorMatches, "Unsupported variables file .*")
+}
+
+func (s *VariablesTestSuite) TestMergeVariables(c *C) {
+	dst := map[string]interface{}{
+		"A": "a",
+		"M": map[string]interface{}{"X": 1, "Y": 2},
+	}
+
+	src := map[string]interface{}{
+		"A": "b",
+		"M": map[string]interface{}{"Y": 3},
+	}
+
+	expected := map[string]interface{}{
+		"A": "b",
+		"M": map[string]interface{}{"X": 1, "Y": 3},
+	}
+	c.Assert(MergeVariables(dst, src), DeepEquals, expected)
+}
@@ -12,7 +12,13 @@ import (
 type CommandArgs map[string]interface{}
 type RuntimeConfig struct {
 	Verbose bool
+	DryRun  bool
 	Output  io.Writer
+
+	// Where previous contents are stored before being overwritten
+	// or deleted. Backups are disabled when empty.
+	BackupDir string
+	RunId     string
 }
 
 type Commander interface {
@@ -34,8 +40,11 @@ func (err *HaiconfError) Error() string {
 	return fmt.Sprintf("%s. Received args : %+v", err.Msg, err.Args)
 }
 
+// Output writes a message when running in verbose or dry-run mode.
+// In dry-run mode nothing is changed on the system so messages
+// are the only way to know what would have happened.
 func Output(rc *RuntimeConfig, msgFmt string, msgArgs ...interface{}) {
-	if !rc.Verbose {
+	if !rc.Verbose && !rc.DryRun {
 		return
 	}
 
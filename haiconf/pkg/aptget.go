@@ -9,8 +9,8 @@ package pkg
 // AptGet({
 //         Method = "install",
 //
-//         -- defined here:
-//         Packages = {"vim", "mutt", "cowsay"},
+//         -- defined here, a version can be pinned for install:
+//         Packages = {"vim", "mutt", "nginx=1.18.0-6"},
 //
 //         -- or alternatively:
 //         PackagesFromSource = "/path/to/packages.to.install.txt",
@@ -20,8 +20,26 @@ package pkg
 //             "--download-only",
 //             "--simulate",
 //             "--fix-broken",
-//         }
+//         },
+//
+//         -- optional
+//         DpkgStatus = "/var/lib/dpkg/status",
 //     })
+//
+// apt-get is only called for packages which are not installed yet, or
+// not removed yet. Pinned packages are also installed when another
+// version is installed, downgrades included.
+//
+// Methods hold and unhold use apt-mark so held packages are neither
+// upgraded nor removed, versions are ignored by these methods and by
+// remove.
+//
+// Installed packages are available in lua with
+// InstalledPackages() which returns versions by package name:
+//
+//     if InstalledPackages()["nginx"] then
+//         ...
+//     end
 
 import (
 	"github.com/jeromer/haiconf/haiconf"
@@ -29,15 +47,19 @@ import (
 	"github.com/jeromer/haiconf/haiconf/stringutils"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 )
 
 const (
-	APT_GET = "/usr/bin/apt-get"
+	APT_GET  = "/usr/bin/apt-get"
+	APT_MARK = "/usr/bin/apt-mark"
 
 	METHOD_INSTALL = "install"
 	METHOD_UPDATE  = "update"
 	METHOD_REMOVE  = "remove"
+	METHOD_HOLD    = "hold"
+	METHOD_UNHOLD  = "unhold"
 )
 
 var (
@@ -45,6 +67,8 @@ var (
 		METHOD_INSTALL,
 		METHOD_UPDATE,
 		METHOD_REMOVE,
+		METHOD_HOLD,
+		METHOD_UNHOLD,
 	}
 
 	envVariables = map[string]string{
@@ -62,18 +86,23 @@ var (
 		"--yes",
 		"--quiet",
 	}
+
+	aptGetPath  = APT_GET
+	aptMarkPath = APT_MARK
 )
 
 type AptGet struct {
 	Method       string
 	Packages     []string
 	ExtraOptions []string
+	DpkgStatus   string
 	shellCmd     string
 
 	rc *haiconf.RuntimeConfig
 }
 
 func (ag *AptGet) SetDefault(rc *haiconf.RuntimeConfig) error {
+	ag.DpkgStatus = DPKG_STATUS
 	ag.rc = rc
 	return nil
 }
@@ -93,11 +122,21 @@ func (ag *AptGet) SetUserConfig(args haiconf.CommandArgs) error {
 		return err
 	}
 
+	err = ag.checkPins(args)
+	if err != nil {
+		return err
+	}
+
 	err = ag.setExtraOptions(args)
 	if err != nil {
 		return err
 	}
 
+	err = ag.setDpkgStatus(args)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -112,13 +151,171 @@ func (ag *AptGet) Run() error {
 	// http://golang.org/doc/faq#Do_Go_programs_link_with_Cpp_programs
 	// http://www.swig.org/Doc2.0/Go.html
 
+	if ag.Method == METHOD_UPDATE {
+		haiconf.Output(ag.rc, "Updating package lists")
+		if ag.rc.DryRun {
+			return nil
+		}
+
+		return ag.aptGet(nil)
+	}
+
+	before, err := ReadDpkgStatus(ag.DpkgStatus)
+	if err != nil {
+		return err
+	}
+
+	pending, downgrade := ag.pending(before)
+	if len(pending) == 0 {
+		haiconf.Output(ag.rc, "Nothing to %s, packages are up to date", ag.Method)
+		return nil
+	}
+
+	tool := "apt-get"
+	if ag.Method == METHOD_HOLD || ag.Method == METHOD_UNHOLD {
+		tool = "apt-mark"
+	}
+
+	haiconf.Output(ag.rc, "Running %s %s %s", tool, ag.Method, strings.Join(pending, " "))
+	if ag.rc.DryRun {
+		return nil
+	}
+
+	if tool == "apt-mark" {
+		err = ag.aptMark(pending)
+	} else if downgrade {
+		err = ag.aptGet(pending, "--allow-downgrades")
+	} else {
+		err = ag.aptGet(pending)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	after, err := ReadDpkgStatus(ag.DpkgStatus)
+	if err != nil {
+		return err
+	}
+
+	ag.report(before, after)
+
+	return nil
+}
+
+// pending returns the packages which are not in the state expected
+// by Method and whether installing them requires a downgrade
+func (ag *AptGet) pending(status map[string]DpkgPackage) ([]string, bool) {
+	pending := []string{}
+	downgrade := false
+
+	for _, pkg := range ag.Packages {
+		name, pin := splitPin(pkg)
+		p, found := status[name]
+		isInstalled := found && p.IsInstalled()
+
+		switch ag.Method {
+		case METHOD_INSTALL:
+			if !isInstalled {
+				pending = append(pending, pkg)
+				continue
+			}
+
+			if pin == "" || CompareVersions(p.Version, pin) == 0 {
+				continue
+			}
+
+			if CompareVersions(p.Version, pin) > 0 {
+				haiconf.Output(ag.rc, "Downgrading %s from %s to %s", name, p.Version, pin)
+				downgrade = true
+			}
+
+			pending = append(pending, pkg)
+
+		case METHOD_REMOVE:
+			if isInstalled {
+				pending = append(pending, name)
+			}
+
+		case METHOD_HOLD:
+			if !found || !p.IsHeld() {
+				pending = append(pending, name)
+			}
+
+		case METHOD_UNHOLD:
+			if found && p.IsHeld() {
+				pending = append(pending, name)
+			}
+		}
+	}
+
+	return pending, downgrade
+}
+
+// splitPin splits name=version
+func splitPin(pkg string) (string, string) {
+	idx := strings.Index(pkg, "=")
+	if idx < 0 {
+		return pkg, ""
+	}
+
+	return pkg[:idx], pkg[idx+1:]
+}
+
+// report shows every package installed, upgraded or removed by
+// apt-get, dependencies included
+func (ag *AptGet) report(before map[string]DpkgPackage, after map[string]DpkgPackage) {
+	names := make([]string, 0, len(after))
+
+	for name := range before {
+		names = append(names, name)
+	}
+
+	for name := range after {
+		_, found := before[name]
+		if !found {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		// name:arch entries duplicate name entries
+		if strings.Contains(name, ":") {
+			continue
+		}
+
+		b := before[name]
+		a := after[name]
+
+		switch {
+		case !b.IsInstalled() && a.IsInstalled():
+			haiconf.Output(ag.rc, "Installed %s %s", name, a.Version)
+		case b.IsInstalled() && !a.IsInstalled():
+			haiconf.Output(ag.rc, "Removed %s %s", name, b.Version)
+		case a.IsInstalled() && a.Version != b.Version:
+			haiconf.Output(ag.rc, "Changed %s from %s to %s", name, b.Version, a.Version)
+		}
+
+		switch {
+		case !b.IsHeld() && a.IsHeld():
+			haiconf.Output(ag.rc, "Held %s", name)
+		case b.IsHeld() && !a.IsHeld():
+			haiconf.Output(ag.rc, "Released hold on %s", name)
+		}
+	}
+}
+
+func (ag *AptGet) aptGet(packages []string, options ...string) error {
 	// XXX : crap
 	args := append(defaultOptions, ag.Method)
+	args = stringutils.RemoveDuplicates(append(args, options...))
 	args = stringutils.RemoveDuplicates(append(args, ag.ExtraOptions...))
-	args = stringutils.RemoveDuplicates(append(args, ag.Packages...))
+	args = stringutils.RemoveDuplicates(append(args, packages...))
 
 	sc := osutils.SystemCommand{
-		Path:                 APT_GET,
+		Path:                 aptGetPath,
 		Args:                 args,
 		EnvVars:              envVariables,
 		ExecDir:              os.TempDir(),
@@ -133,6 +330,23 @@ func (ag *AptGet) Run() error {
 	return nil
 }
 
+func (ag *AptGet) aptMark(packages []string) error {
+	sc := osutils.SystemCommand{
+		Path:                 aptMarkPath,
+		Args:                 append([]string{ag.Method}, packages...),
+		EnvVars:              envVariables,
+		ExecDir:              os.TempDir(),
+		EnableShellExpansion: true,
+	}
+
+	output := sc.Run()
+	if output.HasError() {
+		return output
+	}
+
+	return nil
+}
+
 func (ag *AptGet) setMethod(args haiconf.CommandArgs) error {
 	m, err := haiconf.CheckStringChoice("Method", args, availableMethods)
 
@@ -177,6 +391,27 @@ func (ag *AptGet) setPackages(args haiconf.CommandArgs) error {
 	return haiconf.NewArgError(msg, args)
 }
 
+func (ag *AptGet) checkPins(args haiconf.CommandArgs) error {
+	for _, pkg := range ag.Packages {
+		name, pin := splitPin(pkg)
+
+		if name == "" {
+			return haiconf.NewArgError("Invalid package "+pkg, args)
+		}
+
+		if pin == "" && !strings.Contains(pkg, "=") {
+			continue
+		}
+
+		_, err := ParseVersion(pin)
+		if err != nil {
+			return haiconf.NewArgError(err.Error()+" for "+name, args)
+		}
+	}
+
+	return nil
+}
+
 func (ag *AptGet) setExtraOptions(args haiconf.CommandArgs) error {
 	xtraOpts, _ := haiconf.CheckStringList("ExtraOptions", args)
 	l := len(xtraOpts)
@@ -187,3 +422,18 @@ func (ag *AptGet) setExtraOptions(args haiconf.CommandArgs) error {
 
 	return nil
 }
+
+func (ag *AptGet) setDpkgStatus(args haiconf.CommandArgs) error {
+	_, present := args["DpkgStatus"]
+	if !present {
+		return nil
+	}
+
+	p, err := haiconf.CheckAbsolutePath("DpkgStatus", args)
+	if err != nil {
+		return err
+	}
+
+	ag.DpkgStatus = p
+	return nil
+}
@@ -5,7 +5,9 @@
 package pkg
 
 import (
+	"bytes"
 	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
 	"testing"
@@ -33,9 +35,15 @@ func (s *AptGetTestSuite) SetUpTest(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *AptGetTestSuite) TearDownTest(c *C) {
+	aptGetPath = APT_GET
+	aptMarkPath = APT_MARK
+}
+
 func (s *AptGetTestSuite) TestSetDefault(c *C) {
 	// XXX : s.d.SetDefault() called in Setuptest
 	c.Assert(s.ag.rc, Equals, &dummyRuntimeConfig)
+	c.Assert(s.ag.DpkgStatus, Equals, DPKG_STATUS)
 }
 
 func (s *AptGetTestSuite) TestSetPackages_NotFromList(c *C) {
@@ -165,3 +173,242 @@ func (s *AptGetTestSuite) TestRun_Failed(c *C) {
 	err = s.ag.Run()
 	c.Assert(err, NotNil)
 }
+
+// fakeAptGet replaces apt-get and apt-mark with a script which logs its arguments
+// and installs after as the new dpkg status database
+func fakeAptGet(c *C, after string) (string, string) {
+	dir := c.MkDir()
+	status := dir + "/status"
+
+	buff, err := ioutil.ReadFile("testdata/dpkg-status")
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(status, buff, 0644)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(dir+"/after", []byte(after), 0644)
+	c.Assert(err, IsNil)
+
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncp " + dir + "/after " + status + "\n"
+	err = ioutil.WriteFile(dir+"/apt-get", []byte(script), 0755)
+	c.Assert(err, IsNil)
+
+	aptGetPath = dir + "/apt-get"
+	aptMarkPath = dir + "/apt-get"
+
+	return dir, status
+}
+
+func (s *AptGetTestSuite) runAptGet(c *C, args haiconf.CommandArgs, after string) (string, string) {
+	dir, status := fakeAptGet(c, after)
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		Verbose: true,
+		Output:  output,
+	}
+	s.ag.SetDefault(&rc)
+
+	args["DpkgStatus"] = status
+
+	err := s.ag.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.ag.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(dir + "/args")
+	if os.IsNotExist(err) {
+		return output.String(), ""
+	}
+
+	c.Assert(err, IsNil)
+	return output.String(), string(buff)
+}
+
+func (s *AptGetTestSuite) TestRun_AlreadyInstalled(c *C) {
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_INSTALL,
+		"Packages": []interface{}{"vim", "libc6:i386", "tzdata"},
+	}, "")
+
+	c.Assert(aptArgs, Equals, "")
+	c.Assert(output, Equals, "Nothing to install, packages are up to date\n")
+}
+
+func (s *AptGetTestSuite) TestRun_Install(c *C) {
+	after := `Package: vim
+Status: install ok installed
+Architecture: amd64
+Version: 2:7.3.547-8
+
+Package: mutt
+Status: install ok installed
+Architecture: amd64
+Version: 1.5.21-6.2
+
+Package: nginx
+Status: install ok installed
+Architecture: amd64
+Version: 1.2.1-2.2
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 2013c-0wheezy1
+
+Package: libc6
+Status: hold ok installed
+Architecture: i386
+Version: 2.13-38
+`
+
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_INSTALL,
+		"Packages": []interface{}{"vim", "mutt", "nginx"},
+	}, after)
+
+	c.Assert(aptArgs, Equals, "--yes --quiet install mutt nginx\n")
+
+	expected := "Running apt-get install mutt nginx\n" +
+		"Installed mutt 1.5.21-6.2\n" +
+		"Installed nginx 1.2.1-2.2\n" +
+		"Changed vim from 2:7.3.547-7 to 2:7.3.547-8\n"
+	c.Assert(output, Equals, expected)
+}
+
+func (s *AptGetTestSuite) TestRun_Remove(c *C) {
+	after := `Package: libc6
+Status: hold ok installed
+Architecture: i386
+Version: 2.13-38
+`
+
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_REMOVE,
+		"Packages": []interface{}{"vim", "mutt", "tzdata"},
+	}, after)
+
+	c.Assert(aptArgs, Equals, "--yes --quiet remove vim tzdata\n")
+
+	expected := "Running apt-get remove vim tzdata\n" +
+		"Removed tzdata 2013c-0wheezy1\n" +
+		"Removed vim 2:7.3.547-7\n"
+	c.Assert(output, Equals, expected)
+}
+
+func (s *AptGetTestSuite) TestRun_DryRun(c *C) {
+	dir, status := fakeAptGet(c, "")
+
+	output := new(bytes.Buffer)
+	rc := haiconf.RuntimeConfig{
+		DryRun: true,
+		Output: output,
+	}
+	s.ag.SetDefault(&rc)
+
+	err := s.ag.SetUserConfig(haiconf.CommandArgs{
+		"Method":     METHOD_INSTALL,
+		"Packages":   []interface{}{"vim", "mutt"},
+		"DpkgStatus": status,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.ag.Run()
+	c.Assert(err, IsNil)
+
+	c.Assert(output.String(), Equals, "Running apt-get install mutt\n")
+
+	_, err = os.Stat(dir + "/args")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *AptGetTestSuite) TestSetUserConfig_Pins(c *C) {
+	err := s.ag.SetUserConfig(haiconf.CommandArgs{
+		"Method":   METHOD_INSTALL,
+		"Packages": []interface{}{"vim", "nginx=1.18.0-6", "libc6:i386=2.13-38"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.ag.Packages, DeepEquals, []string{"vim", "nginx=1.18.0-6", "libc6:i386=2.13-38"})
+
+	for _, pkg := range []string{"nginx=", "nginx=latest", "=1.0"} {
+		err = s.ag.SetUserConfig(haiconf.CommandArgs{
+			"Method":   METHOD_INSTALL,
+			"Packages": []interface{}{pkg},
+		})
+		c.Assert(err, NotNil, Commentf(pkg))
+	}
+}
+
+func (s *AptGetTestSuite) TestRun_PinUpToDate(c *C) {
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_INSTALL,
+		"Packages": []interface{}{"vim=2:7.3.547-7", "libc6:i386=2.13-38", "tzdata=2013c-0wheezy1"},
+	}, "")
+
+	c.Assert(aptArgs, Equals, "")
+	c.Assert(output, Equals, "Nothing to install, packages are up to date\n")
+}
+
+func (s *AptGetTestSuite) TestRun_PinUpgrade(c *C) {
+	after := `Package: vim
+Status: install ok installed
+Architecture: amd64
+Version: 2:7.4.052-1
+`
+
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_INSTALL,
+		"Packages": []interface{}{"vim=2:7.4.052-1", "tzdata"},
+	}, after)
+
+	c.Assert(aptArgs, Equals, "--yes --quiet install vim=2:7.4.052-1\n")
+	c.Assert(output, Matches, "Running apt-get install vim=2:7.4.052-1\n(?s).*Changed vim from 2:7.3.547-7 to 2:7.4.052-1\n")
+}
+
+func (s *AptGetTestSuite) TestRun_PinDowngrade(c *C) {
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_INSTALL,
+		"Packages": []interface{}{"vim=2:7.3.429-2"},
+	}, "")
+
+	c.Assert(aptArgs, Equals, "--yes --quiet install --allow-downgrades vim=2:7.3.429-2\n")
+	c.Assert(output, Matches, "Downgrading vim from 2:7.3.547-7 to 2:7.3.429-2\nRunning apt-get install vim=2:7.3.429-2\n(?s).*")
+}
+
+func (s *AptGetTestSuite) TestRun_Hold(c *C) {
+	after := `Package: vim
+Status: hold ok installed
+Architecture: amd64
+Version: 2:7.3.547-7
+
+Package: libc6
+Status: hold ok installed
+Architecture: i386
+Version: 2.13-38
+`
+
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_HOLD,
+		"Packages": []interface{}{"vim=2:7.3.547-7", "libc6"},
+	}, after)
+
+	c.Assert(aptArgs, Equals, "hold vim\n")
+	c.Assert(output, Matches, "Running apt-mark hold vim\n(?s).*Held vim\n(?s).*")
+}
+
+func (s *AptGetTestSuite) TestRun_Unhold(c *C) {
+	after := `Package: libc6
+Status: install ok installed
+Architecture: i386
+Version: 2.13-38
+`
+
+	output, aptArgs := s.runAptGet(c, haiconf.CommandArgs{
+		"Method":   METHOD_UNHOLD,
+		"Packages": []interface{}{"vim", "libc6"},
+	}, after)
+
+	c.Assert(aptArgs, Equals, "unhold libc6\n")
+	c.Assert(output, Matches, "Running apt-mark unhold libc6\n(?s).*Released hold on libc6\n(?s).*")
+}
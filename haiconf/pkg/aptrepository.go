@@ -0,0 +1,767 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+// Usage in lua configuration file
+//
+// AptRepository({
+//         Name       = "postgresql",
+//         Uris       = {"https://apt.postgresql.org/pub/repos/apt"},
+//         Suites     = {"wheezy-pgdg"},
+//         Components = {"main"},
+//         Ensure     = "present",
+//
+//         -- optional, {"deb"} by default
+//         Types         = {"deb", "deb-src"},
+//         Architectures = {"amd64"},
+//
+//         -- optional, "deb822" writes <Name>.sources, "list"
+//         -- writes a one-line style <Name>.list
+//         Format     = "deb822",
+//
+//         -- optional, a local path or an http(s) url. The key is
+//         -- installed in KeyringsDir once its fingerprint is checked.
+//         -- Urls are not downloaded again while the installed key
+//         -- holds KeyFingerprint
+//         Key            = "https://www.postgresql.org/media/keys/ACCC4CF8.asc",
+//         KeyFingerprint = "B97B 0AFC AA1A 47F0 44F2  44A0 7FCC 7D46 ACCC 4CF8",
+//
+//         -- optional, apt-get update is run when the repository
+//         -- or its key changed
+//         Update     = true,
+//
+//         -- optional
+//         SourcesDir  = "/etc/apt/sources.list.d",
+//         KeyringsDir = "/etc/apt/keyrings",
+//     })
+//
+// Flat repositories are declared with a suite ending with a slash and
+// no Components. Checking fingerprints requires gpg.
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"github.com/jeromer/haiconf/haiconf/fs"
+	"github.com/jeromer/haiconf/haiconf/osutils"
+	"github.com/jeromer/haiconf/haiconf/utils/httpget"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	GPG = "/usr/bin/gpg"
+
+	FORMAT_DEB822 = "deb822"
+	FORMAT_LIST   = "list"
+
+	DEFAULT_SOURCES_DIR  = "/etc/apt/sources.list.d"
+	DEFAULT_KEYRINGS_DIR = "/etc/apt/keyrings"
+
+	armoredKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+)
+
+var (
+	gpgPath = GPG
+
+	repositoryNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	fingerprintRegexp    = regexp.MustCompile(`^[0-9A-F]{40}$`)
+)
+
+type AptRepository struct {
+	Name           string
+	Uris           []string
+	Suites         []string
+	Components     []string
+	Types          []string
+	Architectures  []string
+	Format         string
+	Key            string
+	KeyFingerprint string
+	Ensure         string
+	Update         bool
+	SourcesDir     string
+	KeyringsDir    string
+	keyring        string
+
+	rc *haiconf.RuntimeConfig
+}
+
+func (r *AptRepository) SetDefault(rc *haiconf.RuntimeConfig) error {
+	*r = AptRepository{
+		Name:           "",
+		Uris:           nil,
+		Suites:         nil,
+		Components:     nil,
+		Types:          []string{"deb"},
+		Architectures:  nil,
+		Format:         FORMAT_DEB822,
+		Key:            "",
+		KeyFingerprint: "",
+		Ensure:         haiconf.ENSURE_PRESENT,
+		Update:         true,
+		SourcesDir:     DEFAULT_SOURCES_DIR,
+		KeyringsDir:    DEFAULT_KEYRINGS_DIR,
+		rc:             rc,
+	}
+
+	return nil
+}
+
+func (r *AptRepository) SetUserConfig(args haiconf.CommandArgs) error {
+	var err error
+	type setter func(haiconf.CommandArgs) error
+
+	setters := []setter{
+		r.setName,
+		r.setEnsure,
+		r.setFormat,
+		r.setDirs,
+		r.setUpdate,
+		r.setUris,
+		r.setSuites,
+		r.setComponents,
+		r.setTypes,
+		r.setArchitectures,
+		r.setKey,
+	}
+
+	for _, s := range setters {
+		err = s(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *AptRepository) Run() error {
+	var changed bool
+	var err error
+
+	if r.Ensure == haiconf.ENSURE_ABSENT {
+		changed, err = r.remove()
+	} else {
+		changed, err = r.install()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !changed || !r.Update {
+		return nil
+	}
+
+	ag := AptGet{
+		Method: METHOD_UPDATE,
+		rc:     r.rc,
+	}
+
+	return ag.Run()
+}
+
+func (r *AptRepository) install() (bool, error) {
+	keyChanged, err := r.installKey()
+	if err != nil {
+		return false, err
+	}
+
+	content := r.sources()
+	if r.Format == FORMAT_LIST {
+		content = r.list()
+	}
+
+	sourcesChanged, err := r.write(r.sourcesPath(r.Format), []byte(content))
+	if err != nil {
+		return false, err
+	}
+
+	// switching formats must not leave the repository declared twice
+	staleChanged, err := r.removeFile(r.sourcesPath(otherFormat(r.Format)))
+	if err != nil {
+		return false, err
+	}
+
+	return keyChanged || sourcesChanged || staleChanged, nil
+}
+
+func (r *AptRepository) remove() (bool, error) {
+	changed, err := r.removeKeyrings("")
+	if err != nil {
+		return false, err
+	}
+
+	for _, format := range []string{FORMAT_DEB822, FORMAT_LIST} {
+		removed, err := r.removeFile(r.sourcesPath(format))
+		if err != nil {
+			return false, err
+		}
+
+		changed = changed || removed
+	}
+
+	return changed, nil
+}
+
+// installKey installs Key in KeyringsDir and removes the keyrings
+// the repository does not use anymore
+func (r *AptRepository) installKey() (bool, error) {
+	if r.Key == "" {
+		return r.removeKeyrings("")
+	}
+
+	installed := r.installedKeyring()
+	if installed != "" {
+		haiconf.Output(r.rc, "Keyring %s already holds %s", installed, r.KeyFingerprint)
+		r.keyring = installed
+		return r.removeKeyrings(installed)
+	}
+
+	key, err := r.readKey()
+	if err != nil {
+		return false, err
+	}
+
+	err = r.checkFingerprint(key)
+	if err != nil {
+		return false, err
+	}
+
+	r.keyring = keyringPath(r.KeyringsDir, r.Name, key)
+
+	changed, err := r.write(r.keyring, key)
+	if err != nil {
+		return false, err
+	}
+
+	// the key may have been converted from or to an armored key
+	removed, err := r.removeKeyrings(r.keyring)
+	if err != nil {
+		return false, err
+	}
+
+	return changed || removed, nil
+}
+
+// installedKeyring returns the keyring downloaded by a previous run
+// when it holds KeyFingerprint, so it is not downloaded again. Local
+// keys are always read again since they may have been updated.
+func (r *AptRepository) installedKeyring() string {
+	if !httpget.IsUrl(r.Key) {
+		return ""
+	}
+
+	for _, p := range r.keyringPaths() {
+		buff, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+
+		fingerprints, err := keyFingerprints(buff)
+		if err == nil && len(fingerprints) == 1 && fingerprints[0] == r.KeyFingerprint {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// removeKeyrings removes every keyring of the repository but keep
+func (r *AptRepository) removeKeyrings(keep string) (bool, error) {
+	changed := false
+
+	for _, p := range r.keyringPaths() {
+		if p == keep {
+			continue
+		}
+
+		removed, err := r.removeFile(p)
+		if err != nil {
+			return false, err
+		}
+
+		changed = changed || removed
+	}
+
+	return changed, nil
+}
+
+// write replaces the content of p with buff when it differs
+// and reports whether it did
+func (r *AptRepository) write(p string, buff []byte) (bool, error) {
+	current, err := ioutil.ReadFile(p)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if exists && bytes.Equal(current, buff) {
+		haiconf.Output(r.rc, "File %s is unchanged", p)
+		return false, nil
+	}
+
+	if exists && !fs.IsBinary(current) && !fs.IsBinary(buff) {
+		diff := fs.UnifiedDiff(p, p, current, buff)
+		haiconf.Output(r.rc, "%s", strings.TrimRight(diff, "\n"))
+	}
+
+	haiconf.Output(r.rc, "Writing file %s", p)
+	if r.rc.DryRun {
+		return true, nil
+	}
+
+	if exists {
+		err = r.backup(p)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = fs.MkDir(path.Dir(p), true, fs.DEFAULT_MODE_DIRECTORY)
+	if err != nil {
+		return false, err
+	}
+
+	return true, fs.WriteFileAtomic(p, buff, fs.DEFAULT_MODE_FILE, nil, nil)
+}
+
+func (r *AptRepository) removeFile(p string) (bool, error) {
+	_, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	haiconf.Output(r.rc, "Removing file %s", p)
+	if r.rc.DryRun {
+		return true, nil
+	}
+
+	err = r.backup(p)
+	if err != nil {
+		return false, err
+	}
+
+	return true, os.Remove(p)
+}
+
+func (r *AptRepository) backup(p string) error {
+	e, err := backup.NewBucket(r.rc.BackupDir, r.rc.RunId).StoreFile(p)
+	if err != nil {
+		return err
+	}
+
+	if e != nil {
+		haiconf.Output(r.rc, "Backed up %s as %s", p, e.Id)
+	}
+
+	return nil
+}
+
+func (r *AptRepository) readKey() ([]byte, error) {
+	if httpget.IsUrl(r.Key) {
+		haiconf.Output(r.rc, "Downloading %s", r.Key)
+		return httpget.Fetch(r.Key)
+	}
+
+	return ioutil.ReadFile(r.Key)
+}
+
+// checkFingerprint makes sure key holds a single public
+// key whose fingerprint is KeyFingerprint
+func (r *AptRepository) checkFingerprint(key []byte) error {
+	fingerprints, err := keyFingerprints(key)
+	if err != nil {
+		return err
+	}
+
+	if len(fingerprints) != 1 {
+		return fmt.Errorf("%s must contain exactly one key, %d found", r.Key, len(fingerprints))
+	}
+
+	if fingerprints[0] != r.KeyFingerprint {
+		return fmt.Errorf("Fingerprint mismatch for %s : expected %s, got %s", r.Key, r.KeyFingerprint, fingerprints[0])
+	}
+
+	return nil
+}
+
+// keyFingerprints returns the fingerprints of the primary keys
+// in key, subkeys are ignored
+func keyFingerprints(key []byte) ([]string, error) {
+	dir, err := ioutil.TempDir("", "haiconf-gpg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := path.Join(dir, "key")
+	err = ioutil.WriteFile(keyFile, key, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := osutils.SystemCommand{
+		Path:                 gpgPath,
+		Args:                 []string{"--homedir", dir, "--batch", "--with-colons", "--show-keys", keyFile},
+		ExecDir:              dir,
+		EnableShellExpansion: true,
+	}
+
+	output := sc.Run()
+	if output.HasError() {
+		return nil, output
+	}
+
+	fingerprints := []string{}
+	primary := false
+
+	for _, line := range strings.Split(output.Stdout, "\n") {
+		fields := strings.Split(line, ":")
+
+		switch fields[0] {
+		case "pub":
+			primary = true
+		case "sub":
+			primary = false
+		case "fpr":
+			if primary && len(fields) > 9 {
+				fingerprints = append(fingerprints, fields[9])
+				primary = false
+			}
+		}
+	}
+
+	return fingerprints, nil
+}
+
+// sources formats the repository as a deb822 stanza, see sources.list(5)
+func (r *AptRepository) sources() string {
+	fields := [][]string{
+		{"Types", strings.Join(r.Types, " ")},
+		{"URIs", strings.Join(r.Uris, " ")},
+		{"Suites", strings.Join(r.Suites, " ")},
+		{"Components", strings.Join(r.Components, " ")},
+		{"Architectures", strings.Join(r.Architectures, " ")},
+	}
+
+	if r.keyring != "" {
+		fields = append(fields, []string{"Signed-By", r.keyring})
+	}
+
+	buff := new(bytes.Buffer)
+	for _, f := range fields {
+		if f[1] != "" {
+			fmt.Fprintf(buff, "%s: %s\n", f[0], f[1])
+		}
+	}
+
+	return buff.String()
+}
+
+// list formats the repository in the one-line style, one
+// line per type, uri and suite
+func (r *AptRepository) list() string {
+	options := []string{}
+
+	if len(r.Architectures) > 0 {
+		options = append(options, "arch="+strings.Join(r.Architectures, ","))
+	}
+
+	if r.keyring != "" {
+		options = append(options, "signed-by="+r.keyring)
+	}
+
+	opts := ""
+	if len(options) > 0 {
+		opts = " [" + strings.Join(options, " ") + "]"
+	}
+
+	buff := new(bytes.Buffer)
+
+	for _, t := range r.Types {
+		for _, uri := range r.Uris {
+			for _, suite := range r.Suites {
+				line := strings.Join(append([]string{t + opts, uri, suite}, r.Components...), " ")
+				fmt.Fprintln(buff, line)
+			}
+		}
+	}
+
+	return buff.String()
+}
+
+func (r *AptRepository) sourcesPath(format string) string {
+	ext := ".sources"
+	if format == FORMAT_LIST {
+		ext = ".list"
+	}
+
+	return path.Join(r.SourcesDir, r.Name+ext)
+}
+
+// keyringPath returns where key is installed, apt reads armored
+// keys only when they are named .asc
+func keyringPath(dir string, name string, key []byte) string {
+	if isArmored(key) {
+		return path.Join(dir, name+".asc")
+	}
+
+	return path.Join(dir, name+".gpg")
+}
+
+func isArmored(key []byte) bool {
+	return bytes.Contains(key, []byte(armoredKeyHeader))
+}
+
+func (r *AptRepository) keyringPaths() []string {
+	return []string{
+		path.Join(r.KeyringsDir, r.Name+".asc"),
+		path.Join(r.KeyringsDir, r.Name+".gpg"),
+	}
+}
+
+func otherFormat(format string) string {
+	if format == FORMAT_LIST {
+		return FORMAT_DEB822
+	}
+
+	return FORMAT_LIST
+}
+
+func (r *AptRepository) setName(args haiconf.CommandArgs) error {
+	n, err := haiconf.CheckString("Name", args)
+	if err != nil {
+		return err
+	}
+
+	if !repositoryNameRegexp.MatchString(n) {
+		return haiconf.NewArgError("Invalid repository name "+n, args)
+	}
+
+	r.Name = n
+	return nil
+}
+
+func (r *AptRepository) setEnsure(args haiconf.CommandArgs) error {
+	e, err := haiconf.CheckEnsure(args)
+	if err != nil {
+		return err
+	}
+
+	r.Ensure = e
+	return nil
+}
+
+func (r *AptRepository) setFormat(args haiconf.CommandArgs) error {
+	_, present := args["Format"]
+	if !present {
+		return nil
+	}
+
+	f, err := haiconf.CheckStringChoice("Format", args, []string{FORMAT_DEB822, FORMAT_LIST})
+	if err != nil {
+		return err
+	}
+
+	r.Format = f
+	return nil
+}
+
+func (r *AptRepository) setDirs(args haiconf.CommandArgs) error {
+	_, present := args["SourcesDir"]
+	if present {
+		d, err := haiconf.CheckAbsolutePath("SourcesDir", args)
+		if err != nil {
+			return err
+		}
+
+		r.SourcesDir = d
+	}
+
+	_, present = args["KeyringsDir"]
+	if present {
+		d, err := haiconf.CheckAbsolutePath("KeyringsDir", args)
+		if err != nil {
+			return err
+		}
+
+		r.KeyringsDir = d
+	}
+
+	return nil
+}
+
+func (r *AptRepository) setUpdate(args haiconf.CommandArgs) error {
+	_, present := args["Update"]
+	if present {
+		r.Update = haiconf.CheckBool("Update", args)
+	}
+
+	return nil
+}
+
+func (r *AptRepository) setUris(args haiconf.CommandArgs) error {
+	if r.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	uris, err := checkWords("Uris", args)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range uris {
+		if !strings.Contains(u, ":/") {
+			return haiconf.NewArgError("Invalid uri "+u, args)
+		}
+	}
+
+	r.Uris = uris
+	return nil
+}
+
+func (r *AptRepository) setSuites(args haiconf.CommandArgs) error {
+	if r.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	suites, err := checkWords("Suites", args)
+	if err != nil {
+		return err
+	}
+
+	r.Suites = suites
+	return nil
+}
+
+func (r *AptRepository) setComponents(args haiconf.CommandArgs) error {
+	if r.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	flat := true
+	for _, s := range r.Suites {
+		flat = flat && strings.HasSuffix(s, "/")
+	}
+
+	_, present := args["Components"]
+	if !present {
+		if flat {
+			return nil
+		}
+
+		return haiconf.NewArgError("Components must be provided", args)
+	}
+
+	if flat {
+		return haiconf.NewArgError("Components must not be provided for flat repositories", args)
+	}
+
+	components, err := checkWords("Components", args)
+	if err != nil {
+		return err
+	}
+
+	r.Components = components
+	return nil
+}
+
+func (r *AptRepository) setTypes(args haiconf.CommandArgs) error {
+	_, present := args["Types"]
+	if !present {
+		return nil
+	}
+
+	types, err := checkWords("Types", args)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range types {
+		if t != "deb" && t != "deb-src" {
+			return haiconf.NewArgError("Invalid type "+t+". Valid types are deb, deb-src", args)
+		}
+	}
+
+	r.Types = types
+	return nil
+}
+
+func (r *AptRepository) setArchitectures(args haiconf.CommandArgs) error {
+	_, present := args["Architectures"]
+	if !present {
+		return nil
+	}
+
+	archs, err := checkWords("Architectures", args)
+	if err != nil {
+		return err
+	}
+
+	r.Architectures = archs
+	return nil
+}
+
+func (r *AptRepository) setKey(args haiconf.CommandArgs) error {
+	_, present := args["Key"]
+	if !present || r.Ensure == haiconf.ENSURE_ABSENT {
+		return nil
+	}
+
+	k, err := haiconf.CheckString("Key", args)
+	if err != nil {
+		return err
+	}
+
+	if !httpget.IsUrl(k) && !path.IsAbs(k) {
+		return haiconf.NewArgError("Key must be an absolute path or an http(s) url", args)
+	}
+
+	fpr, err := haiconf.CheckString("KeyFingerprint", args)
+	if err != nil {
+		return err
+	}
+
+	fpr = strings.ToUpper(strings.Replace(fpr, " ", "", -1))
+	if !fingerprintRegexp.MatchString(fpr) {
+		return haiconf.NewArgError("KeyFingerprint must be a full 40 characters fingerprint", args)
+	}
+
+	r.Key = k
+	r.KeyFingerprint = fpr
+	return nil
+}
+
+// checkWords reads a string or a list of strings which
+// must not contain spaces
+func checkWords(k string, args haiconf.CommandArgs) ([]string, error) {
+	words, err := haiconf.CheckStringList(k, args)
+	if err != nil {
+		w, err := haiconf.CheckString(k, args)
+		if err != nil {
+			return nil, err
+		}
+
+		words = []string{w}
+	}
+
+	if len(words) == 0 {
+		return nil, haiconf.NewArgError(k+" must be provided", args)
+	}
+
+	for _, w := range words {
+		if w == "" || strings.ContainsAny(w, " \t\n") {
+			return nil, haiconf.NewArgError("Invalid value "+w+" for "+k, args)
+		}
+	}
+
+	return words, nil
+}
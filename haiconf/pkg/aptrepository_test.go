@@ -0,0 +1,368 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bytes"
+	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+const (
+	testKeyFingerprint = "F828 3FEA CC13 8A14 5818  2EE2 6B83 D176 F84E 4FC4"
+)
+
+type AptRepositoryTestSuite struct {
+	r      *AptRepository
+	dir    string
+	output *bytes.Buffer
+}
+
+var _ = Suite(&AptRepositoryTestSuite{})
+
+func (s *AptRepositoryTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.output = new(bytes.Buffer)
+
+	s.r = new(AptRepository)
+	err := s.r.SetDefault(&haiconf.RuntimeConfig{
+		Verbose: true,
+		Output:  s.output,
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *AptRepositoryTestSuite) TearDownTest(c *C) {
+	aptGetPath = APT_GET
+	gpgPath = GPG
+}
+
+func (s *AptRepositoryTestSuite) args(extra haiconf.CommandArgs) haiconf.CommandArgs {
+	args := haiconf.CommandArgs{
+		"Name":        "example",
+		"Uris":        []interface{}{"https://apt.example.com/debian"},
+		"Suites":      []interface{}{"bookworm"},
+		"Components":  []interface{}{"main", "contrib"},
+		"Ensure":      haiconf.ENSURE_PRESENT,
+		"SourcesDir":  s.dir + "/sources.list.d",
+		"KeyringsDir": s.dir + "/keyrings",
+	}
+
+	for k, v := range extra {
+		args[k] = v
+	}
+
+	return args
+}
+
+// run configures and runs a new command and tells whether apt-get was called
+func (s *AptRepositoryTestSuite) run(c *C, args haiconf.CommandArgs) bool {
+	dir, _ := fakeAptGet(c, "")
+
+	s.r.SetDefault(s.r.rc)
+	err := s.r.SetUserConfig(args)
+	c.Assert(err, IsNil)
+
+	err = s.r.Run()
+	c.Assert(err, IsNil)
+
+	buff, err := ioutil.ReadFile(dir + "/args")
+	if os.IsNotExist(err) {
+		return false
+	}
+
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Matches, "(?s).*update.*")
+	return true
+}
+
+func skipWithoutGpg(c *C) {
+	_, err := os.Stat(GPG)
+	if err != nil {
+		c.Skip("gpg is not available")
+	}
+}
+
+func (s *AptRepositoryTestSuite) TestSetDefault(c *C) {
+	c.Assert(s.r.Types, DeepEquals, []string{"deb"})
+	c.Assert(s.r.Format, Equals, FORMAT_DEB822)
+	c.Assert(s.r.Update, Equals, true)
+	c.Assert(s.r.SourcesDir, Equals, DEFAULT_SOURCES_DIR)
+	c.Assert(s.r.KeyringsDir, Equals, DEFAULT_KEYRINGS_DIR)
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_InvalidName(c *C) {
+	err := s.r.SetUserConfig(s.args(haiconf.CommandArgs{"Name": "../foo"}))
+	c.Assert(err, ErrorMatches, "Invalid repository name ../foo.*")
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_ComponentsRequired(c *C) {
+	args := s.args(nil)
+	delete(args, "Components")
+
+	err := s.r.SetUserConfig(args)
+	c.Assert(err, ErrorMatches, "Components must be provided.*")
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_FlatRepository(c *C) {
+	args := s.args(haiconf.CommandArgs{"Suites": "./"})
+	delete(args, "Components")
+
+	err := s.r.SetUserConfig(args)
+	c.Assert(err, IsNil)
+	c.Assert(s.r.Suites, DeepEquals, []string{"./"})
+
+	err = s.r.SetUserConfig(s.args(haiconf.CommandArgs{"Suites": "./"}))
+	c.Assert(err, ErrorMatches, "Components must not be provided for flat repositories.*")
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_InvalidType(c *C) {
+	err := s.r.SetUserConfig(s.args(haiconf.CommandArgs{"Types": []interface{}{"rpm"}}))
+	c.Assert(err, ErrorMatches, "Invalid type rpm.*")
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_KeyRequiresFingerprint(c *C) {
+	err := s.r.SetUserConfig(s.args(haiconf.CommandArgs{"Key": "/tmp/key.asc"}))
+	c.Assert(err, NotNil)
+
+	err = s.r.SetUserConfig(s.args(haiconf.CommandArgs{
+		"Key":            "/tmp/key.asc",
+		"KeyFingerprint": "6B83D176F84E4FC4",
+	}))
+	c.Assert(err, ErrorMatches, "KeyFingerprint must be a full 40 characters fingerprint.*")
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_RelativeKey(c *C) {
+	err := s.r.SetUserConfig(s.args(haiconf.CommandArgs{
+		"Key":            "key.asc",
+		"KeyFingerprint": testKeyFingerprint,
+	}))
+	c.Assert(err, ErrorMatches, "Key must be an absolute path or an http\\(s\\) url.*")
+}
+
+func (s *AptRepositoryTestSuite) TestSetUserConfig_FingerprintNormalized(c *C) {
+	err := s.r.SetUserConfig(s.args(haiconf.CommandArgs{
+		"Key":            "https://apt.example.com/key.asc",
+		"KeyFingerprint": "f828 3fea cc13 8a14 5818  2ee2 6b83 d176 f84e 4fc4",
+	}))
+	c.Assert(err, IsNil)
+	c.Assert(s.r.KeyFingerprint, Equals, "F8283FEACC138A1458182EE26B83D176F84E4FC4")
+}
+
+func (s *AptRepositoryTestSuite) TestRun_Deb822(c *C) {
+	skipWithoutGpg(c)
+
+	key, err := os.Getwd()
+	c.Assert(err, IsNil)
+	key += "/testdata/repository-key.asc"
+
+	updated := s.run(c, s.args(haiconf.CommandArgs{
+		"Types":          []interface{}{"deb", "deb-src"},
+		"Architectures":  []interface{}{"amd64"},
+		"Key":            key,
+		"KeyFingerprint": testKeyFingerprint,
+	}))
+	c.Assert(updated, Equals, true)
+
+	buff, err := ioutil.ReadFile(s.dir + "/sources.list.d/example.sources")
+	c.Assert(err, IsNil)
+
+	expected := "Types: deb deb-src\n" +
+		"URIs: https://apt.example.com/debian\n" +
+		"Suites: bookworm\n" +
+		"Components: main contrib\n" +
+		"Architectures: amd64\n" +
+		"Signed-By: " + s.dir + "/keyrings/example.asc\n"
+	c.Assert(string(buff), Equals, expected)
+
+	installed, err := ioutil.ReadFile(s.dir + "/keyrings/example.asc")
+	c.Assert(err, IsNil)
+	original, err := ioutil.ReadFile(key)
+	c.Assert(err, IsNil)
+	c.Assert(installed, DeepEquals, original)
+
+	// nothing changed, no update
+	updated = s.run(c, s.args(haiconf.CommandArgs{
+		"Types":          []interface{}{"deb", "deb-src"},
+		"Architectures":  []interface{}{"amd64"},
+		"Key":            key,
+		"KeyFingerprint": testKeyFingerprint,
+	}))
+	c.Assert(updated, Equals, false)
+}
+
+func (s *AptRepositoryTestSuite) TestRun_List(c *C) {
+	skipWithoutGpg(c)
+
+	key, err := os.Getwd()
+	c.Assert(err, IsNil)
+	key += "/testdata/repository-key.gpg"
+
+	args := s.args(haiconf.CommandArgs{
+		"Format":         FORMAT_LIST,
+		"Suites":         []interface{}{"bookworm", "bookworm-backports"},
+		"Architectures":  []interface{}{"amd64", "arm64"},
+		"Key":            key,
+		"KeyFingerprint": testKeyFingerprint,
+	})
+
+	updated := s.run(c, args)
+	c.Assert(updated, Equals, true)
+
+	buff, err := ioutil.ReadFile(s.dir + "/sources.list.d/example.list")
+	c.Assert(err, IsNil)
+
+	opts := "[arch=amd64,arm64 signed-by=" + s.dir + "/keyrings/example.gpg]"
+	expected := "deb " + opts + " https://apt.example.com/debian bookworm main contrib\n" +
+		"deb " + opts + " https://apt.example.com/debian bookworm-backports main contrib\n"
+	c.Assert(string(buff), Equals, expected)
+
+	_, err = os.Stat(s.dir + "/keyrings/example.gpg")
+	c.Assert(err, IsNil)
+}
+
+func (s *AptRepositoryTestSuite) TestRun_KeyFromUrl(c *C) {
+	skipWithoutGpg(c)
+
+	key, err := ioutil.ReadFile("testdata/repository-key.asc")
+	c.Assert(err, IsNil)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(key)
+	}))
+	defer server.Close()
+
+	args := s.args(haiconf.CommandArgs{
+		"Key":            server.URL + "/key",
+		"KeyFingerprint": testKeyFingerprint,
+	})
+
+	updated := s.run(c, args)
+	c.Assert(updated, Equals, true)
+	c.Assert(requests, Equals, 1)
+
+	// armored keys are named .asc whatever the url
+	buff, err := ioutil.ReadFile(s.dir + "/sources.list.d/example.sources")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Matches, "(?s).*Signed-By: "+s.dir+"/keyrings/example.asc\n")
+
+	_, err = os.Stat(s.dir + "/keyrings/example.asc")
+	c.Assert(err, IsNil)
+
+	// the installed key already holds the fingerprint, it is not fetched again
+	updated = s.run(c, args)
+	c.Assert(updated, Equals, false)
+	c.Assert(requests, Equals, 1)
+}
+
+func (s *AptRepositoryTestSuite) TestRun_KeyRemoved(c *C) {
+	skipWithoutGpg(c)
+
+	key, err := os.Getwd()
+	c.Assert(err, IsNil)
+	key += "/testdata/repository-key.gpg"
+
+	updated := s.run(c, s.args(haiconf.CommandArgs{
+		"Key":            key,
+		"KeyFingerprint": testKeyFingerprint,
+	}))
+	c.Assert(updated, Equals, true)
+
+	_, err = os.Stat(s.dir + "/keyrings/example.gpg")
+	c.Assert(err, IsNil)
+
+	updated = s.run(c, s.args(nil))
+	c.Assert(updated, Equals, true)
+
+	_, err = os.Stat(s.dir + "/keyrings/example.gpg")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	buff, err := ioutil.ReadFile(s.dir + "/sources.list.d/example.sources")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Not(Matches), "(?s).*Signed-By.*")
+}
+
+func (s *AptRepositoryTestSuite) TestRun_FormatSwitch(c *C) {
+	updated := s.run(c, s.args(nil))
+	c.Assert(updated, Equals, true)
+
+	updated = s.run(c, s.args(haiconf.CommandArgs{"Format": FORMAT_LIST}))
+	c.Assert(updated, Equals, true)
+
+	_, err := os.Stat(s.dir + "/sources.list.d/example.sources")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	buff, err := ioutil.ReadFile(s.dir + "/sources.list.d/example.list")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "deb https://apt.example.com/debian bookworm main contrib\n")
+}
+
+func (s *AptRepositoryTestSuite) TestRun_FingerprintMismatch(c *C) {
+	skipWithoutGpg(c)
+
+	key, err := os.Getwd()
+	c.Assert(err, IsNil)
+	key += "/testdata/repository-key.asc"
+
+	err = s.r.SetUserConfig(s.args(haiconf.CommandArgs{
+		"Key":            key,
+		"KeyFingerprint": "0000000000000000000000000000000000000000",
+	}))
+	c.Assert(err, IsNil)
+
+	err = s.r.Run()
+	c.Assert(err, ErrorMatches, "Fingerprint mismatch for .* : expected 0{40}, got F8283FEACC138A1458182EE26B83D176F84E4FC4")
+
+	_, err = os.Stat(s.dir + "/keyrings")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(s.dir + "/sources.list.d")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *AptRepositoryTestSuite) TestRun_NoUpdate(c *C) {
+	updated := s.run(c, s.args(haiconf.CommandArgs{"Update": false}))
+	c.Assert(updated, Equals, false)
+
+	_, err := os.Stat(s.dir + "/sources.list.d/example.sources")
+	c.Assert(err, IsNil)
+}
+
+func (s *AptRepositoryTestSuite) TestRun_Absent(c *C) {
+	updated := s.run(c, s.args(nil))
+	c.Assert(updated, Equals, true)
+
+	args := haiconf.CommandArgs{
+		"Name":        "example",
+		"Ensure":      haiconf.ENSURE_ABSENT,
+		"SourcesDir":  s.dir + "/sources.list.d",
+		"KeyringsDir": s.dir + "/keyrings",
+	}
+
+	updated = s.run(c, args)
+	c.Assert(updated, Equals, true)
+
+	_, err := os.Stat(s.dir + "/sources.list.d/example.sources")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	updated = s.run(c, args)
+	c.Assert(updated, Equals, false)
+}
+
+func (s *AptRepositoryTestSuite) TestRun_DryRun(c *C) {
+	s.r.rc.DryRun = true
+
+	updated := s.run(c, s.args(nil))
+	c.Assert(updated, Equals, false)
+
+	_, err := os.Stat(s.dir + "/sources.list.d")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(s.output.String(), Matches, "(?s).*Writing file .*example.sources.*Updating package lists.*")
+}
@@ -0,0 +1,128 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+const (
+	DPKG_STATUS = "/var/lib/dpkg/status"
+)
+
+type DpkgPackage struct {
+	Name         string
+	Version      string
+	Architecture string
+
+	// Status holds the want, flag and status words,
+	// as in "install ok installed"
+	Status string
+}
+
+func (p DpkgPackage) IsInstalled() bool {
+	fields := strings.Fields(p.Status)
+	return len(fields) == 3 && fields[2] == "installed"
+}
+
+// IsHeld tells whether the package is on hold, held packages
+// are neither upgraded nor removed by apt-get
+func (p DpkgPackage) IsHeld() bool {
+	fields := strings.Fields(p.Status)
+	return len(fields) == 3 && fields[0] == "hold"
+}
+
+// ReadDpkgStatus parses the dpkg status database at path and returns
+// the packages known to dpkg, installed or not, by name. Packages
+// for a specific architecture are also available as name:arch.
+func ReadDpkgStatus(path string) (map[string]DpkgPackage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	packages := make(map[string]DpkgPackage)
+
+	add := func(p DpkgPackage) {
+		if p.Name == "" {
+			return
+		}
+
+		// multi arch packages may be known for several architectures,
+		// the installed one wins for the bare name
+		current, found := packages[p.Name]
+		if !found || !current.IsInstalled() {
+			packages[p.Name] = p
+		}
+
+		if p.Architecture != "" && p.Architecture != "all" {
+			packages[p.Name+":"+p.Architecture] = p
+		}
+	}
+
+	current := DpkgPackage{}
+	scanner := bufio.NewScanner(f)
+
+	// some control fields such as Description are long
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			add(current)
+			current = DpkgPackage{}
+			continue
+		}
+
+		// continuation of a multi line field
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+
+		v := strings.TrimSpace(line[idx+1:])
+
+		switch line[:idx] {
+		case "Package":
+			current.Name = v
+		case "Version":
+			current.Version = v
+		case "Architecture":
+			current.Architecture = v
+		case "Status":
+			current.Status = v
+		}
+	}
+
+	add(current)
+
+	return packages, scanner.Err()
+}
+
+// InstalledPackages returns the version of every installed
+// package, by name, for use in lua configuration files
+func InstalledPackages(path string) (map[string]string, error) {
+	packages, err := ReadDpkgStatus(path)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]string, len(packages))
+	for name, p := range packages {
+		if p.IsInstalled() {
+			versions[name] = p.Version
+		}
+	}
+
+	return versions, nil
+}
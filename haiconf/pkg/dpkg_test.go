@@ -0,0 +1,60 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+)
+
+type DpkgTestSuite struct{}
+
+var _ = Suite(&DpkgTestSuite{})
+
+func (s *DpkgTestSuite) TestReadDpkgStatus(c *C) {
+	installed, err := ReadDpkgStatus("testdata/dpkg-status")
+	c.Assert(err, IsNil)
+
+	c.Assert(installed["vim"], Equals, DpkgPackage{
+		Name:         "vim",
+		Version:      "2:7.3.547-7",
+		Architecture: "amd64",
+		Status:       "install ok installed",
+	})
+	c.Assert(installed["vim:amd64"], Equals, installed["vim"])
+
+	c.Assert(installed["libc6:i386"].Version, Equals, "2.13-38")
+	c.Assert(installed["tzdata"].Version, Equals, "2013c-0wheezy1")
+
+	_, found := installed["tzdata:all"]
+	c.Assert(found, Equals, false)
+
+	// removed with config files left, half installed
+	for _, name := range []string{"mutt", "nginx"} {
+		c.Assert(installed[name].IsInstalled(), Equals, false, Commentf(name))
+	}
+
+	c.Assert(installed["vim"].IsInstalled(), Equals, true)
+	c.Assert(installed["vim"].IsHeld(), Equals, false)
+	c.Assert(installed["libc6"].IsHeld(), Equals, true)
+	c.Assert(len(installed), Equals, 9)
+}
+
+func (s *DpkgTestSuite) TestReadDpkgStatus_Missing(c *C) {
+	_, err := ReadDpkgStatus("testdata/missing")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DpkgTestSuite) TestInstalledPackages(c *C) {
+	versions, err := InstalledPackages("testdata/dpkg-status")
+	c.Assert(err, IsNil)
+	c.Assert(versions["vim"], Equals, "2:7.3.547-7")
+	c.Assert(versions["libc6"], Equals, "2.13-38")
+
+	for _, name := range []string{"mutt", "nginx"} {
+		_, found := versions[name]
+		c.Assert(found, Equals, false, Commentf(name))
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a Debian package version, [epoch:]upstream[-revision].
+// See deb-version(5).
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+func ParseVersion(s string) (Version, error) {
+	v := Version{}
+	rest := strings.TrimSpace(s)
+
+	if rest == "" {
+		return v, fmt.Errorf("Invalid version %q : empty", s)
+	}
+
+	idx := strings.Index(rest, ":")
+	if idx >= 0 {
+		epoch, err := strconv.Atoi(rest[:idx])
+		if err != nil || epoch < 0 {
+			return v, fmt.Errorf("Invalid version %q : bad epoch", s)
+		}
+
+		v.Epoch = epoch
+		rest = rest[idx+1:]
+	}
+
+	idx = strings.LastIndex(rest, "-")
+	if idx >= 0 {
+		v.Revision = rest[idx+1:]
+		rest = rest[:idx]
+
+		if v.Revision == "" || !validVersionPart(v.Revision, ".+~") {
+			return v, fmt.Errorf("Invalid version %q : bad revision", s)
+		}
+	}
+
+	v.Upstream = rest
+
+	if v.Upstream == "" || v.Upstream[0] < '0' || v.Upstream[0] > '9' {
+		return v, fmt.Errorf("Invalid version %q : upstream version must start with a digit", s)
+	}
+
+	if !validVersionPart(v.Upstream, ".+~-") {
+		return v, fmt.Errorf("Invalid version %q : bad upstream version", s)
+	}
+
+	return v, nil
+}
+
+func validVersionPart(s string, extra string) bool {
+	for _, r := range s {
+		isAlnum := (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !isAlnum && !strings.ContainsRune(extra, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (v Version) String() string {
+	s := v.Upstream
+
+	if v.Epoch > 0 {
+		s = strconv.Itoa(v.Epoch) + ":" + s
+	}
+
+	if v.Revision != "" {
+		s += "-" + v.Revision
+	}
+
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is older, equal or newer than o
+func (v Version) Compare(o Version) int {
+	if v.Epoch != o.Epoch {
+		if v.Epoch < o.Epoch {
+			return -1
+		}
+
+		return 1
+	}
+
+	c := compareVersionPart(v.Upstream, o.Upstream)
+	if c != 0 {
+		return c
+	}
+
+	// a missing revision compares as "0", as dpkg does
+	return compareVersionPart(v.Revision, o.Revision)
+}
+
+// CompareVersions compares version strings, invalid versions
+// are compared as plain strings
+func CompareVersions(a string, b string) int {
+	va, errA := ParseVersion(a)
+	vb, errB := ParseVersion(b)
+
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+
+	return va.Compare(vb)
+}
+
+// compareVersionPart implements the dpkg algorithm: non digit parts
+// are compared with letters sorting before other characters and ~
+// sorting before anything, even the end of the part. Digit parts are
+// compared numerically.
+func compareVersionPart(a string, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			ac, bc := 0, 0
+
+			if a != "" {
+				ac = versionCharOrder(a[0])
+			}
+
+			if b != "" {
+				bc = versionCharOrder(b[0])
+			}
+
+			if ac != bc {
+				return sign(ac - bc)
+			}
+
+			a, b = shift(a), shift(b)
+		}
+
+		for a != "" && a[0] == '0' {
+			a = a[1:]
+		}
+
+		for b != "" && b[0] == '0' {
+			b = b[1:]
+		}
+
+		firstDiff := 0
+		for a != "" && isDigit(a[0]) && b != "" && isDigit(b[0]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[0]) - int(b[0])
+			}
+
+			a, b = a[1:], b[1:]
+		}
+
+		if a != "" && isDigit(a[0]) {
+			return 1
+		}
+
+		if b != "" && isDigit(b[0]) {
+			return -1
+		}
+
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+
+	return 0
+}
+
+func versionCharOrder(c byte) int {
+	switch {
+	case isDigit(c):
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func shift(s string) string {
+	if s == "" {
+		return s
+	}
+
+	return s[1:]
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+
+	return 0
+}
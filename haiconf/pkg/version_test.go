@@ -0,0 +1,83 @@
+// Copyright 2013 Jérôme Renard. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkg
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type VersionTestSuite struct{}
+
+var _ = Suite(&VersionTestSuite{})
+
+func (s *VersionTestSuite) TestParseVersion(c *C) {
+	tests := map[string]Version{
+		"1.18.0-6":          {0, "1.18.0", "6"},
+		"2:7.3.547-7":       {2, "7.3.547", "7"},
+		"1.2.3":             {0, "1.2.3", ""},
+		"1.0-beta-2ubuntu1": {0, "1.0-beta", "2ubuntu1"},
+		"0.9~rc1+dfsg-1.1":  {0, "0.9~rc1+dfsg", "1.1"},
+	}
+
+	for str, expected := range tests {
+		v, err := ParseVersion(str)
+		c.Assert(err, IsNil, Commentf(str))
+		c.Assert(v, Equals, expected, Commentf(str))
+		c.Assert(v.String(), Equals, str)
+	}
+}
+
+func (s *VersionTestSuite) TestParseVersion_Invalid(c *C) {
+	for _, str := range []string{"", "a1.0", "x:1.0", "1.0-", "1.0_1", "-1:1.0", "1.0-1_2"} {
+		_, err := ParseVersion(str)
+		c.Assert(err, NotNil, Commentf(str))
+	}
+}
+
+func (s *VersionTestSuite) TestCompareVersions(c *C) {
+	// each version is older than the next one
+	ordered := []string{
+		"0.9~rc1",
+		"0.9",
+		"0.9-1",
+		"0.9a",
+		"0.9+dfsg",
+		"0.10",
+		"1.0~~",
+		"1.0~~a",
+		"1.0~",
+		"1.0",
+		"1.0-0ubuntu1",
+		"1.0-1",
+		"1.0-1.1",
+		"1.0-2",
+		"1.0-10",
+		"1.2.1-2.2",
+		"1.18.0-6",
+		"1:0.1",
+		"2:7.3.547-7",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			expected := sign(i - j)
+			obtained := CompareVersions(ordered[i], ordered[j])
+			c.Assert(obtained, Equals, expected, Commentf("%s vs %s", ordered[i], ordered[j]))
+		}
+	}
+}
+
+func (s *VersionTestSuite) TestCompareVersions_Equivalent(c *C) {
+	equivalent := [][]string{
+		{"1.0", "1.0-0", "0:1.0"},
+		{"1.01", "1.1"},
+	}
+
+	for _, versions := range equivalent {
+		for _, v := range versions {
+			c.Assert(CompareVersions(versions[0], v), Equals, 0, Commentf("%s vs %s", versions[0], v))
+		}
+	}
+}
@@ -65,10 +65,18 @@ func (g *Group) Run() error {
 
 	if g.Ensure == haiconf.ENSURE_PRESENT {
 		haiconf.Output(g.rc, "Adding group %s", g.Name)
+		if g.rc.DryRun {
+			return nil
+		}
+
 		return mgr.Add()
 	}
 
 	haiconf.Output(g.rc, "Removing group %s", g.Name)
+	if g.rc.DryRun {
+		return nil
+	}
+
 	return mgr.Remove()
 }
 
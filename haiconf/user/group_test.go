@@ -5,7 +5,9 @@
 package user
 
 import (
+	"github.com/jeromer/haiconf/hacks"
 	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"math/rand"
 	"strconv"
@@ -103,3 +105,21 @@ func (s *GroupTestSuite) TestSetUserConfig_Complete(c *C) {
 	c.Assert(s.g.Name, Equals, n)
 	c.Assert(s.g.Ensure, Equals, e)
 }
+
+func (s *GroupTestSuite) TestRun_DryRun(c *C) {
+	name := strconv.Itoa(rand.Int())
+
+	s.g.SetDefault(&haiconf.RuntimeConfig{DryRun: true, Output: ioutil.Discard})
+	err := s.g.SetUserConfig(haiconf.CommandArgs{
+		"Name":   name,
+		"Ensure": haiconf.ENSURE_PRESENT,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.g.action, Equals, ACTION_CREATE)
+
+	err = s.g.Run()
+	c.Assert(err, IsNil)
+
+	grp, err := hacks.LookupSystemGroup(name)
+	c.Assert(err == nil && grp.Gid != "", Equals, false)
+}
@@ -5,6 +5,7 @@
 package httpget
 
 import (
+	"fmt"
 	"github.com/jeromer/haiconf/haiconf"
 	"github.com/jeromer/haiconf/haiconf/utils"
 	"io/ioutil"
@@ -12,6 +13,16 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+)
+
+const (
+	// applies to the whole download, body included
+	DEFAULT_TIMEOUT = 5 * time.Minute
+)
+
+var (
+	client = &http.Client{Timeout: DEFAULT_TIMEOUT}
 )
 
 // Usage in a haiconf file
@@ -49,8 +60,11 @@ func (h *HttpGet) SetUserConfig(args haiconf.CommandArgs) error {
 
 func (h *HttpGet) Run() error {
 	haiconf.Output(h.rc, "Downloading %s to %s", h.From, h.To)
+	if h.rc.DryRun {
+		return nil
+	}
 
-	resp, err := http.Get(h.From)
+	resp, err := client.Get(h.From)
 	if err != nil {
 		return err
 	}
@@ -70,6 +84,28 @@ func (h *HttpGet) Run() error {
 	return f.Close()
 }
 
+// Fetch downloads url and returns its body. Responses other than
+// 200 OK are considered as errors, as well as downloads lasting
+// more than DEFAULT_TIMEOUT.
+func Fetch(url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to download %s : %s", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func IsUrl(s string) bool {
+	s = strings.ToLower(s)
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
 func (h *HttpGet) setFrom(args haiconf.CommandArgs) error {
 	f, _ := haiconf.CheckString("From", args)
 
@@ -6,10 +6,14 @@ package httpget
 
 import (
 	"github.com/jeromer/haiconf/haiconf"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Hooks up gocheck into the gotest runner.
@@ -164,3 +168,68 @@ func (s *HttpGetTestSuite) TestRun(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(bytesRead > 0, Equals, true)
 }
+
+func (s *HttpGetTestSuite) TestFetch(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.txt" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte("foo"))
+	}))
+	defer srv.Close()
+
+	buff, err := Fetch(srv.URL + "/file.txt")
+	c.Assert(err, IsNil)
+	c.Assert(string(buff), Equals, "foo")
+
+	_, err = Fetch(srv.URL + "/missing.txt")
+	c.Assert(err, ErrorMatches, "Unable to download .* : 404 Not Found")
+}
+
+func (s *HttpGetTestSuite) TestFetch_Timeout(c *C) {
+	stalled := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer srv.Close()
+	defer close(stalled)
+
+	client = &http.Client{Timeout: 100 * time.Millisecond}
+	defer func() { client = &http.Client{Timeout: DEFAULT_TIMEOUT} }()
+
+	_, err := Fetch(srv.URL + "/file.txt")
+	c.Assert(err, NotNil)
+}
+
+func (s *HttpGetTestSuite) TestIsUrl(c *C) {
+	c.Assert(IsUrl("http://example.com/"), Equals, true)
+	c.Assert(IsUrl("HTTPS://example.com/"), Equals, true)
+	c.Assert(IsUrl("/etc/hosts"), Equals, false)
+	c.Assert(IsUrl("ftp://example.com/"), Equals, false)
+}
+
+func (s *HttpGetTestSuite) TestRun_DryRun(c *C) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	to := c.MkDir() + "/example.html"
+
+	s.h.SetDefault(&haiconf.RuntimeConfig{DryRun: true, Output: ioutil.Discard})
+	err := s.h.SetUserConfig(haiconf.CommandArgs{
+		"From": server.URL,
+		"To":   to,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.h.Run()
+	c.Assert(err, IsNil)
+	c.Assert(requested, Equals, false)
+
+	_, err = os.Stat(to)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
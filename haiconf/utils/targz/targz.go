@@ -51,6 +51,9 @@ func (t *TarGz) SetUserConfig(args haiconf.CommandArgs) error {
 
 func (t *TarGz) Run() error {
 	haiconf.Output(t.rc, "Archiving %s to %s", t.Source, t.Dest)
+	if t.rc.DryRun {
+		return nil
+	}
 
 	return tarGz(t.Source, t.Dest)
 }
@@ -224,3 +224,20 @@ func (s *TarGzTestSuite) TestRun_TarGz(c *C) {
 	c.Assert(err, IsNil)
 	defer f.Close()
 }
+
+func (s *TarGzTestSuite) TestRun_DryRun(c *C) {
+	dest := c.MkDir() + "/fixtures.tar.gz"
+
+	s.t.SetDefault(&haiconf.RuntimeConfig{DryRun: true, Output: ioutil.Discard})
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Source": "./fixtures",
+		"Dest":   dest,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(dest)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -9,6 +9,8 @@ import (
 	"compress/gzip"
 	"github.com/dotcloud/tar"
 	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
+	"github.com/jeromer/haiconf/haiconf/fs"
 	"github.com/jeromer/haiconf/haiconf/utils"
 	"io"
 	"io/ioutil"
@@ -55,8 +57,12 @@ func (t *UnTarGz) SetUserConfig(args haiconf.CommandArgs) error {
 
 func (t *UnTarGz) Run() error {
 	haiconf.Output(t.rc, "Extracting %s to %s", t.Source, t.Dest)
+	if t.rc.DryRun {
+		return nil
+	}
 
-	return unTarGz(t.Source, t.Dest)
+	bucket := backup.NewBucket(t.rc.BackupDir, t.rc.RunId)
+	return unTarGz(t.Source, t.Dest, bucket)
 }
 
 func (t *UnTarGz) setSource(args haiconf.CommandArgs) error {
@@ -97,7 +103,7 @@ func (t *UnTarGz) setDest(args haiconf.CommandArgs) error {
 	return nil
 }
 
-func unTarGz(source string, dest string) error {
+func unTarGz(source string, dest string, bucket *backup.Bucket) error {
 	gunzipped, err := gunzip(source)
 	if err != nil {
 		return err
@@ -108,7 +114,7 @@ func unTarGz(source string, dest string) error {
 		return err
 	}
 
-	return writeFiles(archive, dest)
+	return writeFiles(archive, dest, bucket)
 }
 
 func untar(buff []byte) ([]tarItem, error) {
@@ -165,7 +171,7 @@ func gunzip(source string) ([]byte, error) {
 	return ioutil.ReadAll(reader)
 }
 
-func writeFiles(items []tarItem, dest string) error {
+func writeFiles(items []tarItem, dest string, bucket *backup.Bucket) error {
 	for _, it := range items {
 		typeFlag := it.header.Typeflag
 		mode := os.FileMode(it.header.Mode)
@@ -181,6 +187,21 @@ func writeFiles(items []tarItem, dest string) error {
 		}
 
 		if typeFlag == tar.TypeReg || typeFlag == tar.TypeRegA {
+			changed, err := contentChanged(name, it.body)
+			if err != nil {
+				return err
+			}
+
+			// identical files are neither backed up nor written again
+			if !changed {
+				continue
+			}
+
+			_, err = bucket.StoreFile(name)
+			if err != nil {
+				return err
+			}
+
 			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
 			if err != nil {
 				return err
@@ -208,3 +229,18 @@ func writeFiles(items []tarItem, dest string) error {
 
 	return nil
 }
+
+// contentChanged tells whether name does not exist yet or
+// holds something else than buff
+func contentChanged(name string, buff []byte) (bool, error) {
+	current, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return fs.Checksum(current) != fs.Checksum(buff), nil
+}
@@ -9,6 +9,7 @@ import (
 	//"compress/gzip"
 	"github.com/dotcloud/tar"
 	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
 	//"io"
 	"io/ioutil"
 	. "launchpad.net/gocheck"
@@ -233,7 +234,7 @@ func (s *UnTarGzTestSuite) TestWriteFiles(c *C) {
 		},
 	}
 
-	err = writeFiles(items, dest)
+	err = writeFiles(items, dest, backup.NewBucket("", ""))
 	c.Assert(err, IsNil)
 
 	for _, it := range items {
@@ -250,6 +251,44 @@ func (s *UnTarGzTestSuite) TestWriteFiles(c *C) {
 	c.Assert(obtained, DeepEquals, fileContents)
 }
 
+func (s *UnTarGzTestSuite) TestWriteFiles_Unchanged(c *C) {
+	dest := c.MkDir()
+	bucket := backup.NewBucket(c.MkDir(), "run-1")
+
+	items := []tarItem{
+		tarItem{
+			header: &tar.Header{
+				Name:     "somefile",
+				Typeflag: tar.TypeReg,
+				Mode:     0640,
+			},
+			body: []byte("foo"),
+		},
+	}
+
+	// the second extraction is identical, nothing is backed up
+	for i := 0; i < 2; i++ {
+		err := writeFiles(items, dest, bucket)
+		c.Assert(err, IsNil)
+	}
+
+	entries, err := bucket.List()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	items[0].body = []byte("bar")
+	err = writeFiles(items, dest, bucket)
+	c.Assert(err, IsNil)
+
+	entries, err = bucket.List()
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+
+	obtained, err := ioutil.ReadFile(dest + "/somefile")
+	c.Assert(err, IsNil)
+	c.Assert(string(obtained), Equals, "bar")
+}
+
 func (s *UnTarGzTestSuite) TestRun_UnTarGz(c *C) {
 	source := "./fixtures.tar.gz"
 
@@ -283,3 +322,21 @@ func (s *UnTarGzTestSuite) TestRun_UnTarGz(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(len(n) > 0, Equals, true)
 }
+
+func (s *UnTarGzTestSuite) TestRun_DryRun(c *C) {
+	dest := c.MkDir()
+
+	s.t.SetDefault(&haiconf.RuntimeConfig{DryRun: true, Output: ioutil.Discard})
+	err := s.t.SetUserConfig(haiconf.CommandArgs{
+		"Source": "./fixtures.tar.gz",
+		"Dest":   dest,
+	})
+	c.Assert(err, IsNil)
+
+	err = s.t.Run()
+	c.Assert(err, IsNil)
+
+	names, err := ioutil.ReadDir(dest)
+	c.Assert(err, IsNil)
+	c.Assert(names, HasLen, 0)
+}
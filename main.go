@@ -6,8 +6,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	lua "github.com/aarzilli/golua/lua"
 	"github.com/jeromer/haiconf/haiconf"
+	"github.com/jeromer/haiconf/haiconf/backup"
 	"github.com/jeromer/haiconf/haiconf/cron"
 	"github.com/jeromer/haiconf/haiconf/fs"
 	"github.com/jeromer/haiconf/haiconf/pkg"
@@ -17,16 +19,39 @@ import (
 	"github.com/stevedonovan/luar"
 	"log"
 	"os"
+	"time"
 )
 
 var (
 	flagConfigFile = flag.String("config", "./haiconf.lua", "Path to config file")
 	flagVerbose    = flag.Bool("verbose", true, "Verbose mode")
+	flagDryRun     = flag.Bool("dry-run", false, "Show what would be changed without changing anything")
+	flagBackupDir  = flag.String("backup-dir", backup.DEFAULT_DIR, "Where to store files before they are changed, haiconf stops when it can not be created. Empty to disable backups")
+
+	runId = fmt.Sprintf("%s-%d", time.Now().Format("20060102T150405"), os.Getpid())
 )
 
+// Usage:
+//
+//	haiconf [flags]
+//	haiconf [flags] backups list
+//	haiconf [flags] backups restore <id|path>
+//
+// Files are backed up in -backup-dir before they are overwritten or
+// deleted. Its default location requires root, non-root runs have to
+// choose another directory or disable backups with -backup-dir="".
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "backups" {
+		err := runBackups(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		return
+	}
+
 	conf := NewConf()
 	defer conf.Close()
 
@@ -57,14 +82,29 @@ func NewConf() *Conf {
 
 func (c *Conf) registerCommands() {
 	luar.Register(c.l, "", luar.Map{
-		"Directory": Directory,
-		"File":      File,
-		"AptGet":    AptGet,
-		"HttpGet":   HttpGet,
-		"TarGz":     TarGz,
-		"UnTarGz":   UnTarGz,
-		"Cron":      Cron,
-		"Group":     Group,
+		"Directory":           Directory,
+		"File":                File,
+		"Tree":                Tree,
+		"Link":                Link,
+		"LineInFile":          LineInFile,
+		"BlockInFile":         BlockInFile,
+		"IniSetting":          IniSetting,
+		"JsonSetting":         JsonSetting,
+		"YamlSetting":         YamlSetting,
+		"Tidy":                Tidy,
+		"Permissions":         Permissions,
+		"Host":                Host,
+		"Sysctl":              Sysctl,
+		"Mount":               Mount,
+		"EnvironmentVariable": EnvironmentVariable,
+		"AptRepository":       AptRepository,
+		"AptGet":              AptGet,
+		"InstalledPackages":   InstalledPackages,
+		"HttpGet":             HttpGet,
+		"TarGz":               TarGz,
+		"UnTarGz":             UnTarGz,
+		"Cron":                Cron,
+		"Group":               Group,
 	})
 }
 
@@ -95,10 +135,77 @@ func File(args haiconf.CommandArgs) {
 	runCommand(new(fs.File), args)
 }
 
+func Tree(args haiconf.CommandArgs) {
+	runCommand(new(fs.Tree), args)
+}
+
+func Link(args haiconf.CommandArgs) {
+	runCommand(new(fs.Link), args)
+}
+
+func LineInFile(args haiconf.CommandArgs) {
+	runCommand(new(fs.LineInFile), args)
+}
+
+func BlockInFile(args haiconf.CommandArgs) {
+	runCommand(new(fs.BlockInFile), args)
+}
+
+func IniSetting(args haiconf.CommandArgs) {
+	runCommand(new(fs.IniSetting), args)
+}
+
+func JsonSetting(args haiconf.CommandArgs) {
+	runCommand(new(fs.JsonSetting), args)
+}
+
+func YamlSetting(args haiconf.CommandArgs) {
+	runCommand(new(fs.YamlSetting), args)
+}
+
+func Tidy(args haiconf.CommandArgs) {
+	runCommand(new(fs.Tidy), args)
+}
+
+func Permissions(args haiconf.CommandArgs) {
+	runCommand(new(fs.Permissions), args)
+}
+
+func Host(args haiconf.CommandArgs) {
+	runCommand(new(fs.Host), args)
+}
+
+func Sysctl(args haiconf.CommandArgs) {
+	runCommand(new(fs.Sysctl), args)
+}
+
+func Mount(args haiconf.CommandArgs) {
+	runCommand(new(fs.Mount), args)
+}
+
+func EnvironmentVariable(args haiconf.CommandArgs) {
+	runCommand(new(fs.EnvironmentVariable), args)
+}
+
 func AptGet(args haiconf.CommandArgs) {
 	runCommand(new(pkg.AptGet), args)
 }
 
+func AptRepository(args haiconf.CommandArgs) {
+	runCommand(new(pkg.AptRepository), args)
+}
+
+// InstalledPackages returns the versions of the
+// installed packages by name
+func InstalledPackages() map[string]string {
+	versions, err := pkg.InstalledPackages(pkg.DPKG_STATUS)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return versions
+}
+
 func HttpGet(args haiconf.CommandArgs) {
 	runCommand(new(httpget.HttpGet), args)
 }
@@ -122,7 +229,11 @@ func Group(args haiconf.CommandArgs) {
 func runCommand(c haiconf.Commander, args haiconf.CommandArgs) {
 	rc := haiconf.RuntimeConfig{
 		Verbose: *flagVerbose,
+		DryRun:  *flagDryRun,
 		Output:  os.Stdout,
+
+		BackupDir: *flagBackupDir,
+		RunId:     runId,
 	}
 
 	err := c.SetDefault(&rc)
@@ -140,3 +251,37 @@ func runCommand(c haiconf.Commander, args haiconf.CommandArgs) {
 		log.Fatal(err.Error())
 	}
 }
+
+// -------------------
+
+func runBackups(args []string) error {
+	bucket := backup.NewBucket(*flagBackupDir, runId)
+
+	if len(args) == 1 && args[0] == "list" {
+		entries, err := bucket.List()
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			fmt.Printf(
+				"%s  %s  %s  %s  %d:%d  %s\n",
+				e.Id, e.Timestamp.Format(time.RFC3339), e.RunId, e.Mode, e.Uid, e.Gid, e.Path,
+			)
+		}
+
+		return nil
+	}
+
+	if len(args) == 2 && args[0] == "restore" {
+		e, err := bucket.Find(args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Restoring %s from backup %s taken at %s\n", e.Path, e.Id, e.Timestamp.Format(time.RFC3339))
+		return bucket.Restore(e)
+	}
+
+	return fmt.Errorf("Usage: haiconf backups list | haiconf backups restore <id|path>")
+}